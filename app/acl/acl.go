@@ -0,0 +1,70 @@
+package acl
+
+import "sync"
+
+type Operation int8
+
+const (
+	OpUnknown         = Operation(0)
+	OpAny             = Operation(1)
+	OpAll             = Operation(2)
+	OpRead            = Operation(3)
+	OpWrite           = Operation(4)
+	OpCreate          = Operation(5)
+	OpDelete          = Operation(6)
+	OpAlter           = Operation(7)
+	OpDescribe        = Operation(8)
+	OpClusterAction   = Operation(9)
+	OpDescribeConfigs = Operation(10)
+	OpAlterConfigs    = Operation(11)
+	OpIdempotentWrite = Operation(12)
+)
+
+const AnonymousPrincipal = "User:ANONYMOUS"
+
+// OmittedOperations is the sentinel sent when a client did not ask for
+// authorized operations.
+const OmittedOperations = int32(-2147483648)
+
+var topicOperations = []Operation{
+	OpRead, OpWrite, OpCreate, OpDelete, OpAlter,
+	OpDescribe, OpDescribeConfigs, OpAlterConfigs,
+}
+
+type Authorizer interface {
+	AuthorizeTopic(principal string, op Operation, topic string) bool
+}
+
+type AllowAll struct{}
+
+func (AllowAll) AuthorizeTopic(string, Operation, string) bool {
+	return true
+}
+
+var (
+	mu         sync.RWMutex
+	authorizer Authorizer = AllowAll{}
+)
+
+func SetAuthorizer(a Authorizer) {
+	mu.Lock()
+	defer mu.Unlock()
+	if a == nil {
+		a = AllowAll{}
+	}
+	authorizer = a
+}
+
+func TopicAuthorizedOperations(principal, topic string) int32 {
+	mu.RLock()
+	a := authorizer
+	mu.RUnlock()
+
+	var ops int32
+	for _, op := range topicOperations {
+		if a.AuthorizeTopic(principal, op, topic) {
+			ops |= 1 << uint(op)
+		}
+	}
+	return ops
+}
@@ -0,0 +1,156 @@
+// Package capture records the raw request and response frames of client
+// connections to files, so a bug report can carry the exact bytes a client
+// sent and the broker answered, and reads them back for replay.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// magic and version open every capture file.
+const (
+	magic   = "KCAP"
+	version = 1
+)
+
+var dir atomic.Pointer[string]
+
+// SetDir turns capturing on, one file per connection under path, or off
+// when path is empty.
+func SetDir(path string) {
+	if path == "" {
+		dir.Store(nil)
+		return
+	}
+	dir.Store(&path)
+}
+
+// Record is one captured frame, without its size prefix.
+type Record struct {
+	Response bool
+	Time     time.Time
+	Frame    []byte
+}
+
+// Writer appends one connection's frames to its capture file. A nil Writer,
+// as Open returns with capturing off, discards everything.
+type Writer struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// Open starts the capture file of a connection from remote. With capturing
+// off it returns a nil Writer.
+func Open(remote string) (*Writer, error) {
+	d := dir.Load()
+	if d == nil {
+		return nil, nil
+	}
+	if err := os.MkdirAll(*d, 0755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%d-%s.kcap", time.Now().UnixNano(), strings.NewReplacer(":", "_", "[", "", "]", "").Replace(remote))
+	f, err := os.Create(filepath.Join(*d, name))
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, w: bufio.NewWriter(f)}
+	w.w.WriteString(magic)
+	binary.Write(w.w, binary.BigEndian, uint16(version))
+	return w, nil
+}
+
+// Request records a request frame.
+func (w *Writer) Request(frame []byte) {
+	w.record(false, frame)
+}
+
+// Response records a response frame.
+func (w *Writer) Response(frame []byte) {
+	w.record(true, frame)
+}
+
+// record writes a direction byte, the time in Unix nanoseconds, the frame
+// length and the frame. Records are flushed as they are written, so a
+// capture survives the broker being killed.
+func (w *Writer) record(response bool, frame []byte) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var hdr [13]byte
+	if response {
+		hdr[0] = 1
+	}
+	binary.BigEndian.PutUint64(hdr[1:9], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(hdr[9:13], uint32(len(frame)))
+	w.w.Write(hdr[:])
+	w.w.Write(frame)
+	w.w.Flush()
+}
+
+// Close closes the capture file.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.w.Flush()
+	return w.f.Close()
+}
+
+// ReadFile reads every record of a capture file. A record cut short, as the
+// last one is when the broker dies mid-write, ends the capture.
+func ReadFile(path string) ([]Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(magic)+2 || string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%s is not a capture file", path)
+	}
+	if v := binary.BigEndian.Uint16(data[len(magic):]); v != version {
+		return nil, fmt.Errorf("unsupported capture version %d", v)
+	}
+
+	var records []Record
+	for off := len(magic) + 2; off+13 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[off+9:]))
+		if off+13+n > len(data) {
+			break
+		}
+		records = append(records, Record{
+			Response: data[off] == 1,
+			Time:     time.Unix(0, int64(binary.BigEndian.Uint64(data[off+1:]))),
+			Frame:    data[off+13 : off+13+n],
+		})
+		off += 13 + n
+	}
+	return records, nil
+}
+
+// ReadFrame reads one size-prefixed frame from r, returning it without the
+// prefix.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
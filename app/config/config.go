@@ -0,0 +1,116 @@
+// Package config resolves the broker's startup settings: where it listens,
+// where it keeps its logs and which node it is. Each comes from a
+// command-line flag when one is given, else from server.properties, else
+// from the package defaults.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+type Config struct {
+	// PropertiesPath is the server.properties file, empty when none was
+	// given.
+	PropertiesPath string
+	// Listeners is a listeners-style list; empty keeps the listener
+	// package's PLAINTEXT://0.0.0.0:9092.
+	Listeners string
+	LogDir    string
+	NodeID    int32
+	// Properties is every setting of the properties file, with the flags
+	// applied, once LoadProperties has run.
+	Properties map[string]string
+
+	// fromFlags names the settings given on the command line, which the
+	// properties file must not override.
+	fromFlags map[string]bool
+}
+
+// FromArgs reads the broker's command line: the -listeners, -log-dir and
+// -node-id flags and an optional server.properties path, which may come
+// before or after them.
+func FromArgs(args []string) (Config, error) {
+	cfg := Config{LogDir: partition.DefaultLogDir, NodeID: topic.DefaultNodeID, fromFlags: map[string]bool{}}
+
+	fs := flag.NewFlagSet("kafka", flag.ContinueOnError)
+	fs.StringVar(&cfg.Listeners, "listeners", cfg.Listeners, "listeners to bind, e.g. PLAINTEXT://0.0.0.0:9092")
+	fs.StringVar(&cfg.LogDir, "log-dir", cfg.LogDir, "directory holding the partition logs")
+	nodeID := fs.Int("node-id", int(cfg.NodeID), "this broker's node.id")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if rest := fs.Args(); len(rest) > 0 {
+		cfg.PropertiesPath = rest[0]
+		if err := fs.Parse(rest[1:]); err != nil {
+			return cfg, err
+		}
+		if fs.NArg() > 0 {
+			return cfg, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+		}
+	}
+	fs.Visit(func(f *flag.Flag) { cfg.fromFlags[f.Name] = true })
+
+	if *nodeID < 0 || *nodeID > 1<<31-1 {
+		return cfg, fmt.Errorf("invalid -node-id %d", *nodeID)
+	}
+	cfg.NodeID = int32(*nodeID)
+	if cfg.LogDir == "" {
+		return cfg, fmt.Errorf("-log-dir must not be empty")
+	}
+	return cfg, nil
+}
+
+// LoadProperties reads the properties file into Properties and fills in
+// listeners, log.dirs and node.id for the settings no flag gave. Flag values
+// are written back into Properties, so the packages configured from it see
+// them too. The broker keeps a single log dir, so only the first log.dirs
+// entry (or log.dir, without one) is used. Without a file Properties holds
+// just the flags.
+func (c *Config) LoadProperties() error {
+	var firstErr error
+	c.Properties = map[string]string{}
+	if c.PropertiesPath != "" {
+		if props, err := ReadProperties(c.PropertiesPath); err != nil {
+			firstErr = err
+		} else {
+			c.Properties = props
+		}
+	}
+	props := c.Properties
+
+	if v, ok := props["listeners"]; ok && !c.fromFlags["listeners"] {
+		c.Listeners = v
+	}
+	dirs := props["log.dirs"]
+	if dirs == "" {
+		dirs = props["log.dir"]
+	}
+	if dir, _, _ := strings.Cut(dirs, ","); strings.TrimSpace(dir) != "" && !c.fromFlags["log-dir"] {
+		c.LogDir = strings.TrimSpace(dir)
+	}
+	if v, ok := props["node.id"]; ok && !c.fromFlags["node-id"] {
+		if id, err := strconv.ParseInt(v, 10, 32); err == nil && id >= 0 {
+			c.NodeID = int32(id)
+		} else if firstErr == nil {
+			firstErr = fmt.Errorf("invalid node.id %q", v)
+		}
+	}
+
+	if c.fromFlags["listeners"] {
+		props["listeners"] = c.Listeners
+	}
+	if c.fromFlags["log-dir"] {
+		props["log.dirs"] = c.LogDir
+	}
+	if c.fromFlags["node-id"] {
+		props["node.id"] = strconv.Itoa(int(c.NodeID))
+	}
+	return firstErr
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadProperties reads a server.properties file.
+func ReadProperties(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseProperties(string(b)), nil
+}
+
+// ParseProperties reads the java.util.Properties text format Kafka's own
+// configs are written in. Lines starting with '#' or '!' are comments; the
+// key ends at the first unescaped '=', ':' or whitespace, and the value is
+// the rest of the line with leading separators and whitespace dropped. A
+// line ending in an odd number of backslashes continues on the next, whose
+// leading whitespace is skipped. Backslash escapes \t, \n, \r, \f and \uXXXX
+// are decoded; any other escaped character stands for itself. Later
+// duplicates win, as in Java. Unlike Java, unescaped trailing whitespace is
+// dropped too, as Kafka's config parsing would trim it anyway.
+func ParseProperties(text string) map[string]string {
+	props := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for continues(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		if continues(line) {
+			line = line[:len(line)-1]
+		}
+		key, value := splitProperty(line)
+		props[unescape(key)] = unescape(value)
+	}
+	return props
+}
+
+// continues reports whether line ends in an odd number of backslashes, the
+// last of which escapes the line break.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitProperty splits a logical line into its still-escaped key and value.
+func splitProperty(line string) (string, string) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+	key, rest := line[:end], line[end:]
+	rest = strings.TrimLeft(rest, " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	value := strings.TrimRight(rest, " \t\f")
+	if continues(value) {
+		value = rest[:len(value)+1]
+	}
+	return key, value
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if r, err := strconv.ParseUint(s[i+1:min(i+5, len(s))], 16, 16); err == nil && i+5 <= len(s) {
+				b.WriteRune(rune(r))
+				i += 4
+				continue
+			}
+			b.WriteByte('u')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
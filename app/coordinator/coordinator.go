@@ -0,0 +1,79 @@
+package coordinator
+
+import (
+	"strconv"
+	"sync/atomic"
+	"unicode/utf16"
+)
+
+const (
+	OffsetsTopicName     = "__consumer_offsets"
+	TransactionTopicName = "__transaction_state"
+
+	DefaultOffsetsTopicPartitions     = 50
+	DefaultTransactionTopicPartitions = 50
+)
+
+var (
+	offsetsPartitions     atomic.Int32
+	transactionPartitions atomic.Int32
+)
+
+func init() {
+	offsetsPartitions.Store(DefaultOffsetsTopicPartitions)
+	transactionPartitions.Store(DefaultTransactionTopicPartitions)
+}
+
+func OffsetsTopicPartitions() int32 {
+	return offsetsPartitions.Load()
+}
+
+func TransactionTopicPartitions() int32 {
+	return transactionPartitions.Load()
+}
+
+func SetOffsetsTopicPartitions(n int32) {
+	if n > 0 {
+		offsetsPartitions.Store(n)
+	}
+}
+
+func SetTransactionTopicPartitions(n int32) {
+	if n > 0 {
+		transactionPartitions.Store(n)
+	}
+}
+
+// LoadProperties applies offsets.topic.num.partitions and
+// transaction.state.log.num.partitions from the broker's properties.
+func LoadProperties(props map[string]string) {
+	if n, err := strconv.Atoi(props["offsets.topic.num.partitions"]); err == nil {
+		SetOffsetsTopicPartitions(int32(n))
+	}
+	if n, err := strconv.Atoi(props["transaction.state.log.num.partitions"]); err == nil {
+		SetTransactionTopicPartitions(int32(n))
+	}
+}
+
+// PartitionForGroup maps a group ID onto its __consumer_offsets partition
+// with the same hash the Java broker uses, so every broker and client agrees
+// on which partition (and therefore which coordinator) owns a group.
+func PartitionForGroup(groupID string) int32 {
+	return partitionFor(groupID, OffsetsTopicPartitions())
+}
+
+func PartitionForTransactionalID(transactionalID string) int32 {
+	return partitionFor(transactionalID, TransactionTopicPartitions())
+}
+
+func partitionFor(key string, partitions int32) int32 {
+	return (javaStringHash(key) & 0x7fffffff) % partitions
+}
+
+func javaStringHash(s string) int32 {
+	var h int32
+	for _, c := range utf16.Encode([]rune(s)) {
+		h = 31*h + int32(c)
+	}
+	return h
+}
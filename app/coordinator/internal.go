@@ -0,0 +1,95 @@
+package coordinator
+
+import (
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+// defaultInternalReplicationFactor mirrors offsets.topic.replication.factor;
+// it is capped at the number of live brokers so a single-node cluster can
+// still bootstrap its internal topics.
+const defaultInternalReplicationFactor = 3
+
+// EnsureOffsetsTopic returns the __consumer_offsets metadata, creating the
+// topic in the broker state the first time a coordinator lookup needs it.
+func EnsureOffsetsTopic(state *topic.BrokerState) (topic.Meta, error) {
+	return ensureInternalTopic(state, OffsetsTopicName, OffsetsTopicPartitions())
+}
+
+// EnsureTransactionTopic is EnsureOffsetsTopic for __transaction_state.
+func EnsureTransactionTopic(state *topic.BrokerState) (topic.Meta, error) {
+	return ensureInternalTopic(state, TransactionTopicName, TransactionTopicPartitions())
+}
+
+// ensureInternalTopic creates an internal topic the way CreateTopics creates
+// any other: directories first, then its TopicRecord and PartitionRecords in
+// the metadata log, then the broker state. A topic replayed from the log on
+// startup comes back without the internal flag and configs, which aren't
+// in the log; they are restored on first use, keeping its topic ID.
+func ensureInternalTopic(state *topic.BrokerState, name string, numPartitions int32) (topic.Meta, error) {
+	state.RLock()
+	meta, exists := state.Topics[name]
+	state.RUnlock()
+	if exists && meta.Internal {
+		return meta, nil
+	}
+
+	brokers := state.LiveBrokers()
+	replicationFactor := int16(min(defaultInternalReplicationFactor, len(brokers)))
+	assignments, err := topic.AssignReplicas(brokers, numPartitions, replicationFactor)
+	if err != nil {
+		return topic.Meta{}, err
+	}
+
+	state.Lock()
+	defer state.Unlock()
+
+	if meta, exists := state.Topics[name]; exists {
+		if !meta.Internal {
+			meta.Internal = true
+			if meta.Configs == nil {
+				meta.Configs = internalTopicConfigs()
+			}
+			state.SetTopic(name, meta)
+		}
+		return meta, nil
+	}
+
+	meta = topic.Meta{
+		ID:            state.NewTopicID(),
+		Partitions:    int(numPartitions),
+		PartitionInfo: make(map[int32]topic.PartitionMeta, numPartitions),
+		Configs:       internalTopicConfigs(),
+		Internal:      true,
+	}
+	for i, replicas := range assignments {
+		meta.PartitionInfo[i] = topic.PartitionMeta{
+			Index:    i,
+			Leader:   replicas[0],
+			Replicas: replicas,
+			ISR:      append([]int32(nil), replicas...),
+		}
+	}
+
+	for i := int32(0); i < numPartitions; i++ {
+		if err := partition.CreateLogDir(name, i); err != nil {
+			return topic.Meta{}, err
+		}
+	}
+	records := [][]byte{topic.TopicRecordValue(name, meta.ID)}
+	for i := int32(0); i < numPartitions; i++ {
+		records = append(records, topic.PartitionRecordValue(meta.ID, meta.PartitionInfo[i]))
+	}
+	if err := topic.AppendMetadataRecords(records...); err != nil {
+		return topic.Meta{}, err
+	}
+	state.SetTopic(name, meta)
+	return meta, nil
+}
+
+func internalTopicConfigs() map[string]string {
+	return map[string]string{
+		"cleanup.policy":   "compact",
+		"compression.type": "producer",
+	}
+}
@@ -0,0 +1,21 @@
+package coordinator
+
+import "github.com/codecrafters-io/kafka-starter-go/app/partition"
+
+// GroupLag returns, for each partition the group has committed an offset
+// for, how many records its log end offset is past that offset. Partitions
+// committed with no position (-1) are left out.
+func GroupLag(groupID string) (map[TopicPartition]int64, error) {
+	offsets, err := FetchOffsets(groupID)
+	if err != nil {
+		return nil, err
+	}
+	lag := make(map[TopicPartition]int64, len(offsets))
+	for tp, o := range offsets {
+		if o.Offset < 0 {
+			continue
+		}
+		lag[tp] = max(partition.GetOffsets(tp.Topic, tp.Partition).LogEndOffset-o.Offset, 0)
+	}
+	return lag, nil
+}
@@ -0,0 +1,213 @@
+package coordinator
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+)
+
+// MaxOffsetMetadataBytes mirrors offset.metadata.max.bytes.
+const MaxOffsetMetadataBytes = 4096
+
+// offsetsDir holds one checkpoint file per group. Its name has no
+// "-<partition>" suffix, so log dir scans never mistake it for a partition.
+func offsetsDir() string {
+	return filepath.Join(partition.LogDir, "__group_offsets")
+}
+
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+type CommittedOffset struct {
+	Offset      int64
+	LeaderEpoch int32
+	Metadata    string
+}
+
+var (
+	offsetsMu sync.Mutex
+	groups    = map[string]map[TopicPartition]CommittedOffset{}
+)
+
+// CommitOffsets records offsets for a group and persists the group's whole
+// offset table before returning, so a commit that was acknowledged survives a
+// restart.
+func CommitOffsets(groupID string, offsets map[TopicPartition]CommittedOffset) error {
+	offsetsMu.Lock()
+	defer offsetsMu.Unlock()
+
+	current, err := loadGroup(groupID)
+	if err != nil {
+		return err
+	}
+	next := make(map[TopicPartition]CommittedOffset, len(current)+len(offsets))
+	for tp, o := range current {
+		next[tp] = o
+	}
+	for tp, o := range offsets {
+		next[tp] = o
+	}
+	if err := writeGroup(groupID, next); err != nil {
+		return err
+	}
+	groups[groupID] = next
+	return nil
+}
+
+// FetchOffsets returns the group's committed offsets, reading them from disk
+// the first time the group is asked for.
+func FetchOffsets(groupID string) (map[TopicPartition]CommittedOffset, error) {
+	offsetsMu.Lock()
+	defer offsetsMu.Unlock()
+	return loadGroup(groupID)
+}
+
+// Groups returns the IDs of the groups that have committed offsets, sorted.
+func Groups() []string {
+	offsetsMu.Lock()
+	defer offsetsMu.Unlock()
+	ids := checkpointedGroups()
+	sort.Strings(ids)
+	return ids
+}
+
+// DeleteTopicOffsets drops every group's offsets for a deleted topic.
+func DeleteTopicOffsets(topicName string) {
+	offsetsMu.Lock()
+	defer offsetsMu.Unlock()
+
+	for _, groupID := range checkpointedGroups() {
+		current, err := loadGroup(groupID)
+		if err != nil {
+			continue
+		}
+		next := make(map[TopicPartition]CommittedOffset, len(current))
+		for tp, o := range current {
+			if tp.Topic != topicName {
+				next[tp] = o
+			}
+		}
+		if len(next) != len(current) && writeGroup(groupID, next) == nil {
+			groups[groupID] = next
+		}
+	}
+}
+
+// checkpointedGroups lists the groups with a checkpoint file, which every
+// group gets on its first commit. The caller must hold offsetsMu.
+func checkpointedGroups() []string {
+	entries, _ := os.ReadDir(offsetsDir())
+	var ids []string
+	for _, e := range entries {
+		escaped, ok := strings.CutSuffix(e.Name(), ".offsets")
+		if !ok {
+			continue
+		}
+		if groupID, err := url.PathUnescape(escaped); err == nil {
+			ids = append(ids, groupID)
+		}
+	}
+	return ids
+}
+
+func groupPath(groupID string) string {
+	return filepath.Join(offsetsDir(), url.PathEscape(groupID)+".offsets")
+}
+
+// loadGroup reads a group's checkpoint: a version line, an entry count, then
+// one `"topic" partition offset leaderEpoch "metadata"` line per partition.
+// The caller must hold offsetsMu.
+func loadGroup(groupID string) (map[TopicPartition]CommittedOffset, error) {
+	if g, ok := groups[groupID]; ok {
+		return g, nil
+	}
+
+	path := groupPath(groupID)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		// Not cached: any group ID a client names would otherwise stay
+		// in memory for good. The group is cached on its first commit.
+		return map[TopicPartition]CommittedOffset{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("malformed offsets checkpoint %s", path)
+	}
+	if v := strings.TrimSpace(lines[0]); v != "0" {
+		return nil, fmt.Errorf("unsupported offsets checkpoint version %s", v)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(lines[1]))
+	if err != nil || count != len(lines)-2 {
+		return nil, fmt.Errorf("malformed offsets checkpoint %s", path)
+	}
+
+	g := make(map[TopicPartition]CommittedOffset, count)
+	for _, line := range lines[2:] {
+		var tp TopicPartition
+		var o CommittedOffset
+		if _, err := fmt.Sscanf(line, "%q %d %d %d %q", &tp.Topic, &tp.Partition, &o.Offset, &o.LeaderEpoch, &o.Metadata); err != nil {
+			return nil, fmt.Errorf("malformed offsets entry %q: %v", line, err)
+		}
+		g[tp] = o
+	}
+	groups[groupID] = g
+	return g, nil
+}
+
+// writeGroup replaces a group's checkpoint through a rename, so a crash
+// leaves either the old table or the new one.
+func writeGroup(groupID string, g map[TopicPartition]CommittedOffset) error {
+	if err := os.MkdirAll(offsetsDir(), 0755); err != nil {
+		return err
+	}
+
+	keys := make([]TopicPartition, 0, len(g))
+	for tp := range g {
+		keys = append(keys, tp)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Topic != keys[j].Topic {
+			return keys[i].Topic < keys[j].Topic
+		}
+		return keys[i].Partition < keys[j].Partition
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "0\n%d\n", len(keys))
+	for _, tp := range keys {
+		o := g[tp]
+		fmt.Fprintf(&b, "%q %d %d %d %q\n", tp.Topic, tp.Partition, o.Offset, o.LeaderEpoch, o.Metadata)
+	}
+
+	path := groupPath(groupID)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
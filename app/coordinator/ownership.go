@@ -0,0 +1,91 @@
+package coordinator
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+// ownedPartitions holds the __consumer_offsets partitions this broker led at
+// the last SyncOwnership; nil until the first, when every group counts as
+// local. Guarded by offsetsMu.
+var ownedPartitions map[int32]bool
+
+// IsGroupCoordinator reports whether this broker leads the group's
+// __consumer_offsets partition and so coordinates the group. Until the topic
+// exists every group is coordinated here, as FindCoordinator would create
+// the topic with this broker as leader.
+func IsGroupCoordinator(state *topic.BrokerState, groupID string) bool {
+	state.RLock()
+	defer state.RUnlock()
+	meta, exists := state.Topics[OffsetsTopicName]
+	return !exists || meta.Partition(PartitionForGroup(groupID)).Leader == topic.NodeID()
+}
+
+// SyncOwnership loads and unloads group state as leadership of the
+// __consumer_offsets partitions moves; it runs whenever a new metadata image
+// is installed. Groups on partitions this broker no longer leads are dropped
+// from memory, so their offsets are never served stale should leadership
+// come back. Groups on partitions it took over are read from their
+// checkpoints up front rather than on their first request.
+func SyncOwnership(state *topic.BrokerState) {
+	owned := map[int32]bool{}
+	state.RLock()
+	meta, exists := state.Topics[OffsetsTopicName]
+	for p := int32(0); p < OffsetsTopicPartitions(); p++ {
+		if !exists || meta.Partition(p).Leader == topic.NodeID() {
+			owned[p] = true
+		}
+	}
+	state.RUnlock()
+
+	offsetsMu.Lock()
+	defer offsetsMu.Unlock()
+
+	var gained, lost int
+	for p := int32(0); p < OffsetsTopicPartitions(); p++ {
+		was := ownedPartitions == nil || ownedPartitions[p]
+		switch {
+		case owned[p] && !was:
+			gained++
+		case !owned[p] && was:
+			lost++
+		}
+	}
+	previous := ownedPartitions
+	ownedPartitions = owned
+	if gained == 0 && lost == 0 {
+		return
+	}
+	logger.Info("Group coordinator took over %d and gave up %d %s partitions", gained, lost, OffsetsTopicName)
+
+	for groupID := range groups {
+		if !owned[PartitionForGroup(groupID)] {
+			delete(groups, groupID)
+		}
+	}
+	if gained == 0 {
+		return
+	}
+	entries, _ := os.ReadDir(offsetsDir())
+	for _, e := range entries {
+		escaped, ok := strings.CutSuffix(e.Name(), ".offsets")
+		if !ok {
+			continue
+		}
+		groupID, err := url.PathUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		p := PartitionForGroup(groupID)
+		if !owned[p] || previous == nil || previous[p] {
+			continue
+		}
+		if _, err := loadGroup(groupID); err != nil {
+			logger.Warn("failed to load offsets of group %s: %v", groupID, err)
+		}
+	}
+}
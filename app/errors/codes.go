@@ -0,0 +1,299 @@
+package errors
+
+import "fmt"
+
+const (
+	ErrUnknownServerError                 = int16(-1)
+	ErrNone                               = int16(0)
+	ErrOffsetOutOfRange                   = int16(1)
+	ErrCorruptMessage                     = int16(2)
+	ErrUnknownTopicOrPartition            = int16(3)
+	ErrInvalidFetchSize                   = int16(4)
+	ErrLeaderNotAvailable                 = int16(5)
+	ErrNotLeaderOrFollower                = int16(6)
+	ErrRequestTimedOut                    = int16(7)
+	ErrBrokerNotAvailable                 = int16(8)
+	ErrReplicaNotAvailable                = int16(9)
+	ErrMessageTooLarge                    = int16(10)
+	ErrStaleControllerEpoch               = int16(11)
+	ErrOffsetMetadataTooLarge             = int16(12)
+	ErrNetworkException                   = int16(13)
+	ErrCoordinatorLoadInProgress          = int16(14)
+	ErrCoordinatorNotAvailable            = int16(15)
+	ErrNotCoordinator                     = int16(16)
+	ErrInvalidTopicException              = int16(17)
+	ErrRecordListTooLarge                 = int16(18)
+	ErrNotEnoughReplicas                  = int16(19)
+	ErrNotEnoughReplicasAfterAppend       = int16(20)
+	ErrInvalidRequiredAcks                = int16(21)
+	ErrIllegalGeneration                  = int16(22)
+	ErrInconsistentGroupProtocol          = int16(23)
+	ErrInvalidGroupID                     = int16(24)
+	ErrUnknownMemberID                    = int16(25)
+	ErrInvalidSessionTimeout              = int16(26)
+	ErrRebalanceInProgress                = int16(27)
+	ErrInvalidCommitOffsetSize            = int16(28)
+	ErrTopicAuthorizationFailed           = int16(29)
+	ErrGroupAuthorizationFailed           = int16(30)
+	ErrClusterAuthorizationFailed         = int16(31)
+	ErrInvalidTimestamp                   = int16(32)
+	ErrUnsupportedSASLMechanism           = int16(33)
+	ErrIllegalSASLState                   = int16(34)
+	ErrUnsupportedVersion                 = int16(35)
+	ErrTopicAlreadyExists                 = int16(36)
+	ErrInvalidPartitions                  = int16(37)
+	ErrInvalidReplicationFactor           = int16(38)
+	ErrInvalidReplicaAssignment           = int16(39)
+	ErrInvalidConfig                      = int16(40)
+	ErrNotController                      = int16(41)
+	ErrInvalidRequest                     = int16(42)
+	ErrUnsupportedForMessageFormat        = int16(43)
+	ErrPolicyViolation                    = int16(44)
+	ErrOutOfOrderSequenceNumber           = int16(45)
+	ErrDuplicateSequenceNumber            = int16(46)
+	ErrInvalidProducerEpoch               = int16(47)
+	ErrInvalidTxnState                    = int16(48)
+	ErrInvalidProducerIDMapping           = int16(49)
+	ErrInvalidTransactionTimeout          = int16(50)
+	ErrConcurrentTransactions             = int16(51)
+	ErrTransactionCoordinatorFenced       = int16(52)
+	ErrTransactionalIDAuthorizationFailed = int16(53)
+	ErrSecurityDisabled                   = int16(54)
+	ErrOperationNotAttempted              = int16(55)
+	ErrKafkaStorageError                  = int16(56)
+	ErrLogDirNotFound                     = int16(57)
+	ErrSASLAuthenticationFailed           = int16(58)
+	ErrUnknownProducerID                  = int16(59)
+	ErrReassignmentInProgress             = int16(60)
+	ErrDelegationTokenAuthDisabled        = int16(61)
+	ErrDelegationTokenNotFound            = int16(62)
+	ErrDelegationTokenOwnerMismatch       = int16(63)
+	ErrDelegationTokenRequestNotAllowed   = int16(64)
+	ErrDelegationTokenAuthorizationFailed = int16(65)
+	ErrDelegationTokenExpired             = int16(66)
+	ErrInvalidPrincipalType               = int16(67)
+	ErrNonEmptyGroup                      = int16(68)
+	ErrGroupIDNotFound                    = int16(69)
+	ErrFetchSessionIDNotFound             = int16(70)
+	ErrInvalidFetchSessionEpoch           = int16(71)
+	ErrListenerNotFound                   = int16(72)
+	ErrTopicDeletionDisabled              = int16(73)
+	ErrFencedLeaderEpoch                  = int16(74)
+	ErrUnknownLeaderEpoch                 = int16(75)
+	ErrUnsupportedCompressionType         = int16(76)
+	ErrStaleBrokerEpoch                   = int16(77)
+	ErrOffsetNotAvailable                 = int16(78)
+	ErrMemberIDRequired                   = int16(79)
+	ErrPreferredLeaderNotAvailable        = int16(80)
+	ErrGroupMaxSizeReached                = int16(81)
+	ErrFencedInstanceID                   = int16(82)
+	ErrEligibleLeadersNotAvailable        = int16(83)
+	ErrElectionNotNeeded                  = int16(84)
+	ErrNoReassignmentInProgress           = int16(85)
+	ErrGroupSubscribedToTopic             = int16(86)
+	ErrInvalidRecord                      = int16(87)
+	ErrUnstableOffsetCommit               = int16(88)
+	ErrThrottlingQuotaExceeded            = int16(89)
+	ErrProducerFenced                     = int16(90)
+	ErrResourceNotFound                   = int16(91)
+	ErrDuplicateResource                  = int16(92)
+	ErrUnacceptableCredential             = int16(93)
+	ErrInconsistentVoterSet               = int16(94)
+	ErrInvalidUpdateVersion               = int16(95)
+	ErrFeatureUpdateFailed                = int16(96)
+	ErrPrincipalDeserializationFailure    = int16(97)
+	ErrSnapshotNotFound                   = int16(98)
+	ErrPositionOutOfRange                 = int16(99)
+	ErrUnknownTopicID                     = int16(100)
+	ErrDuplicateBrokerRegistration        = int16(101)
+	ErrBrokerIDNotRegistered              = int16(102)
+	ErrInconsistentTopicID                = int16(103)
+	ErrInconsistentClusterID              = int16(104)
+	ErrTransactionalIDNotFound            = int16(105)
+	ErrFetchSessionTopicIDError           = int16(106)
+	ErrIneligibleReplica                  = int16(107)
+	ErrNewLeaderElected                   = int16(108)
+	ErrOffsetMovedToTieredStorage         = int16(109)
+	ErrFencedMemberEpoch                  = int16(110)
+	ErrUnreleasedInstanceID               = int16(111)
+	ErrUnsupportedAssignor                = int16(112)
+	ErrStaleMemberEpoch                   = int16(113)
+	ErrMismatchedEndpointType             = int16(114)
+	ErrUnsupportedEndpointType            = int16(115)
+	ErrUnknownControllerID                = int16(116)
+	ErrUnknownSubscriptionID              = int16(117)
+	ErrTelemetryTooLarge                  = int16(118)
+	ErrInvalidRegistration                = int16(119)
+	ErrTransactionAbortable               = int16(120)
+	ErrInvalidRecordState                 = int16(121)
+	ErrShareSessionNotFound               = int16(122)
+	ErrInvalidShareSessionEpoch           = int16(123)
+	ErrFencedStateEpoch                   = int16(124)
+	ErrInvalidVoterKey                    = int16(125)
+	ErrDuplicateVoter                     = int16(126)
+	ErrVoterNotFound                      = int16(127)
+)
+
+type codeInfo struct {
+	name      string
+	retriable bool
+}
+
+// catalog mirrors the broker's error table: the wire name of each code and
+// whether clients treat it as retriable.
+var catalog = map[int16]codeInfo{
+	ErrUnknownServerError:                 {"UNKNOWN_SERVER_ERROR", false},
+	ErrNone:                               {"NONE", false},
+	ErrOffsetOutOfRange:                   {"OFFSET_OUT_OF_RANGE", false},
+	ErrCorruptMessage:                     {"CORRUPT_MESSAGE", true},
+	ErrUnknownTopicOrPartition:            {"UNKNOWN_TOPIC_OR_PARTITION", true},
+	ErrInvalidFetchSize:                   {"INVALID_FETCH_SIZE", false},
+	ErrLeaderNotAvailable:                 {"LEADER_NOT_AVAILABLE", true},
+	ErrNotLeaderOrFollower:                {"NOT_LEADER_OR_FOLLOWER", true},
+	ErrRequestTimedOut:                    {"REQUEST_TIMED_OUT", true},
+	ErrBrokerNotAvailable:                 {"BROKER_NOT_AVAILABLE", false},
+	ErrReplicaNotAvailable:                {"REPLICA_NOT_AVAILABLE", true},
+	ErrMessageTooLarge:                    {"MESSAGE_TOO_LARGE", false},
+	ErrStaleControllerEpoch:               {"STALE_CONTROLLER_EPOCH", false},
+	ErrOffsetMetadataTooLarge:             {"OFFSET_METADATA_TOO_LARGE", false},
+	ErrNetworkException:                   {"NETWORK_EXCEPTION", true},
+	ErrCoordinatorLoadInProgress:          {"COORDINATOR_LOAD_IN_PROGRESS", true},
+	ErrCoordinatorNotAvailable:            {"COORDINATOR_NOT_AVAILABLE", true},
+	ErrNotCoordinator:                     {"NOT_COORDINATOR", true},
+	ErrInvalidTopicException:              {"INVALID_TOPIC_EXCEPTION", false},
+	ErrRecordListTooLarge:                 {"RECORD_LIST_TOO_LARGE", false},
+	ErrNotEnoughReplicas:                  {"NOT_ENOUGH_REPLICAS", true},
+	ErrNotEnoughReplicasAfterAppend:       {"NOT_ENOUGH_REPLICAS_AFTER_APPEND", true},
+	ErrInvalidRequiredAcks:                {"INVALID_REQUIRED_ACKS", false},
+	ErrIllegalGeneration:                  {"ILLEGAL_GENERATION", false},
+	ErrInconsistentGroupProtocol:          {"INCONSISTENT_GROUP_PROTOCOL", false},
+	ErrInvalidGroupID:                     {"INVALID_GROUP_ID", false},
+	ErrUnknownMemberID:                    {"UNKNOWN_MEMBER_ID", false},
+	ErrInvalidSessionTimeout:              {"INVALID_SESSION_TIMEOUT", false},
+	ErrRebalanceInProgress:                {"REBALANCE_IN_PROGRESS", false},
+	ErrInvalidCommitOffsetSize:            {"INVALID_COMMIT_OFFSET_SIZE", false},
+	ErrTopicAuthorizationFailed:           {"TOPIC_AUTHORIZATION_FAILED", false},
+	ErrGroupAuthorizationFailed:           {"GROUP_AUTHORIZATION_FAILED", false},
+	ErrClusterAuthorizationFailed:         {"CLUSTER_AUTHORIZATION_FAILED", false},
+	ErrInvalidTimestamp:                   {"INVALID_TIMESTAMP", false},
+	ErrUnsupportedSASLMechanism:           {"UNSUPPORTED_SASL_MECHANISM", false},
+	ErrIllegalSASLState:                   {"ILLEGAL_SASL_STATE", false},
+	ErrUnsupportedVersion:                 {"UNSUPPORTED_VERSION", false},
+	ErrTopicAlreadyExists:                 {"TOPIC_ALREADY_EXISTS", false},
+	ErrInvalidPartitions:                  {"INVALID_PARTITIONS", false},
+	ErrInvalidReplicationFactor:           {"INVALID_REPLICATION_FACTOR", false},
+	ErrInvalidReplicaAssignment:           {"INVALID_REPLICA_ASSIGNMENT", false},
+	ErrInvalidConfig:                      {"INVALID_CONFIG", false},
+	ErrNotController:                      {"NOT_CONTROLLER", true},
+	ErrInvalidRequest:                     {"INVALID_REQUEST", false},
+	ErrUnsupportedForMessageFormat:        {"UNSUPPORTED_FOR_MESSAGE_FORMAT", false},
+	ErrPolicyViolation:                    {"POLICY_VIOLATION", false},
+	ErrOutOfOrderSequenceNumber:           {"OUT_OF_ORDER_SEQUENCE_NUMBER", false},
+	ErrDuplicateSequenceNumber:            {"DUPLICATE_SEQUENCE_NUMBER", false},
+	ErrInvalidProducerEpoch:               {"INVALID_PRODUCER_EPOCH", false},
+	ErrInvalidTxnState:                    {"INVALID_TXN_STATE", false},
+	ErrInvalidProducerIDMapping:           {"INVALID_PRODUCER_ID_MAPPING", false},
+	ErrInvalidTransactionTimeout:          {"INVALID_TRANSACTION_TIMEOUT", false},
+	ErrConcurrentTransactions:             {"CONCURRENT_TRANSACTIONS", true},
+	ErrTransactionCoordinatorFenced:       {"TRANSACTION_COORDINATOR_FENCED", false},
+	ErrTransactionalIDAuthorizationFailed: {"TRANSACTIONAL_ID_AUTHORIZATION_FAILED", false},
+	ErrSecurityDisabled:                   {"SECURITY_DISABLED", false},
+	ErrOperationNotAttempted:              {"OPERATION_NOT_ATTEMPTED", false},
+	ErrKafkaStorageError:                  {"KAFKA_STORAGE_ERROR", true},
+	ErrLogDirNotFound:                     {"LOG_DIR_NOT_FOUND", false},
+	ErrSASLAuthenticationFailed:           {"SASL_AUTHENTICATION_FAILED", false},
+	ErrUnknownProducerID:                  {"UNKNOWN_PRODUCER_ID", false},
+	ErrReassignmentInProgress:             {"REASSIGNMENT_IN_PROGRESS", false},
+	ErrDelegationTokenAuthDisabled:        {"DELEGATION_TOKEN_AUTH_DISABLED", false},
+	ErrDelegationTokenNotFound:            {"DELEGATION_TOKEN_NOT_FOUND", false},
+	ErrDelegationTokenOwnerMismatch:       {"DELEGATION_TOKEN_OWNER_MISMATCH", false},
+	ErrDelegationTokenRequestNotAllowed:   {"DELEGATION_TOKEN_REQUEST_NOT_ALLOWED", false},
+	ErrDelegationTokenAuthorizationFailed: {"DELEGATION_TOKEN_AUTHORIZATION_FAILED", false},
+	ErrDelegationTokenExpired:             {"DELEGATION_TOKEN_EXPIRED", false},
+	ErrInvalidPrincipalType:               {"INVALID_PRINCIPAL_TYPE", false},
+	ErrNonEmptyGroup:                      {"NON_EMPTY_GROUP", false},
+	ErrGroupIDNotFound:                    {"GROUP_ID_NOT_FOUND", false},
+	ErrFetchSessionIDNotFound:             {"FETCH_SESSION_ID_NOT_FOUND", true},
+	ErrInvalidFetchSessionEpoch:           {"INVALID_FETCH_SESSION_EPOCH", true},
+	ErrListenerNotFound:                   {"LISTENER_NOT_FOUND", true},
+	ErrTopicDeletionDisabled:              {"TOPIC_DELETION_DISABLED", false},
+	ErrFencedLeaderEpoch:                  {"FENCED_LEADER_EPOCH", true},
+	ErrUnknownLeaderEpoch:                 {"UNKNOWN_LEADER_EPOCH", true},
+	ErrUnsupportedCompressionType:         {"UNSUPPORTED_COMPRESSION_TYPE", false},
+	ErrStaleBrokerEpoch:                   {"STALE_BROKER_EPOCH", false},
+	ErrOffsetNotAvailable:                 {"OFFSET_NOT_AVAILABLE", true},
+	ErrMemberIDRequired:                   {"MEMBER_ID_REQUIRED", false},
+	ErrPreferredLeaderNotAvailable:        {"PREFERRED_LEADER_NOT_AVAILABLE", true},
+	ErrGroupMaxSizeReached:                {"GROUP_MAX_SIZE_REACHED", false},
+	ErrFencedInstanceID:                   {"FENCED_INSTANCE_ID", false},
+	ErrEligibleLeadersNotAvailable:        {"ELIGIBLE_LEADERS_NOT_AVAILABLE", true},
+	ErrElectionNotNeeded:                  {"ELECTION_NOT_NEEDED", true},
+	ErrNoReassignmentInProgress:           {"NO_REASSIGNMENT_IN_PROGRESS", false},
+	ErrGroupSubscribedToTopic:             {"GROUP_SUBSCRIBED_TO_TOPIC", false},
+	ErrInvalidRecord:                      {"INVALID_RECORD", false},
+	ErrUnstableOffsetCommit:               {"UNSTABLE_OFFSET_COMMIT", true},
+	ErrThrottlingQuotaExceeded:            {"THROTTLING_QUOTA_EXCEEDED", true},
+	ErrProducerFenced:                     {"PRODUCER_FENCED", false},
+	ErrResourceNotFound:                   {"RESOURCE_NOT_FOUND", false},
+	ErrDuplicateResource:                  {"DUPLICATE_RESOURCE", false},
+	ErrUnacceptableCredential:             {"UNACCEPTABLE_CREDENTIAL", false},
+	ErrInconsistentVoterSet:               {"INCONSISTENT_VOTER_SET", false},
+	ErrInvalidUpdateVersion:               {"INVALID_UPDATE_VERSION", false},
+	ErrFeatureUpdateFailed:                {"FEATURE_UPDATE_FAILED", false},
+	ErrPrincipalDeserializationFailure:    {"PRINCIPAL_DESERIALIZATION_FAILURE", false},
+	ErrSnapshotNotFound:                   {"SNAPSHOT_NOT_FOUND", false},
+	ErrPositionOutOfRange:                 {"POSITION_OUT_OF_RANGE", false},
+	ErrUnknownTopicID:                     {"UNKNOWN_TOPIC_ID", true},
+	ErrDuplicateBrokerRegistration:        {"DUPLICATE_BROKER_REGISTRATION", false},
+	ErrBrokerIDNotRegistered:              {"BROKER_ID_NOT_REGISTERED", false},
+	ErrInconsistentTopicID:                {"INCONSISTENT_TOPIC_ID", true},
+	ErrInconsistentClusterID:              {"INCONSISTENT_CLUSTER_ID", false},
+	ErrTransactionalIDNotFound:            {"TRANSACTIONAL_ID_NOT_FOUND", false},
+	ErrFetchSessionTopicIDError:           {"FETCH_SESSION_TOPIC_ID_ERROR", true},
+	ErrIneligibleReplica:                  {"INELIGIBLE_REPLICA", false},
+	ErrNewLeaderElected:                   {"NEW_LEADER_ELECTED", false},
+	ErrOffsetMovedToTieredStorage:         {"OFFSET_MOVED_TO_TIERED_STORAGE", false},
+	ErrFencedMemberEpoch:                  {"FENCED_MEMBER_EPOCH", false},
+	ErrUnreleasedInstanceID:               {"UNRELEASED_INSTANCE_ID", false},
+	ErrUnsupportedAssignor:                {"UNSUPPORTED_ASSIGNOR", false},
+	ErrStaleMemberEpoch:                   {"STALE_MEMBER_EPOCH", false},
+	ErrMismatchedEndpointType:             {"MISMATCHED_ENDPOINT_TYPE", false},
+	ErrUnsupportedEndpointType:            {"UNSUPPORTED_ENDPOINT_TYPE", false},
+	ErrUnknownControllerID:                {"UNKNOWN_CONTROLLER_ID", false},
+	ErrUnknownSubscriptionID:              {"UNKNOWN_SUBSCRIPTION_ID", false},
+	ErrTelemetryTooLarge:                  {"TELEMETRY_TOO_LARGE", false},
+	ErrInvalidRegistration:                {"INVALID_REGISTRATION", false},
+	ErrTransactionAbortable:               {"TRANSACTION_ABORTABLE", false},
+	ErrInvalidRecordState:                 {"INVALID_RECORD_STATE", false},
+	ErrShareSessionNotFound:               {"SHARE_SESSION_NOT_FOUND", true},
+	ErrInvalidShareSessionEpoch:           {"INVALID_SHARE_SESSION_EPOCH", true},
+	ErrFencedStateEpoch:                   {"FENCED_STATE_EPOCH", false},
+	ErrInvalidVoterKey:                    {"INVALID_VOTER_KEY", false},
+	ErrDuplicateVoter:                     {"DUPLICATE_VOTER", false},
+	ErrVoterNotFound:                      {"VOTER_NOT_FOUND", false},
+}
+
+// Name returns the protocol name of code, e.g. "UNKNOWN_TOPIC_OR_PARTITION".
+func Name(code int16) string {
+	if info, ok := catalog[code]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("UNKNOWN_ERROR_CODE_%d", code)
+}
+
+// CodeByName returns the code with the protocol name name, e.g.
+// "NOT_ENOUGH_REPLICAS".
+func CodeByName(name string) (int16, bool) {
+	for code, info := range catalog {
+		if info.name == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// Retriable reports whether clients are expected to retry a request that
+// failed with code.
+func Retriable(code int16) bool {
+	return catalog[code].retriable
+}
@@ -1,12 +1,9 @@
 package errors
 
-import "fmt"
-
-const (
-	ErrNone                    = int16(0)
-	ErrUnknownTopicOrPartition = int16(3)
-	ErrUnsupportedVersion      = int16(35)
-	ErrUnknownTopicID          = int16(100)
+import (
+	stderrors "errors"
+	"fmt"
+	"io/fs"
 )
 
 type KafkaError struct {
@@ -15,9 +12,59 @@ type KafkaError struct {
 }
 
 func (e *KafkaError) Error() string {
-	return fmt.Sprintf("kafka error %d: %s", e.Code, e.Message)
+	return fmt.Sprintf("%s: %s", Name(e.Code), e.Message)
+}
+
+func (e *KafkaError) Retriable() bool {
+	return Retriable(e.Code)
 }
 
 func NewKafkaError(code int16, message string) *KafkaError {
 	return &KafkaError{Code: code, Message: message}
 }
+
+func Newf(code int16, format string, args ...any) *KafkaError {
+	return &KafkaError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// From converts any error into a KafkaError. Errors that are already Kafka
+// errors pass through; filesystem errors become KAFKA_STORAGE_ERROR and
+// everything else UNKNOWN_SERVER_ERROR.
+func From(err error) *KafkaError {
+	if err == nil {
+		return nil
+	}
+	var kerr *KafkaError
+	if stderrors.As(err, &kerr) {
+		return kerr
+	}
+	var perr *fs.PathError
+	if stderrors.As(err, &perr) {
+		return NewKafkaError(ErrKafkaStorageError, err.Error())
+	}
+	return NewKafkaError(ErrUnknownServerError, err.Error())
+}
+
+func CodeOf(err error) int16 {
+	if err == nil {
+		return ErrNone
+	}
+	var kerr *KafkaError
+	if stderrors.As(err, &kerr) {
+		return kerr.Code
+	}
+	return ErrInvalidRequest
+}
+
+// MessageOf returns the client-facing message for err, without the error
+// name prefix that Error adds.
+func MessageOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	var kerr *KafkaError
+	if stderrors.As(err, &kerr) {
+		return kerr.Message
+	}
+	return err.Error()
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"encoding/binary"
+
 	"github.com/codecrafters-io/kafka-starter-go/app/errors"
 	"github.com/codecrafters-io/kafka-starter-go/app/parser"
 )
@@ -8,7 +10,15 @@ import (
 const (
 	APIKeyProduce            = int16(0)
 	APIKeyFetch              = int16(1)
+	APIKeyListOffsets        = int16(2)
+	APIKeyMetadata           = int16(3)
+	APIKeyOffsetCommit       = int16(8)
+	APIKeyOffsetFetch        = int16(9)
+	APIKeyFindCoordinator    = int16(10)
 	APIKeyApiVersions        = int16(18)
+	APIKeyCreateTopics       = int16(19)
+	APIKeyDeleteTopics       = int16(20)
+	APIKeyCreatePartitions   = int16(37)
 	APIKeyDescribeTopicParts = int16(75)
 )
 
@@ -22,34 +32,33 @@ func BuildSimpleError(corrID int32, errorCode int16) []byte {
 	return frameResponse(header, body)
 }
 
-func BuildApiVersionsV4Body(corrID int32) []byte {
+// APIVersionRange is one entry of the ApiVersions response.
+type APIVersionRange struct {
+	APIKey     int16
+	MinVersion int16
+	MaxVersion int16
+}
+
+// BuildApiVersions answers ApiVersions v0 through v4 with the given APIs. The
+// response header is always v0; the body turns flexible at v3 and carries
+// throttle_time_ms from v1.
+func BuildApiVersions(corrID int32, apiVersion int16, apis []APIVersionRange) []byte {
+	flexible := apiVersion >= 3
 	header := parser.AppendInt32(nil, corrID)
 
 	body := parser.AppendInt16(nil, errors.ErrNone)
-	body = parser.AppendUVarInt(body, 5)
-
-	body = parser.AppendInt16(body, APIKeyProduce)
-	body = parser.AppendInt16(body, 0)
-	body = parser.AppendInt16(body, 11)
-	body = parser.AppendUVarInt(body, 0)
-
-	body = parser.AppendInt16(body, APIKeyFetch)
-	body = parser.AppendInt16(body, 0)
-	body = parser.AppendInt16(body, 16)
-	body = parser.AppendUVarInt(body, 0)
-
-	body = parser.AppendInt16(body, APIKeyApiVersions)
-	body = parser.AppendInt16(body, 0)
-	body = parser.AppendInt16(body, 4)
-	body = parser.AppendUVarInt(body, 0)
+	body = appendArrayLen(body, len(apis), flexible)
+	for _, api := range apis {
+		body = parser.AppendInt16(body, api.APIKey)
+		body = parser.AppendInt16(body, api.MinVersion)
+		body = parser.AppendInt16(body, api.MaxVersion)
+		body = appendTaggedFields(body, flexible)
+	}
 
-	body = parser.AppendInt16(body, APIKeyDescribeTopicParts)
-	body = parser.AppendInt16(body, 0)
-	body = parser.AppendInt16(body, 0)
-	body = parser.AppendUVarInt(body, 0)
-
-	body = parser.AppendInt32(body, 0)
-	body = parser.AppendUVarInt(body, 0)
+	if apiVersion >= 1 {
+		body = parser.AppendInt32(body, 0)
+	}
+	body = appendTaggedFields(body, flexible)
 
 	return frameResponse(header, body)
 }
@@ -62,3 +71,17 @@ func frameResponse(header, body []byte) []byte {
 	out = append(out, body...)
 	return out
 }
+
+// beginResponse starts a response frame holding header, with room for a body
+// of about bodySize bytes. A handler with a large body appends it to the
+// frame in place, rather than building it apart for frameResponse to copy,
+// and then calls endResponse to fill in the size.
+func beginResponse(header []byte, bodySize int) []byte {
+	out := make([]byte, 4, 4+len(header)+bodySize)
+	return append(out, header...)
+}
+
+func endResponse(out []byte) []byte {
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	return out
+}
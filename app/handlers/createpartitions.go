@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+type CreatePartitionsRequest struct {
+	Name        string
+	Count       int32
+	Assignments [][]int32
+}
+
+// HandleCreatePartitions serves the flexible CreatePartitions versions (v2
+// and v3).
+func HandleCreatePartitions(corrID int32, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	topicRequests, validateOnly, parseErr := parseCreatePartitionsRequest(reqBody)
+	if parseErr != nil && len(topicRequests) == 0 {
+		return nil, errors.From(parseErr)
+	}
+
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	body := parser.AppendInt32(nil, 0)
+	body = parser.AppendUVarInt(body, uint32(len(topicRequests)+1))
+
+	seen := make(map[string]int, len(topicRequests))
+	for _, req := range topicRequests {
+		seen[req.Name]++
+	}
+
+	for _, req := range topicRequests {
+		errorCode, errorMessage := errors.ErrNone, ""
+		switch {
+		case parseErr != nil:
+			errorCode, errorMessage = errors.CodeOf(parseErr), errors.MessageOf(parseErr)
+		case seen[req.Name] > 1:
+			errorCode, errorMessage = errors.ErrInvalidRequest, fmt.Sprintf("Duplicate topic %q in request", req.Name)
+		case quota.MutationThrottleMs(c.ClientID) > 0:
+			errorCode, errorMessage = errors.ErrThrottlingQuotaExceeded, throttlingQuotaExceededMessage
+		default:
+			errorCode, errorMessage = createPartitions(c, req, validateOnly)
+		}
+
+		body = parser.AppendCompactString(body, req.Name)
+		body = parser.AppendInt16(body, errorCode)
+		body = parser.AppendCompactNullableString(body, errorMessage, errorCode == errors.ErrNone)
+		body = parser.AppendUVarInt(body, 0)
+	}
+
+	body = parser.AppendUVarInt(body, 0)
+
+	throttleMs := quota.MutationThrottleMs(c.ClientID)
+	c.Throttle(throttleMs)
+	binary.BigEndian.PutUint32(body[0:4], uint32(throttleMs))
+
+	return frameResponse(header, body), nil
+}
+
+// createPartitions grows a topic in two steps so the new partitions are never
+// advertised before they can take writes: the log directories are created
+// first, then the topic metadata is swapped in under the state lock. Produce
+// and DescribeTopicPartitions only look partitions up under that lock, so
+// they see either the old partition count or the new one with its
+// directories already in place.
+func createPartitions(c *session.Connection, req CreatePartitionsRequest, validateOnly bool) (int16, string) {
+	state := c.State
+
+	state.RLock()
+	meta, exists := state.Topics[req.Name]
+	state.RUnlock()
+	if !exists {
+		return errors.ErrUnknownTopicOrPartition, fmt.Sprintf("The topic '%s' does not exist.", req.Name)
+	}
+
+	current := int32(max(meta.Partitions, 1))
+	if req.Count < current {
+		return errors.ErrInvalidPartitions, fmt.Sprintf("Topic currently has %d partitions, which is higher than the requested %d.", current, req.Count)
+	}
+	if req.Count == current {
+		return errors.ErrInvalidPartitions, fmt.Sprintf("Topic already has %d partitions.", current)
+	}
+	if req.Count > maxCreatePartitions {
+		return errors.ErrPolicyViolation, fmt.Sprintf("Number of partitions %d exceeds the limit of %d.", req.Count, maxCreatePartitions)
+	}
+
+	added, err := newPartitionAssignments(state, meta, req)
+	if err != nil {
+		return errors.ErrInvalidReplicaAssignment, err.Error()
+	}
+	if validateOnly {
+		return errors.ErrNone, ""
+	}
+
+	for idx := range added {
+		if err := partition.CreateLogDir(req.Name, idx); err != nil {
+			logger.Warn("%s: creating %s-%d failed: %v", c, req.Name, idx, err)
+			kerr := errors.From(err)
+			return kerr.Code, kerr.Message
+		}
+	}
+
+	state.Lock()
+	defer state.Unlock()
+
+	meta, exists = state.Topics[req.Name]
+	if !exists {
+		return errors.ErrUnknownTopicOrPartition, fmt.Sprintf("The topic '%s' does not exist.", req.Name)
+	}
+	if int32(max(meta.Partitions, 1)) != current {
+		return errors.ErrInvalidPartitions, fmt.Sprintf("Topic currently has %d partitions, which is higher than the requested %d.", meta.Partitions, req.Count)
+	}
+
+	info := make(map[int32]topic.PartitionMeta, req.Count)
+	for idx := int32(0); idx < current; idx++ {
+		info[idx] = meta.Partition(idx)
+	}
+	for idx, replicas := range added {
+		info[idx] = topic.PartitionMeta{
+			Index:    idx,
+			Leader:   replicas[0],
+			Replicas: replicas,
+			ISR:      append([]int32(nil), replicas...),
+		}
+	}
+
+	var records [][]byte
+	for idx := current; idx < req.Count; idx++ {
+		records = append(records, topic.PartitionRecordValue(meta.ID, info[idx]))
+	}
+	if err := topic.AppendMetadataRecords(records...); err != nil {
+		logger.Warn("%s: recording partitions of %s failed: %v", c, req.Name, err)
+		kerr := errors.From(err)
+		return kerr.Code, kerr.Message
+	}
+
+	meta.PartitionInfo = info
+	meta.Partitions = int(req.Count)
+	state.SetTopic(req.Name, meta)
+	quota.RecordMutations(c.ClientID, len(added))
+
+	return errors.ErrNone, ""
+}
+
+func newPartitionAssignments(state *topic.BrokerState, meta topic.Meta, req CreatePartitionsRequest) (map[int32][]int32, error) {
+	brokers := state.LiveBrokers()
+	current := int32(max(meta.Partitions, 1))
+	newCount := req.Count - current
+
+	if req.Assignments != nil {
+		if int32(len(req.Assignments)) != newCount {
+			return nil, fmt.Errorf("Increasing the number of partitions by %d but %d assignments provided.", newCount, len(req.Assignments))
+		}
+		manual := make(map[int32][]int32, newCount)
+		for i, replicas := range req.Assignments {
+			manual[int32(i)] = replicas
+		}
+		if err := topic.ValidateAssignments(brokers, manual); err != nil {
+			return nil, err
+		}
+		added := make(map[int32][]int32, newCount)
+		for i, replicas := range manual {
+			added[current+i] = replicas
+		}
+		return added, nil
+	}
+
+	replicationFactor := int16(len(meta.Partition(0).Replicas))
+	all, err := topic.AssignReplicas(brokers, req.Count, replicationFactor)
+	if err != nil {
+		return nil, err
+	}
+	added := make(map[int32][]int32, newCount)
+	for idx := current; idx < req.Count; idx++ {
+		added[idx] = all[idx]
+	}
+	return added, nil
+}
+
+func parseCreatePartitionsRequest(reqBody []byte) ([]CreatePartitionsRequest, bool, error) {
+	br := parser.BytesReader{B: reqBody}
+
+	nTopics, limitErr := parser.ReadCompactArrayLen(&br, maxRequestTopics, 7)
+	if limitErr != nil && !overLimit(nTopics, limitErr) || nTopics < 0 {
+		return nil, false, limitErr
+	}
+
+	topicRequests := make([]CreatePartitionsRequest, 0, nTopics)
+	for i := 0; i < nTopics; i++ {
+		req := CreatePartitionsRequest{}
+		req.Name = parser.ReadCompactString(&br)
+		req.Count = parser.ReadInt32(&br)
+
+		nAssignments, err := parser.ReadCompactArrayLen(&br, maxRequestPartitions, 2)
+		if err != nil {
+			return topicRequests, false, err
+		}
+		if nAssignments >= 0 {
+			req.Assignments = make([][]int32, 0, nAssignments)
+		}
+		for j := 0; j < nAssignments; j++ {
+			req.Assignments = append(req.Assignments, parser.ReadCompactInt32Array(&br))
+			_ = parser.ReadUVarInt(&br)
+		}
+
+		_ = parser.ReadUVarInt(&br)
+		topicRequests = append(topicRequests, req)
+	}
+
+	_ = parser.ReadInt32(&br)
+	validateOnly := parser.ReadInt8(&br) != 0
+
+	return topicRequests, validateOnly, limitErr
+}
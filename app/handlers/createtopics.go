@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+const (
+	defaultNumPartitions     = 1
+	defaultReplicationFactor = 1
+)
+
+type CreateTopicRequest struct {
+	Name              string
+	NumPartitions     int32
+	ReplicationFactor int16
+	Assignments       map[int32][]int32
+	Configs           []CreateTopicConfig
+}
+
+type CreateTopicConfig struct {
+	Name  string
+	Value string
+	Null  bool
+}
+
+type createTopicResult struct {
+	id                [16]byte
+	errorCode         int16
+	errorMessage      string
+	numPartitions     int32
+	replicationFactor int16
+}
+
+func HandleCreateTopicsV7(corrID int32, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	state := c.State
+
+	topicRequests, validateOnly, parseErr := parseCreateTopicsRequestV7(reqBody)
+	if parseErr != nil && len(topicRequests) == 0 {
+		return nil, errors.From(parseErr)
+	}
+
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	body := parser.AppendInt32(nil, 0)
+	body = parser.AppendUVarInt(body, uint32(len(topicRequests)+1))
+
+	seen := make(map[string]int, len(topicRequests))
+	for _, req := range topicRequests {
+		seen[req.Name]++
+	}
+
+	for _, req := range topicRequests {
+		var res createTopicResult
+		switch {
+		case parseErr != nil:
+			res = createTopicFailure(errors.CodeOf(parseErr), errors.MessageOf(parseErr))
+		case seen[req.Name] > 1:
+			res = createTopicFailure(errors.ErrInvalidRequest, fmt.Sprintf("Create topics request from client contains a duplicate topic %q", req.Name))
+		case quota.MutationThrottleMs(c.ClientID) > 0:
+			res = createTopicFailure(errors.ErrThrottlingQuotaExceeded, throttlingQuotaExceededMessage)
+		default:
+			res = createTopic(req, validateOnly, state)
+			if res.errorCode == errors.ErrNone && !validateOnly {
+				quota.RecordMutations(c.ClientID, int(res.numPartitions))
+			}
+		}
+
+		body = parser.AppendCompactString(body, req.Name)
+		body = append(body, res.id[:]...)
+		body = parser.AppendInt16(body, res.errorCode)
+		body = parser.AppendCompactNullableString(body, res.errorMessage, res.errorCode == errors.ErrNone)
+		body = parser.AppendInt32(body, res.numPartitions)
+		body = parser.AppendInt16(body, res.replicationFactor)
+
+		if res.errorCode != errors.ErrNone {
+			body = parser.AppendUVarInt(body, 0)
+		} else {
+			body = parser.AppendUVarInt(body, uint32(len(req.Configs)+1))
+			for _, cfg := range req.Configs {
+				body = parser.AppendCompactString(body, cfg.Name)
+				body = parser.AppendCompactNullableString(body, cfg.Value, cfg.Null)
+				body = parser.AppendInt8(body, 0)
+				body = parser.AppendInt8(body, 1)
+				body = parser.AppendInt8(body, 0)
+				body = parser.AppendUVarInt(body, 0)
+			}
+		}
+		body = parser.AppendUVarInt(body, 0)
+	}
+
+	body = parser.AppendUVarInt(body, 0)
+
+	throttleMs := quota.MutationThrottleMs(c.ClientID)
+	c.Throttle(throttleMs)
+	binary.BigEndian.PutUint32(body[0:4], uint32(throttleMs))
+
+	return frameResponse(header, body), nil
+}
+
+func createTopic(req CreateTopicRequest, validateOnly bool, state *topic.BrokerState) createTopicResult {
+	if err := topic.ValidateName(req.Name); err != nil {
+		return createTopicFailure(errors.ErrInvalidTopicException, err.Error())
+	}
+
+	brokers := state.LiveBrokers()
+
+	numPartitions := req.NumPartitions
+	replicationFactor := req.ReplicationFactor
+	assignments := req.Assignments
+	if len(assignments) > 0 {
+		if numPartitions != -1 || replicationFactor != -1 {
+			return createTopicFailure(errors.ErrInvalidRequest, "Both numPartitions or replicationFactor and replicasAssignments were set. Both cannot be used at the same time.")
+		}
+		if err := topic.ValidateAssignments(brokers, assignments); err != nil {
+			return createTopicFailure(errors.ErrInvalidReplicaAssignment, err.Error())
+		}
+		numPartitions = int32(len(assignments))
+		replicationFactor = int16(len(assignments[0]))
+	} else {
+		if numPartitions == -1 {
+			numPartitions = defaultNumPartitions
+		}
+		if replicationFactor == -1 {
+			replicationFactor = defaultReplicationFactor
+		}
+		if numPartitions <= 0 {
+			return createTopicFailure(errors.ErrInvalidPartitions, "Number of partitions must be larger than 0.")
+		}
+		if replicationFactor <= 0 {
+			return createTopicFailure(errors.ErrInvalidReplicationFactor, "Replication factor must be larger than 0.")
+		}
+		if numPartitions > maxCreatePartitions {
+			return createTopicFailure(errors.ErrPolicyViolation, fmt.Sprintf("Number of partitions %d exceeds the limit of %d.", numPartitions, maxCreatePartitions))
+		}
+
+		var err error
+		assignments, err = topic.AssignReplicas(brokers, numPartitions, replicationFactor)
+		if err != nil {
+			return createTopicFailure(errors.ErrInvalidReplicationFactor, err.Error())
+		}
+	}
+
+	configs := make(map[string]string, len(req.Configs))
+	for _, cfg := range req.Configs {
+		value := &cfg.Value
+		if cfg.Null {
+			value = nil
+		}
+		if err := topic.ValidateConfig(cfg.Name, value); err != nil {
+			return createTopicFailure(errors.ErrInvalidConfig, err.Error())
+		}
+		if !cfg.Null {
+			configs[cfg.Name] = cfg.Value
+		}
+	}
+
+	state.Lock()
+	defer state.Unlock()
+
+	if _, exists := state.Topics[req.Name]; exists {
+		return createTopicFailure(errors.ErrTopicAlreadyExists, fmt.Sprintf("Topic '%s' already exists.", req.Name))
+	}
+
+	res := createTopicResult{
+		errorCode:         errors.ErrNone,
+		numPartitions:     numPartitions,
+		replicationFactor: replicationFactor,
+	}
+	if validateOnly {
+		return res
+	}
+
+	meta := topic.Meta{
+		ID:            state.NewTopicID(),
+		Partitions:    int(numPartitions),
+		PartitionInfo: make(map[int32]topic.PartitionMeta, numPartitions),
+		Configs:       configs,
+	}
+	for i, replicas := range assignments {
+		meta.PartitionInfo[i] = topic.PartitionMeta{
+			Index:    i,
+			Leader:   replicas[0],
+			Replicas: replicas,
+			ISR:      append([]int32(nil), replicas...),
+		}
+	}
+
+	// The directories go in before the topic is published, so a producer
+	// that sees the topic in metadata can always write to every partition.
+	for i := int32(0); i < numPartitions; i++ {
+		if err := partition.CreateLogDir(req.Name, i); err != nil {
+			kerr := errors.From(err)
+			return createTopicFailure(kerr.Code, kerr.Message)
+		}
+	}
+
+	records := [][]byte{topic.TopicRecordValue(req.Name, meta.ID)}
+	for i := int32(0); i < numPartitions; i++ {
+		records = append(records, topic.PartitionRecordValue(meta.ID, meta.PartitionInfo[i]))
+	}
+	if err := topic.AppendMetadataRecords(records...); err != nil {
+		kerr := errors.From(err)
+		return createTopicFailure(kerr.Code, kerr.Message)
+	}
+	state.SetTopic(req.Name, meta)
+
+	res.id = meta.ID
+	return res
+}
+
+func createTopicFailure(code int16, message string) createTopicResult {
+	return createTopicResult{
+		errorCode:         code,
+		errorMessage:      message,
+		numPartitions:     -1,
+		replicationFactor: -1,
+	}
+}
+
+func parseCreateTopicsRequestV7(reqBody []byte) ([]CreateTopicRequest, bool, error) {
+	br := parser.BytesReader{B: reqBody}
+
+	nTopics, limitErr := parser.ReadCompactArrayLen(&br, maxRequestTopics, 10)
+	if limitErr != nil && !overLimit(nTopics, limitErr) || nTopics < 0 {
+		return nil, false, limitErr
+	}
+
+	topicRequests := make([]CreateTopicRequest, 0, nTopics)
+	for i := 0; i < nTopics; i++ {
+		req := CreateTopicRequest{}
+		req.Name = parser.ReadCompactString(&br)
+		req.NumPartitions = parser.ReadInt32(&br)
+		req.ReplicationFactor = parser.ReadInt16(&br)
+
+		nAssignments, err := parser.ReadCompactArrayLen(&br, maxRequestPartitions, 6)
+		if err != nil {
+			return topicRequests, false, err
+		}
+		if nAssignments > 0 {
+			req.Assignments = make(map[int32][]int32, nAssignments)
+		}
+		for j := 0; j < nAssignments; j++ {
+			idx := parser.ReadInt32(&br)
+			req.Assignments[idx] = parser.ReadCompactInt32Array(&br)
+			_ = parser.ReadUVarInt(&br)
+		}
+
+		nConfigs, err := parser.ReadCompactArrayLen(&br, maxRequestPartitions, 3)
+		if err != nil {
+			return topicRequests, false, err
+		}
+		for j := 0; j < nConfigs; j++ {
+			cfg := CreateTopicConfig{}
+			cfg.Name = parser.ReadCompactString(&br)
+			cfg.Value, cfg.Null = parser.ReadCompactNullableString(&br)
+			_ = parser.ReadUVarInt(&br)
+			req.Configs = append(req.Configs, cfg)
+		}
+
+		_ = parser.ReadUVarInt(&br)
+		topicRequests = append(topicRequests, req)
+	}
+
+	_ = parser.ReadInt32(&br)
+	validateOnly := parser.ReadInt8(&br) != 0
+
+	return topicRequests, validateOnly, limitErr
+}
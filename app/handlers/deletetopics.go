@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/coordinator"
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/purgatory"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+type DeleteTopicRequest struct {
+	Name     string
+	NameNull bool
+	ID       [16]byte
+}
+
+// HandleDeleteTopics serves the flexible DeleteTopics versions (v4 through
+// v6). Up to v5 topics are named; v6 names each topic either by name or by
+// topic ID.
+func HandleDeleteTopics(corrID int32, apiVersion int16, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	topicRequests, parseErr := parseDeleteTopicsRequest(reqBody, apiVersion)
+	if parseErr != nil && len(topicRequests) == 0 {
+		return nil, errors.From(parseErr)
+	}
+
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	body := parser.AppendInt32(nil, 0)
+	body = parser.AppendUVarInt(body, uint32(len(topicRequests)+1))
+
+	seen := make(map[DeleteTopicRequest]int, len(topicRequests))
+	for _, req := range topicRequests {
+		seen[req]++
+	}
+
+	for _, req := range topicRequests {
+		name, id := req.Name, req.ID
+		errorCode, errorMessage := errors.ErrNone, ""
+		switch {
+		case parseErr != nil:
+			errorCode, errorMessage = errors.CodeOf(parseErr), errors.MessageOf(parseErr)
+		case seen[req] > 1:
+			errorCode, errorMessage = errors.ErrInvalidRequest, "Duplicate topic in request."
+		case !req.NameNull && id != parser.NilUUID():
+			errorCode, errorMessage = errors.ErrInvalidRequest, "You may not specify both topic name and topic id."
+		case quota.MutationThrottleMs(c.ClientID) > 0:
+			errorCode, errorMessage = errors.ErrThrottlingQuotaExceeded, throttlingQuotaExceededMessage
+		default:
+			name, id, errorCode, errorMessage = deleteTopic(c, req)
+		}
+
+		if apiVersion >= 6 {
+			body = parser.AppendCompactNullableString(body, name, name == "")
+			body = append(body, id[:]...)
+		} else {
+			body = parser.AppendCompactString(body, name)
+		}
+		body = parser.AppendInt16(body, errorCode)
+		if apiVersion >= 5 {
+			body = parser.AppendCompactNullableString(body, errorMessage, errorCode == errors.ErrNone)
+		}
+		body = parser.AppendUVarInt(body, 0)
+	}
+
+	body = parser.AppendUVarInt(body, 0)
+
+	throttleMs := quota.MutationThrottleMs(c.ClientID)
+	c.Throttle(throttleMs)
+	binary.BigEndian.PutUint32(body[0:4], uint32(throttleMs))
+
+	return frameResponse(header, body), nil
+}
+
+// deleteTopic removes the topic from the broker state and the metadata log,
+// then its partition directories. The state goes first so no new produce can
+// reach a directory that is about to disappear, and fetches parked on the
+// partitions are then woken to answer UNKNOWN_TOPIC_OR_PARTITION. It returns the topic's name
+// and ID for the response, whichever of the two the request used.
+func deleteTopic(c *session.Connection, req DeleteTopicRequest) (string, [16]byte, int16, string) {
+	state := c.State
+
+	state.Lock()
+	name, meta, exists := req.Name, topic.Meta{}, false
+	if req.NameNull {
+		name, meta, exists = state.TopicByID(req.ID)
+		if !exists {
+			state.Unlock()
+			return "", req.ID, errors.ErrUnknownTopicID, "This server does not host this topic ID."
+		}
+	} else {
+		meta, exists = state.Topics[name]
+		if !exists {
+			state.Unlock()
+			return name, req.ID, errors.ErrUnknownTopicOrPartition, fmt.Sprintf("The topic '%s' does not exist.", name)
+		}
+	}
+
+	if err := topic.AppendMetadataRecords(topic.RemoveTopicRecordValue(meta.ID)); err != nil {
+		state.Unlock()
+		kerr := errors.From(err)
+		return name, meta.ID, kerr.Code, kerr.Message
+	}
+	state.RemoveTopic(name)
+	state.Unlock()
+
+	coordinator.DeleteTopicOffsets(name)
+	numPartitions := int32(max(meta.Partitions, 1))
+	quota.RecordMutations(c.ClientID, int(numPartitions))
+	for idx := int32(0); idx < numPartitions; idx++ {
+		if err := partition.DeleteLogDir(name, idx); err != nil {
+			logger.Warn("%s: removing %s-%d failed: %v", c, name, idx, err)
+		}
+		purgatory.Default.CheckAndComplete(purgatory.PartitionKey(name, idx))
+	}
+
+	return name, meta.ID, errors.ErrNone, ""
+}
+
+func parseDeleteTopicsRequest(reqBody []byte, apiVersion int16) ([]DeleteTopicRequest, error) {
+	br := parser.BytesReader{B: reqBody}
+
+	minTopicSize := 1
+	if apiVersion >= 6 {
+		minTopicSize = 18
+	}
+	nTopics, limitErr := parser.ReadCompactArrayLen(&br, maxRequestTopics, minTopicSize)
+	if limitErr != nil && !overLimit(nTopics, limitErr) || nTopics < 0 {
+		return nil, limitErr
+	}
+
+	topicRequests := make([]DeleteTopicRequest, 0, nTopics)
+	for i := 0; i < nTopics; i++ {
+		req := DeleteTopicRequest{}
+		if apiVersion >= 6 {
+			req.Name, req.NameNull = parser.ReadCompactNullableString(&br)
+			if !br.CanRead(16) {
+				break
+			}
+			copy(req.ID[:], br.B[br.Off:br.Off+16])
+			br.Off += 16
+			_ = parser.ReadUVarInt(&br)
+		} else {
+			req.Name = parser.ReadCompactString(&br)
+		}
+		topicRequests = append(topicRequests, req)
+	}
+
+	_ = parser.ReadInt32(&br)
+
+	return topicRequests, limitErr
+}
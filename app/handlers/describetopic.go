@@ -1,88 +1,206 @@
 package handlers
 
 import (
+	"slices"
 	"sort"
 
+	"github.com/codecrafters-io/kafka-starter-go/app/acl"
 	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
 	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
 	"github.com/codecrafters-io/kafka-starter-go/app/topic"
 )
 
-func HandleDescribeTopicPartitionsV0(corrID int32, reqBody []byte, state *topic.BrokerState) []byte {
-	reqNames := parseTopicRequests(reqBody)
+// describePartitionsLimit mirrors max.request.partition.size.limit: no
+// DescribeTopicPartitions response lists more partitions than this, whatever
+// response_partition_limit the client asks for.
+const describePartitionsLimit = 2000
+
+// describeTopicsCursor names the first partition a DescribeTopicPartitions
+// page starts at, or the next one should start at.
+type describeTopicsCursor struct {
+	TopicName      string
+	PartitionIndex int32
+}
+
+type describeTopicsRequest struct {
+	Names          []string
+	AllTopics      bool
+	PartitionLimit int32
+	Cursor         *describeTopicsCursor
+}
+
+// HandleDescribeTopicPartitionsV0 pages through the requested topics in name
+// order, listing at most response_partition_limit partitions. A request with
+// a cursor resumes at that topic and partition; a response that stops short
+// carries the cursor the next page should send.
+func HandleDescribeTopicPartitionsV0(corrID int32, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	state := c.State
+
+	req, parseErr := parseTopicRequests(reqBody)
+	if parseErr != nil && len(req.Names) == 0 {
+		return nil, errors.From(parseErr)
+	}
+	reqNames := req.Names
+
+	state.RLock()
+	defer state.RUnlock()
+
+	if req.AllTopics {
+		reqNames = make([]string, 0, len(state.Topics))
+		for name := range state.Topics {
+			reqNames = append(reqNames, name)
+		}
+	}
+	// Topics are always answered in name order; the cursor pagination relies
+	// on that ordering, so request order is not preserved. A topic asked for
+	// more than once is answered once.
 	sort.Strings(reqNames)
+	reqNames = slices.Compact(reqNames)
+	if req.Cursor != nil {
+		first, _ := slices.BinarySearch(reqNames, req.Cursor.TopicName)
+		reqNames = reqNames[first:]
+	}
+
+	remaining := describePartitionsLimit
+	if req.PartitionLimit > 0 {
+		remaining = min(remaining, int(req.PartitionLimit))
+	}
 
 	header := parser.AppendInt32(nil, corrID)
 	header = parser.AppendUVarInt(header, 0)
 
-	body := parser.AppendInt32(nil, 0)
-	body = parser.AppendUVarInt(body, uint32(len(reqNames)+1))
-
+	var topics []byte
+	var answered int
+	var next *describeTopicsCursor
 	for _, name := range reqNames {
+		firstPartition := 0
+		if req.Cursor != nil && name == req.Cursor.TopicName {
+			firstPartition = max(int(req.Cursor.PartitionIndex), 0)
+		}
+		if remaining == 0 {
+			next = &describeTopicsCursor{TopicName: name, PartitionIndex: int32(firstPartition)}
+			break
+		}
+		answered++
+
 		meta, exists := state.Topics[name]
 
-		if !exists {
-			body = parser.AppendInt16(body, errors.ErrUnknownTopicOrPartition)
-			body = parser.AppendCompactString(body, name)
-			uuid := parser.NilUUID()
-			body = append(body, uuid[:]...)
-			body = append(body, 0x00)
-			body = parser.AppendUVarInt(body, 1)
-			body = parser.AppendInt32(body, -2147483648)
-			body = parser.AppendUVarInt(body, 0)
-		} else {
-			body = parser.AppendInt16(body, errors.ErrNone)
-			body = parser.AppendCompactString(body, name)
-			body = append(body, meta.ID[:]...)
-			body = append(body, 0x00)
-
-			numPartitions := meta.Partitions
-			if numPartitions == 0 {
-				numPartitions = 1
-			}
-			body = parser.AppendUVarInt(body, uint32(numPartitions+1))
-
-			for partIdx := 0; partIdx < numPartitions; partIdx++ {
-				body = parser.AppendInt16(body, errors.ErrNone)
-				body = parser.AppendInt32(body, int32(partIdx))
-				body = parser.AppendInt32(body, 1)
-				body = parser.AppendInt32(body, -1)
-				body = parser.AppendUVarInt(body, 2)
-				body = parser.AppendInt32(body, 1)
-				body = parser.AppendUVarInt(body, 2)
-				body = parser.AppendInt32(body, 1)
-				body = parser.AppendUVarInt(body, 1)
-				body = parser.AppendUVarInt(body, 1)
-				body = parser.AppendUVarInt(body, 1)
-				body = parser.AppendUVarInt(body, 0)
+		if parseErr != nil || !exists {
+			errorCode := errors.ErrUnknownTopicOrPartition
+			if parseErr != nil {
+				errorCode = errors.CodeOf(parseErr)
 			}
+			topics = parser.AppendInt16(topics, errorCode)
+			topics = parser.AppendCompactString(topics, name)
+			uuid := parser.NilUUID()
+			topics = append(topics, uuid[:]...)
+			topics = append(topics, 0x00)
+			topics = parser.AppendUVarInt(topics, 1)
+			topics = parser.AppendInt32(topics, acl.OmittedOperations)
+			topics = parser.AppendUVarInt(topics, 0)
+			continue
+		}
+
+		topics = parser.AppendInt16(topics, errors.ErrNone)
+		topics = parser.AppendCompactString(topics, name)
+		topics = append(topics, meta.ID[:]...)
+		topics = parser.AppendBool(topics, meta.Internal)
+
+		numPartitions := meta.Partitions
+		if numPartitions == 0 {
+			numPartitions = 1
+		}
+		firstPartition = min(firstPartition, numPartitions)
+		lastPartition := min(numPartitions, firstPartition+remaining)
+		remaining -= lastPartition - firstPartition
+		if lastPartition < numPartitions {
+			next = &describeTopicsCursor{TopicName: name, PartitionIndex: int32(lastPartition)}
+		}
+		topics = parser.AppendUVarInt(topics, uint32(lastPartition-firstPartition+1))
+
+		for partIdx := firstPartition; partIdx < lastPartition; partIdx++ {
+			errorCode, part := partitionStatus(c, name, meta.Partition(int32(partIdx)))
+			topics = parser.AppendInt16(topics, errorCode)
+			topics = parser.AppendInt32(topics, part.Index)
+			topics = parser.AppendInt32(topics, part.Leader)
+			topics = parser.AppendInt32(topics, part.LeaderEpoch)
+			topics = parser.AppendCompactInt32Array(topics, part.Replicas)
+			topics = parser.AppendCompactInt32Array(topics, part.ISR)
+			topics = parser.AppendCompactInt32Array(topics, part.ELR)
+			topics = parser.AppendCompactInt32Array(topics, part.LastKnownELR)
+			topics = parser.AppendCompactInt32Array(topics, part.OfflineReplicas)
+			topics = parser.AppendUVarInt(topics, 0)
+		}
 
-			body = parser.AppendInt32(body, -2147483648)
-			body = parser.AppendUVarInt(body, 0)
+		topics = parser.AppendInt32(topics, acl.TopicAuthorizedOperations(c.Principal, name))
+		topics = parser.AppendUVarInt(topics, 0)
+		if next != nil {
+			break
 		}
 	}
 
-	body = append(body, 0xFF)
+	body := parser.AppendInt32(nil, 0)
+	body = parser.AppendUVarInt(body, uint32(answered+1))
+	body = append(body, topics...)
+
+	if next == nil {
+		body = parser.AppendInt8(body, -1)
+	} else {
+		body = parser.AppendInt8(body, 1)
+		body = parser.AppendCompactString(body, next.TopicName)
+		body = parser.AppendInt32(body, next.PartitionIndex)
+		body = parser.AppendUVarInt(body, 0)
+	}
 	body = parser.AppendUVarInt(body, 0)
 
-	return frameResponse(header, body)
+	return frameResponse(header, body), nil
 }
 
-func parseTopicRequests(reqBody []byte) []string {
-	br := parser.BytesReader{B: reqBody}
+// partitionStatus returns the error Metadata and DescribeTopicPartitions
+// report for a partition, and the partition as they describe it: one whose
+// log directory can't be read is KAFKA_STORAGE_ERROR, with its leader listed
+// among the offline replicas. Sharing it keeps the two from disagreeing.
+func partitionStatus(c *session.Connection, name string, part topic.PartitionMeta) (int16, topic.PartitionMeta) {
+	if part.Leader < 0 {
+		return errors.ErrLeaderNotAvailable, part
+	}
+	if err := partition.CheckLogDir(name, part.Index); err != nil {
+		logger.Warn("%s: partition %s-%d offline: %v", c, name, part.Index, err)
+		part.OfflineReplicas = append(append([]int32(nil), part.OfflineReplicas...), part.Leader)
+		return errors.ErrKafkaStorageError, part
+	}
+	return errors.ErrNone, part
+}
 
-	_ = parser.ReadUVarInt(&br)
+func parseTopicRequests(reqBody []byte) (describeTopicsRequest, error) {
+	br := parser.BytesReader{B: reqBody}
 
-	nTopics := int(parser.ReadUVarInt(&br)) - 1
+	req := describeTopicsRequest{}
+	nTopics, limitErr := parser.ReadCompactArrayLen(&br, maxRequestTopics, 2)
+	if limitErr != nil && !overLimit(nTopics, limitErr) {
+		return req, limitErr
+	}
 	if nTopics < 0 {
-		return nil
+		req.AllTopics = true
 	}
 
-	names := make([]string, 0, nTopics)
+	req.Names = make([]string, 0, max(nTopics, 0))
 	for i := 0; i < nTopics; i++ {
 		name := parser.ReadCompactString(&br)
 		_ = parser.ReadUVarInt(&br)
-		names = append(names, name)
+		req.Names = append(req.Names, name)
+	}
+
+	req.PartitionLimit = parser.ReadInt32(&br)
+	if parser.ReadInt8(&br) >= 0 {
+		cursor := &describeTopicsCursor{}
+		cursor.TopicName = parser.ReadCompactString(&br)
+		cursor.PartitionIndex = parser.ReadInt32(&br)
+		_ = parser.ReadUVarInt(&br)
+		req.Cursor = cursor
 	}
-	return names
+	return req, limitErr
 }
@@ -0,0 +1,90 @@
+package handlers
+
+import "github.com/codecrafters-io/kafka-starter-go/app/parser"
+
+// The helpers below pick between the flexible (compact, tagged) encodings
+// and the classic ones, for handlers that serve versions on both sides of an
+// API's flexible-versions cutoff.
+
+func appendArrayLen(b []byte, n int, flexible bool) []byte {
+	if flexible {
+		return parser.AppendUVarInt(b, uint32(n+1))
+	}
+	return parser.AppendInt32(b, int32(n))
+}
+
+func appendString(b []byte, s string, flexible bool) []byte {
+	if flexible {
+		return parser.AppendCompactString(b, s)
+	}
+	return parser.AppendString(b, s)
+}
+
+func appendNullableString(b []byte, s string, null, flexible bool) []byte {
+	if flexible {
+		return parser.AppendCompactNullableString(b, s, null)
+	}
+	return parser.AppendNullableString(b, s, null)
+}
+
+func appendBytes(b []byte, data []byte, flexible bool) []byte {
+	if flexible {
+		b = parser.AppendUVarInt(b, uint32(len(data)+1))
+	} else {
+		b = parser.AppendInt32(b, int32(len(data)))
+	}
+	return append(b, data...)
+}
+
+func appendTaggedFields(b []byte, flexible bool) []byte {
+	if flexible {
+		return parser.AppendUVarInt(b, 0)
+	}
+	return b
+}
+
+func readArrayLen(br *parser.BytesReader, max, minElemSize int, flexible bool) (int, error) {
+	if flexible {
+		return parser.ReadCompactArrayLen(br, max, minElemSize)
+	}
+	return parser.ReadArrayLen(br, max, minElemSize)
+}
+
+func readString(br *parser.BytesReader, flexible bool) string {
+	if flexible {
+		return parser.ReadCompactString(br)
+	}
+	return parser.ReadString(br)
+}
+
+func readNullableString(br *parser.BytesReader, flexible bool) (string, bool) {
+	if flexible {
+		return parser.ReadCompactNullableString(br)
+	}
+	return parser.ReadNullableString(br)
+}
+
+// readBytesLen reads the length prefix of a (nullable) bytes field, returning
+// -1 for null.
+func readBytesLen(br *parser.BytesReader, flexible bool) int {
+	if flexible {
+		return int(parser.ReadUVarInt(br)) - 1
+	}
+	return int(parser.ReadInt32(br))
+}
+
+func skipTaggedFields(br *parser.BytesReader, flexible bool) {
+	if !flexible {
+		return
+	}
+	nTags := int(parser.ReadUVarInt(br))
+	for i := 0; i < nTags && br.Off < len(br.B); i++ {
+		_ = parser.ReadUVarInt(br)
+		size := int(parser.ReadUVarInt(br))
+		if !br.CanRead(size) {
+			br.Off = len(br.B)
+			return
+		}
+		br.Off += size
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+)
+
+// BuildErrorResponse encodes a request-level failure for the APIs whose
+// response carries a top-level error code in the given version. The others
+// can only report errors per topic, partition or group, which the handlers
+// do themselves for every entry they parsed; for those it returns nil, and
+// the connection is closed, as the Java broker does with a request it cannot
+// answer. ApiVersions is answered with just the error code, as
+// BuildApiVersionsErrorOnly does for a version it doesn't serve.
+func BuildErrorResponse(apiKey, apiVersion int16, corrID int32, kerr *errors.KafkaError) []byte {
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	var body []byte
+	switch {
+	case apiKey == APIKeyFetch && apiVersion >= 7:
+		if apiVersion < 12 {
+			return buildClassicFetchError(corrID, kerr)
+		}
+		body = parser.AppendInt32(body, 0)
+		body = parser.AppendInt16(body, kerr.Code)
+		body = parser.AppendInt32(body, 0)
+		body = parser.AppendUVarInt(body, 1)
+	case apiKey == APIKeyMetadata && apiVersion >= 13:
+		body = parser.AppendInt32(body, 0)
+		body = parser.AppendUVarInt(body, 1)
+		body = parser.AppendCompactNullableString(body, "", true)
+		body = parser.AppendInt32(body, -1)
+		body = parser.AppendUVarInt(body, 1)
+		body = parser.AppendInt16(body, kerr.Code)
+	case apiKey == APIKeyOffsetFetch && apiVersion < 8:
+		body = parser.AppendInt32(body, 0)
+		body = parser.AppendUVarInt(body, 1)
+		body = parser.AppendInt16(body, kerr.Code)
+	case apiKey == APIKeyFindCoordinator && apiVersion < 4:
+		body = parser.AppendInt32(body, 0)
+		body = parser.AppendInt16(body, kerr.Code)
+		body = parser.AppendCompactNullableString(body, kerr.Message, false)
+		body = parser.AppendInt32(body, -1)
+		body = parser.AppendCompactString(body, "")
+		body = parser.AppendInt32(body, -1)
+	case apiKey == APIKeyApiVersions:
+		return BuildApiVersionsErrorOnly(corrID, kerr.Code)
+	default:
+		return nil
+	}
+	body = parser.AppendUVarInt(body, 0)
+
+	return frameResponse(header, body)
+}
+
+// buildClassicFetchError encodes a Fetch failure for v7 through v11, which
+// carry a top-level error code but no tagged fields.
+func buildClassicFetchError(corrID int32, kerr *errors.KafkaError) []byte {
+	header := parser.AppendInt32(nil, corrID)
+
+	body := parser.AppendInt32(nil, 0)
+	body = parser.AppendInt16(body, kerr.Code)
+	body = parser.AppendInt32(body, 0)
+	body = parser.AppendInt32(body, 0)
+
+	return frameResponse(header, body)
+}
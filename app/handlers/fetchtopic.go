@@ -1,114 +1,374 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/codecrafters-io/kafka-starter-go/app/errors"
 	"github.com/codecrafters-io/kafka-starter-go/app/parser"
 	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/purgatory"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/stats"
 	"github.com/codecrafters-io/kafka-starter-go/app/topic"
 )
 
-func HandleFetchV16(corrID int32, reqBody []byte, state *topic.BrokerState) []byte {
-	topicIDs := parseFetchRequestV16(reqBody)
+// fetchMaxBytes mirrors the broker's fetch.max.bytes: no single Fetch
+// response carries more record data than this, whatever the client asks for.
+const fetchMaxBytes = 55 << 20
+
+type FetchRequest struct {
+	MaxWaitMs int32
+	MinBytes  int32
+	MaxBytes  int32
+	Topics    []FetchTopicRequest
+}
+
+type FetchTopicRequest struct {
+	Name       string
+	ID         [16]byte
+	Partitions []FetchPartitionRequest
+}
+
+type FetchPartitionRequest struct {
+	Index       int32
+	FetchOffset int64
+	MaxBytes    int32
+}
+
+// responseMaxBytes is the record budget for the whole response: the client's
+// max_bytes, never more than fetchMaxBytes.
+func (r FetchRequest) responseMaxBytes() int {
+	if r.MaxBytes <= 0 {
+		return fetchMaxBytes
+	}
+	return min(int(r.MaxBytes), fetchMaxBytes)
+}
+
+// HandleFetch serves Fetch v4 through v16. Topics are addressed by name up to
+// v12 and by topic ID from v13 onwards; v12 is also where the encoding turns
+// flexible. Fields are written only for the versions that carry them. A fetch
+// that finds fewer than min_bytes waits up to max_wait_ms for more. Records
+// are cut at batch boundaries to honour max_bytes and each partition's
+// max_bytes, though the first batch returned is sent whole whatever its size.
+func HandleFetch(corrID int32, apiVersion int16, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	state := c.State
+	byID := apiVersion >= 13
+	flexible := apiVersion >= 12
+
+	req, parseErr := parseFetchRequest(reqBody, apiVersion)
+	if parseErr != nil {
+		return nil, errors.From(parseErr)
+	}
+	topicRequests := mergeFetchTopics(req.Topics, byID)
+
+	maxBytes := req.responseMaxBytes()
+	fetched, size := readFetch(state, topicRequests, byID, maxBytes)
+	if size < int(req.MinBytes) && req.MaxWaitMs > 0 && !fetchFailed(fetched) {
+		awaitFetchData(state, topicRequests, byID, maxBytes, int(req.MinBytes), time.Duration(req.MaxWaitMs)*time.Millisecond)
+		fetched, _ = readFetch(state, topicRequests, byID, maxBytes)
+	}
 
 	header := parser.AppendInt32(nil, corrID)
-	header = parser.AppendUVarInt(header, 0)
+	header = appendTaggedFields(header, flexible)
 
-	body := parser.AppendInt32(nil, 0)
-	body = parser.AppendInt16(body, errors.ErrNone)
-	body = parser.AppendInt32(body, 0)
+	throttleMs := quota.FetchThrottleMs(c.ClientID)
+	c.Throttle(throttleMs)
 
-	body = parser.AppendUVarInt(body, uint32(len(topicIDs)+1))
+	// The records are by far the bulk of the response, and are copied once,
+	// straight into the frame; the rest is sized generously enough that the
+	// frame is never reallocated.
+	bodySize := 16
+	for i, topicReq := range topicRequests {
+		bodySize += 32 + len(topicReq.Name)
+		for j := range topicReq.Partitions {
+			bodySize += 64 + len(fetched[i][j].records)
+		}
+	}
+	body := beginResponse(header, bodySize)
 
-	for _, topicID := range topicIDs {
-		var topicName string
-		exists := false
-		for name, meta := range state.Topics {
-			if meta.ID == topicID {
-				exists = true
-				topicName = name
-				break
+	body = parser.AppendInt32(body, throttleMs)
+	if apiVersion >= 7 {
+		body = parser.AppendInt16(body, errors.ErrNone)
+		body = parser.AppendInt32(body, 0)
+	}
+
+	body = appendArrayLen(body, len(topicRequests), flexible)
+
+	for i, topicReq := range topicRequests {
+		if byID {
+			body = append(body, topicReq.ID[:]...)
+		} else {
+			body = appendString(body, topicReq.Name, flexible)
+		}
+		body = appendArrayLen(body, len(topicReq.Partitions), flexible)
+
+		for j, partReq := range topicReq.Partitions {
+			res := fetched[i][j]
+			if res.topicName != "" {
+				stats.Default.RecordBytesOut(res.topicName, c.ClientID, len(res.records))
+			}
+
+			body = parser.AppendInt32(body, partReq.Index)
+			body = parser.AppendInt16(body, res.errorCode)
+			body = parser.AppendInt64(body, res.offsets.HighWatermark)
+			body = parser.AppendInt64(body, res.offsets.HighWatermark)
+			if apiVersion >= 5 {
+				body = parser.AppendInt64(body, res.offsets.LogStartOffset)
+			}
+			body = appendArrayLen(body, 0, flexible)
+			if apiVersion >= 11 {
+				body = parser.AppendInt32(body, 0)
 			}
+			body = appendBytes(body, res.records, flexible)
+			body = appendTaggedFields(body, flexible)
 		}
 
-		body = append(body, topicID[:]...)
-		body = parser.AppendUVarInt(body, 2)
+		body = appendTaggedFields(body, flexible)
+	}
 
-		body = parser.AppendInt32(body, 0)
+	body = appendTaggedFields(body, flexible)
+
+	return endResponse(body), nil
+}
+
+type fetchedPartition struct {
+	topicName string
+	errorCode int16
+	records   []byte
+	offsets   partition.Offsets
+}
+
+// readFetch reads each requested partition's records from its fetch
+// position, capping each partition at its own max_bytes and the whole response
+// at maxBytes, and returns them, indexed like the request, with the number of
+// record bytes read. Only the first partition to return data may overshoot,
+// by its first batch.
+func readFetch(state *topic.BrokerState, topicRequests []FetchTopicRequest, byID bool, maxBytes int) ([][]fetchedPartition, int) {
+	fetched := make([][]fetchedPartition, len(topicRequests))
+	remaining := maxBytes
+	for i, topicReq := range topicRequests {
+		fetched[i] = make([]fetchedPartition, len(topicReq.Partitions))
+		topicName, exists := resolveTopic(state, topicReq.Name, topicReq.ID, byID)
 		if !exists {
-			body = parser.AppendInt16(body, errors.ErrUnknownTopicID)
-			body = parser.AppendInt64(body, 0)
-			body = parser.AppendInt64(body, 0)
-			body = parser.AppendInt64(body, 0)
-			body = parser.AppendUVarInt(body, 1)
-			body = parser.AppendInt32(body, 0)
-			body = parser.AppendUVarInt(body, 1)
-			body = parser.AppendUVarInt(body, 0)
-		} else {
-			records := partition.ReadRecords(topicName, 0)
-
-			body = parser.AppendInt16(body, errors.ErrNone)
-			body = parser.AppendInt64(body, 1)
-			body = parser.AppendInt64(body, 0)
-			body = parser.AppendInt64(body, 0)
-			body = parser.AppendUVarInt(body, 1)
-			body = parser.AppendInt32(body, 0)
-
-			if len(records) > 0 {
-				body = parser.AppendUVarInt(body, uint32(len(records)+1))
-				body = append(body, records...)
-			} else {
-				body = parser.AppendUVarInt(body, 1)
-			}
-			body = parser.AppendUVarInt(body, 0)
+			code := errors.ErrUnknownTopicOrPartition
+			if byID {
+				code = errors.ErrUnknownTopicID
+			}
+			for j := range fetched[i] {
+				fetched[i][j].errorCode = code
+			}
+			continue
 		}
 
-		body = parser.AppendUVarInt(body, 0)
+		state.RLock()
+		numPartitions := int32(max(state.Topics[topicName].Partitions, 1))
+		state.RUnlock()
+
+		for j, partReq := range topicReq.Partitions {
+			if partReq.Index < 0 || partReq.Index >= numPartitions {
+				fetched[i][j].errorCode = errors.ErrUnknownTopicOrPartition
+				continue
+			}
+
+			// A position outside the log answers OFFSET_OUT_OF_RANGE, which
+			// is what sets a consumer's auto.offset.reset going.
+			res := fetchedPartition{topicName: topicName, errorCode: errors.ErrNone}
+			res.offsets = partition.GetOffsets(topicName, partReq.Index)
+			if partReq.FetchOffset < res.offsets.LogStartOffset || partReq.FetchOffset > res.offsets.HighWatermark {
+				res.errorCode = errors.ErrOffsetOutOfRange
+				fetched[i][j] = res
+				continue
+			}
+			limit := min(remaining, max(int(partReq.MaxBytes), 0))
+			records, err := partition.ReadRecordsFrom(topicName, partReq.Index, partReq.FetchOffset, limit)
+			if err != nil {
+				res.errorCode = errors.CodeOf(err)
+			}
+			res.records = partition.TruncateToBatches(records, limit, remaining == maxBytes)
+			remaining = max(remaining-len(res.records), 0)
+			// Taken again after the read, so the high watermark is never
+			// behind the records returned.
+			res.offsets = partition.GetOffsets(topicName, partReq.Index)
+			fetched[i][j] = res
+		}
+
+		// A topic deleted while its partitions were read may have lost its
+		// files part way; answer as a fetch arriving just after would.
+		if _, exists := resolveTopic(state, topicReq.Name, topicReq.ID, byID); !exists {
+			code := errors.ErrUnknownTopicOrPartition
+			if byID {
+				code = errors.ErrUnknownTopicID
+			}
+			for j := range fetched[i] {
+				remaining = min(remaining+len(fetched[i][j].records), maxBytes)
+				fetched[i][j] = fetchedPartition{errorCode: code}
+			}
+		}
 	}
+	return fetched, maxBytes - remaining
+}
 
-	body = parser.AppendUVarInt(body, 0)
+// fetchFailed reports whether any partition of a fetch errored, which answers
+// the fetch at once rather than after max_wait_ms.
+func fetchFailed(fetched [][]fetchedPartition) bool {
+	for _, partitions := range fetched {
+		for _, res := range partitions {
+			if res.errorCode != errors.ErrNone {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	return frameResponse(header, body)
+// awaitFetchData parks a fetch in the purgatory, watching its partitions,
+// until they hold minBytes of records past the fetch positions or maxWait
+// passes. Produce completes the watchers of every partition it appends to.
+func awaitFetchData(state *topic.BrokerState, topicRequests []FetchTopicRequest, byID bool, maxBytes, minBytes int, maxWait time.Duration) {
+	var keys []string
+	for _, topicReq := range topicRequests {
+		topicName, exists := resolveTopic(state, topicReq.Name, topicReq.ID, byID)
+		if !exists {
+			continue
+		}
+		for _, partReq := range topicReq.Partitions {
+			keys = append(keys, purgatory.PartitionKey(topicName, partReq.Index))
+		}
+	}
+
+	done := make(chan struct{})
+	purgatory.Default.ParkWatching(maxWait, keys, func() bool {
+		fetched, size := readFetch(state, topicRequests, byID, maxBytes)
+		return size >= minBytes || fetchFailed(fetched)
+	}, func(bool) { close(done) })
+	<-done
 }
 
-func parseFetchRequestV16(reqBody []byte) [][16]byte {
-	br := parser.BytesReader{B: reqBody}
+// mergeFetchTopics folds repeated entries for the same topic into the first
+// one, as the Java broker's fetch map does, so each topic is answered once. A
+// partition named more than once keeps its last fetch position.
+func mergeFetchTopics(topicRequests []FetchTopicRequest, byID bool) []FetchTopicRequest {
+	merged := make([]FetchTopicRequest, 0, len(topicRequests))
+	byName := map[string]int{}
+	byTopicID := map[[16]byte]int{}
+	for _, topicReq := range topicRequests {
+		idx, seen := byName[topicReq.Name]
+		if byID {
+			idx, seen = byTopicID[topicReq.ID]
+		}
+		if !seen {
+			idx = len(merged)
+			byName[topicReq.Name] = idx
+			byTopicID[topicReq.ID] = idx
+			merged = append(merged, FetchTopicRequest{Name: topicReq.Name, ID: topicReq.ID})
+		}
+		for _, partReq := range topicReq.Partitions {
+			replaced := false
+			for k, existing := range merged[idx].Partitions {
+				if existing.Index == partReq.Index {
+					merged[idx].Partitions[k] = partReq
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				merged[idx].Partitions = append(merged[idx].Partitions, partReq)
+			}
+		}
+	}
+	return merged
+}
+
+// resolveTopic finds a requested topic by name, or by topic ID for the API
+// versions that address topics by UUID.
+func resolveTopic(state *topic.BrokerState, name string, id [16]byte, byID bool) (string, bool) {
+	state.RLock()
+	defer state.RUnlock()
 
-	_ = parser.ReadCompactString(&br)
+	if !byID {
+		_, exists := state.Topics[name]
+		return name, exists
+	}
+	name, _, exists := state.TopicByID(id)
+	return name, exists
+}
 
-	_ = parser.ReadInt32(&br)
-	_ = parser.ReadInt32(&br)
-	_ = parser.ReadInt32(&br)
+func parseFetchRequest(reqBody []byte, apiVersion int16) (FetchRequest, error) {
+	flexible := apiVersion >= 12
+	br := parser.BytesReader{B: reqBody}
+
+	req := FetchRequest{}
+	if apiVersion < 15 {
+		_ = parser.ReadInt32(&br)
+	}
+	req.MaxWaitMs = parser.ReadInt32(&br)
+	req.MinBytes = parser.ReadInt32(&br)
+	req.MaxBytes = parser.ReadInt32(&br)
 	_ = parser.ReadInt8(&br)
-	_ = parser.ReadInt32(&br)
-	_ = parser.ReadInt32(&br)
+	if apiVersion >= 7 {
+		_ = parser.ReadInt32(&br)
+		_ = parser.ReadInt32(&br)
+	}
 
-	nTopics := int(parser.ReadUVarInt(&br)) - 1
-	if nTopics < 0 {
-		return nil
+	minTopicSize := 3
+	if apiVersion >= 13 {
+		minTopicSize = 18
+	}
+	nTopics, err := readArrayLen(&br, maxRequestTopics, minTopicSize, flexible)
+	if err != nil || nTopics < 0 {
+		return req, err
 	}
 
-	topicIDs := make([][16]byte, 0, nTopics)
+	minPartitionSize := 16
+	if apiVersion >= 5 {
+		minPartitionSize += 8
+	}
+	if apiVersion >= 9 {
+		minPartitionSize += 4
+	}
+	if apiVersion >= 12 {
+		minPartitionSize += 5
+	}
+
+	req.Topics = make([]FetchTopicRequest, 0, nTopics)
 	for i := 0; i < nTopics; i++ {
-		if !br.CanRead(16) {
-			break
+		topicReq := FetchTopicRequest{}
+		if apiVersion >= 13 {
+			if !br.CanRead(16) {
+				break
+			}
+			copy(topicReq.ID[:], br.B[br.Off:br.Off+16])
+			br.Off += 16
+		} else {
+			topicReq.Name = readString(&br, flexible)
 		}
-		var topicID [16]byte
-		copy(topicID[:], br.B[br.Off:br.Off+16])
-		br.Off += 16
-		topicIDs = append(topicIDs, topicID)
 
-		nPartitions := int(parser.ReadUVarInt(&br)) - 1
+		nPartitions, err := readArrayLen(&br, maxRequestPartitions, minPartitionSize, flexible)
+		if err != nil {
+			return req, err
+		}
+		topicReq.Partitions = make([]FetchPartitionRequest, 0, max(nPartitions, 0))
 		for j := 0; j < nPartitions; j++ {
-			_ = parser.ReadInt32(&br)
-			_ = parser.ReadInt32(&br)
-			_ = parser.ReadInt64(&br)
-			_ = parser.ReadInt64(&br)
-			_ = parser.ReadInt64(&br)
-			_ = parser.ReadInt32(&br)
-			_ = parser.ReadUVarInt(&br)
+			partReq := FetchPartitionRequest{}
+			partReq.Index = parser.ReadInt32(&br)
+			if apiVersion >= 9 {
+				_ = parser.ReadInt32(&br)
+			}
+			partReq.FetchOffset = parser.ReadInt64(&br)
+			if apiVersion >= 12 {
+				_ = parser.ReadInt32(&br)
+			}
+			if apiVersion >= 5 {
+				_ = parser.ReadInt64(&br)
+			}
+			partReq.MaxBytes = parser.ReadInt32(&br)
+			skipTaggedFields(&br, flexible)
+			topicReq.Partitions = append(topicReq.Partitions, partReq)
 		}
-		_ = parser.ReadUVarInt(&br)
+		skipTaggedFields(&br, flexible)
+		req.Topics = append(req.Topics, topicReq)
 	}
 
-	return topicIDs
+	return req, nil
 }
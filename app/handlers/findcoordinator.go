@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"github.com/codecrafters-io/kafka-starter-go/app/coordinator"
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/listener"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+const (
+	coordinatorKeyGroup       = int8(0)
+	coordinatorKeyTransaction = int8(1)
+)
+
+type coordinatorResult struct {
+	key          string
+	nodeID       int32
+	host         string
+	port         int32
+	errorCode    int16
+	errorMessage string
+}
+
+// HandleFindCoordinator serves FindCoordinator v3 and v4. v3 looks up a
+// single key; v4 batches several keys of one type and answers per key. The
+// coordinator is the leader of the key's partition of the internal topic,
+// at its endpoint for the listener the request arrived on.
+func HandleFindCoordinator(corrID int32, apiVersion int16, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	keyType, keys, parseErr := parseFindCoordinatorRequest(reqBody, apiVersion)
+	if parseErr != nil {
+		return nil, errors.From(parseErr)
+	}
+
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	body := parser.AppendInt32(nil, 0)
+	if apiVersion < 4 {
+		res := findCoordinator(c, keyType, keys[0])
+		body = parser.AppendInt16(body, res.errorCode)
+		body = parser.AppendCompactNullableString(body, res.errorMessage, res.errorCode == errors.ErrNone)
+		body = parser.AppendInt32(body, res.nodeID)
+		body = parser.AppendCompactString(body, res.host)
+		body = parser.AppendInt32(body, res.port)
+	} else {
+		body = parser.AppendUVarInt(body, uint32(len(keys)+1))
+		for _, key := range keys {
+			res := findCoordinator(c, keyType, key)
+			body = parser.AppendCompactString(body, res.key)
+			body = parser.AppendInt32(body, res.nodeID)
+			body = parser.AppendCompactString(body, res.host)
+			body = parser.AppendInt32(body, res.port)
+			body = parser.AppendInt16(body, res.errorCode)
+			body = parser.AppendCompactNullableString(body, res.errorMessage, res.errorCode == errors.ErrNone)
+			body = parser.AppendUVarInt(body, 0)
+		}
+	}
+	body = parser.AppendUVarInt(body, 0)
+
+	return frameResponse(header, body), nil
+}
+
+func findCoordinator(c *session.Connection, keyType int8, key string) coordinatorResult {
+	res := coordinatorResult{key: key, nodeID: -1, port: -1}
+
+	var meta topic.Meta
+	var idx int32
+	var err error
+	switch keyType {
+	case coordinatorKeyGroup:
+		if key == "" {
+			res.errorCode, res.errorMessage = errors.ErrInvalidRequest, "Group ID must not be empty."
+			return res
+		}
+		meta, err = coordinator.EnsureOffsetsTopic(c.State)
+		idx = coordinator.PartitionForGroup(key)
+	case coordinatorKeyTransaction:
+		if key == "" {
+			res.errorCode, res.errorMessage = errors.ErrInvalidRequest, "Transactional ID must not be empty."
+			return res
+		}
+		meta, err = coordinator.EnsureTransactionTopic(c.State)
+		idx = coordinator.PartitionForTransactionalID(key)
+	default:
+		res.errorCode, res.errorMessage = errors.ErrInvalidRequest, "Unknown coordinator key type."
+		return res
+	}
+	if err != nil {
+		res.errorCode, res.errorMessage = errors.ErrCoordinatorNotAvailable, err.Error()
+		return res
+	}
+
+	leader := topic.Broker{ID: meta.Partition(idx).Leader}
+	for _, b := range c.State.LiveBrokers() {
+		if b.ID == leader.ID {
+			leader = b
+		}
+	}
+	host, port, ok := brokerEndpoint(c, leader)
+	if !ok {
+		res.errorCode, res.errorMessage = errors.ErrCoordinatorNotAvailable, "The coordinator is not available."
+		return res
+	}
+	res.nodeID, res.host, res.port = leader.ID, host, port
+	return res
+}
+
+// brokerEndpoint returns where a client on c's listener reaches b: this
+// broker's advertised endpoint for itself, and for a peer the endpoint it
+// registered under the same listener name. ok is false for a peer that
+// registered none.
+func brokerEndpoint(c *session.Connection, b topic.Broker) (host string, port int32, ok bool) {
+	if b.ID == topic.NodeID() {
+		endpoint := listener.Advertised(c.Listener)
+		return endpoint.Host, endpoint.Port, true
+	}
+	e, ok := b.Endpoints[c.Listener]
+	if !ok {
+		return "", -1, false
+	}
+	return e.Host, e.Port, true
+}
+
+func parseFindCoordinatorRequest(reqBody []byte, apiVersion int16) (int8, []string, error) {
+	br := parser.BytesReader{B: reqBody}
+
+	if apiVersion < 4 {
+		key := parser.ReadCompactString(&br)
+		keyType := parser.ReadInt8(&br)
+		return keyType, []string{key}, nil
+	}
+
+	keyType := parser.ReadInt8(&br)
+	nKeys, err := parser.ReadCompactArrayLen(&br, maxRequestTopics, 1)
+	if err != nil {
+		return keyType, nil, err
+	}
+	keys := make([]string, 0, max(nKeys, 0))
+	for i := 0; i < nKeys; i++ {
+		keys = append(keys, parser.ReadCompactString(&br))
+	}
+	return keyType, keys, nil
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import "github.com/codecrafters-io/kafka-starter-go/app/errors"
+
+const (
+	maxRequestTopics     = 10000
+	maxRequestPartitions = 10000
+	// maxCreatePartitions caps the partition count CreateTopics and
+	// CreatePartitions will give a topic, since each one costs a log
+	// directory and a metadata record.
+	maxCreatePartitions = 10000
+)
+
+// throttlingQuotaExceededMessage is the Java broker's message for topics
+// rejected by the controller mutation quota.
+const throttlingQuotaExceededMessage = "The throttling quota has been exceeded."
+
+// overLimit reports whether err rejects a request's top-level array only
+// for being longer than its limit. Its n entries still fit in the request,
+// so they are read and each is answered with POLICY_VIOLATION, which a
+// response without a top-level error code has no other way to report.
+func overLimit(n int, err error) bool {
+	return n > 0 && errors.CodeOf(err) == errors.ErrPolicyViolation
+}
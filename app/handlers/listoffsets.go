@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+const (
+	latestTimestamp        = int64(-1)
+	earliestTimestamp      = int64(-2)
+	maxTimestamp           = int64(-3)
+	earliestLocalTimestamp = int64(-4)
+)
+
+type ListOffsetsTopicRequest struct {
+	Name       string
+	Partitions []ListOffsetsPartitionRequest
+}
+
+type ListOffsetsPartitionRequest struct {
+	Index     int32
+	Timestamp int64
+}
+
+type listOffsetsPartitionResult struct {
+	errorCode   int16
+	timestamp   int64
+	offset      int64
+	leaderEpoch int32
+}
+
+// listOffsetsWorkers bounds how many partitions one ListOffsets request
+// resolves at once. Lookups by timestamp read a partition's indexes and scan
+// its log, so a consumer group starting from a timestamp would otherwise wait
+// on hundreds of them in turn.
+const listOffsetsWorkers = 8
+
+// HandleListOffsets serves the flexible ListOffsets versions (v6 through v9):
+// the earliest and latest special timestamps, and lookups by time.
+func HandleListOffsets(corrID int32, apiVersion int16, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	topicRequests, parseErr := parseListOffsetsRequest(reqBody)
+	if parseErr != nil && len(topicRequests) == 0 {
+		return nil, errors.From(parseErr)
+	}
+
+	results := make([][]listOffsetsPartitionResult, len(topicRequests))
+	var lookups []func()
+	for i, topicReq := range topicRequests {
+		c.State.RLock()
+		meta, exists := c.State.Topics[topicReq.Name]
+		c.State.RUnlock()
+
+		results[i] = make([]listOffsetsPartitionResult, len(topicReq.Partitions))
+		for j, partReq := range topicReq.Partitions {
+			res := &results[i][j]
+			switch {
+			case parseErr != nil:
+				*res = listOffsetsFailure(errors.CodeOf(parseErr))
+			case !exists || partReq.Index < 0 || partReq.Index >= int32(max(meta.Partitions, 1)):
+				*res = listOffsetsFailure(errors.ErrUnknownTopicOrPartition)
+			default:
+				lookups = append(lookups, func() {
+					*res = listOffsetsPartition(topicReq.Name, meta, partReq, apiVersion)
+				})
+			}
+		}
+	}
+	runBounded(lookups, listOffsetsWorkers)
+
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	body := parser.AppendInt32(nil, 0)
+	body = parser.AppendUVarInt(body, uint32(len(topicRequests)+1))
+
+	for i, topicReq := range topicRequests {
+		body = parser.AppendCompactString(body, topicReq.Name)
+		body = parser.AppendUVarInt(body, uint32(len(topicReq.Partitions)+1))
+		for j, partReq := range topicReq.Partitions {
+			res := results[i][j]
+			body = parser.AppendInt32(body, partReq.Index)
+			body = parser.AppendInt16(body, res.errorCode)
+			body = parser.AppendInt64(body, res.timestamp)
+			body = parser.AppendInt64(body, res.offset)
+			body = parser.AppendInt32(body, res.leaderEpoch)
+			body = parser.AppendUVarInt(body, 0)
+		}
+		body = parser.AppendUVarInt(body, 0)
+	}
+
+	body = parser.AppendUVarInt(body, 0)
+
+	return frameResponse(header, body), nil
+}
+
+// runBounded calls each of fns on at most workers goroutines and returns
+// once they have all finished.
+func runBounded(fns []func(), workers int) {
+	if len(fns) <= 1 {
+		for _, fn := range fns {
+			fn()
+		}
+		return
+	}
+	next := make(chan func())
+	var wg sync.WaitGroup
+	for range min(workers, len(fns)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fn := range next {
+				fn()
+			}
+		}()
+	}
+	for _, fn := range fns {
+		next <- fn
+	}
+	close(next)
+	wg.Wait()
+}
+
+// listOffsetsPartition resolves a special timestamp against the offsets
+// scanned from the partition's log, and a concrete one against its time
+// indexes: the answer is the first record stamped at or after it, or -1 when
+// none is. Earliest-local (-4, v9+) is the same as earliest because every
+// segment is local. Max-timestamp (-3, v7+) is the record stamped latest,
+// as KIP-734 defines it. The leader epoch is the one the partition's leader-epoch
+// checkpoint gives the offset, or the current one from metadata for a log
+// without a checkpoint.
+func listOffsetsPartition(topicName string, meta topic.Meta, req ListOffsetsPartitionRequest, apiVersion int16) listOffsetsPartitionResult {
+	offsets := partition.GetOffsets(topicName, req.Index)
+	res := listOffsetsPartitionResult{errorCode: errors.ErrNone, timestamp: -1}
+	switch {
+	case req.Timestamp == latestTimestamp:
+		res.offset = offsets.HighWatermark
+	case req.Timestamp == earliestTimestamp, req.Timestamp == earliestLocalTimestamp && apiVersion >= 9:
+		res.offset = offsets.LogStartOffset
+	case req.Timestamp == maxTimestamp && apiVersion >= 7:
+		res.offset = -1
+		if offset, ts, ok := partition.MaxTimestampOffset(topicName, req.Index); ok && offset < offsets.HighWatermark {
+			res.offset, res.timestamp = offset, ts
+		}
+	case req.Timestamp >= 0:
+		res.offset = -1
+		if offset, ts, ok := partition.OffsetForTimestamp(topicName, req.Index, req.Timestamp); ok && offset < offsets.HighWatermark {
+			res.offset, res.timestamp = offset, ts
+		}
+	default:
+		return listOffsetsFailure(errors.ErrInvalidRequest)
+	}
+
+	res.leaderEpoch = -1
+	if res.offset >= 0 {
+		epoch, ok := partition.EpochForOffset(topicName, req.Index, res.offset)
+		if !ok {
+			epoch = meta.Partition(req.Index).LeaderEpoch
+		}
+		res.leaderEpoch = epoch
+	}
+	return res
+}
+
+func listOffsetsFailure(code int16) listOffsetsPartitionResult {
+	return listOffsetsPartitionResult{errorCode: code, timestamp: -1, offset: -1, leaderEpoch: -1}
+}
+
+func parseListOffsetsRequest(reqBody []byte) ([]ListOffsetsTopicRequest, error) {
+	br := parser.BytesReader{B: reqBody}
+
+	_ = parser.ReadInt32(&br)
+	_ = parser.ReadInt8(&br)
+
+	nTopics, limitErr := parser.ReadCompactArrayLen(&br, maxRequestTopics, 3)
+	if limitErr != nil && !overLimit(nTopics, limitErr) || nTopics < 0 {
+		return nil, limitErr
+	}
+
+	topicRequests := make([]ListOffsetsTopicRequest, 0, nTopics)
+	for i := 0; i < nTopics; i++ {
+		topicReq := ListOffsetsTopicRequest{}
+		topicReq.Name = parser.ReadCompactString(&br)
+
+		nPartitions, err := parser.ReadCompactArrayLen(&br, maxRequestPartitions, 17)
+		if err != nil {
+			return topicRequests, err
+		}
+		topicReq.Partitions = make([]ListOffsetsPartitionRequest, 0, max(nPartitions, 0))
+		for j := 0; j < nPartitions; j++ {
+			partReq := ListOffsetsPartitionRequest{}
+			partReq.Index = parser.ReadInt32(&br)
+			_ = parser.ReadInt32(&br)
+			partReq.Timestamp = parser.ReadInt64(&br)
+			_ = parser.ReadUVarInt(&br)
+			topicReq.Partitions = append(topicReq.Partitions, partReq)
+		}
+		_ = parser.ReadUVarInt(&br)
+		topicRequests = append(topicRequests, topicReq)
+	}
+
+	return topicRequests, limitErr
+}
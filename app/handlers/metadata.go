@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"sort"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/acl"
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+type MetadataTopicRequest struct {
+	ID       [16]byte
+	Name     string
+	NullName bool
+}
+
+type MetadataRequest struct {
+	Topics                           []MetadataTopicRequest
+	AllTopics                        bool
+	AllowAutoTopicCreation           bool
+	IncludeTopicAuthorizedOperations bool
+}
+
+// HandleMetadata serves Metadata v12 and v13. A null topic list describes
+// every topic; otherwise only the requested topics are answered, looked up by
+// name or, when the name is null, by topic ID. Unknown names are created with
+// the default layout when the client allows auto-creation.
+func HandleMetadata(corrID int32, apiVersion int16, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	state := c.State
+
+	req, parseErr := parseMetadataRequest(reqBody)
+	if parseErr != nil {
+		return nil, errors.From(parseErr)
+	}
+
+	if req.AllowAutoTopicCreation {
+		for _, t := range req.Topics {
+			if t.NullName {
+				continue
+			}
+			state.RLock()
+			_, exists := state.Topics[t.Name]
+			state.RUnlock()
+			if !exists {
+				createTopic(CreateTopicRequest{Name: t.Name, NumPartitions: -1, ReplicationFactor: -1}, false, state)
+			}
+		}
+	}
+
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	body := parser.AppendInt32(nil, 0)
+
+	// Brokers not reachable on this listener are left out, as the Java
+	// broker does.
+	var brokers []byte
+	nBrokers := 0
+	for _, b := range state.LiveBrokers() {
+		host, port, ok := brokerEndpoint(c, b)
+		if !ok {
+			continue
+		}
+		brokers = parser.AppendInt32(brokers, b.ID)
+		brokers = parser.AppendCompactString(brokers, host)
+		brokers = parser.AppendInt32(brokers, port)
+		brokers = parser.AppendCompactNullableString(brokers, b.Rack, b.Rack == "")
+		brokers = parser.AppendUVarInt(brokers, 0)
+		nBrokers++
+	}
+	body = parser.AppendUVarInt(body, uint32(nBrokers+1))
+	body = append(body, brokers...)
+	body = parser.AppendCompactNullableString(body, "", true)
+	body = parser.AppendInt32(body, topic.NodeID())
+
+	state.RLock()
+	defer state.RUnlock()
+
+	reqTopics := req.Topics
+	if req.AllTopics {
+		names := make([]string, 0, len(state.Topics))
+		for name := range state.Topics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		reqTopics = make([]MetadataTopicRequest, 0, len(names))
+		for _, name := range names {
+			reqTopics = append(reqTopics, MetadataTopicRequest{Name: name})
+		}
+	}
+
+	body = parser.AppendUVarInt(body, uint32(len(reqTopics)+1))
+	for _, t := range reqTopics {
+		name, meta, errorCode := lookupMetadataTopic(state, t)
+
+		body = parser.AppendInt16(body, errorCode)
+		body = parser.AppendCompactNullableString(body, name, name == "" && t.NullName)
+		body = append(body, meta.ID[:]...)
+		body = parser.AppendBool(body, meta.Internal)
+
+		if errorCode != errors.ErrNone {
+			body = parser.AppendUVarInt(body, 1)
+			body = parser.AppendInt32(body, acl.OmittedOperations)
+			body = parser.AppendUVarInt(body, 0)
+			continue
+		}
+
+		numPartitions := max(meta.Partitions, 1)
+		body = parser.AppendUVarInt(body, uint32(numPartitions+1))
+		for idx := 0; idx < numPartitions; idx++ {
+			partErr, part := partitionStatus(c, name, meta.Partition(int32(idx)))
+			body = parser.AppendInt16(body, partErr)
+			body = parser.AppendInt32(body, part.Index)
+			body = parser.AppendInt32(body, part.Leader)
+			body = parser.AppendInt32(body, part.LeaderEpoch)
+			body = parser.AppendCompactInt32Array(body, part.Replicas)
+			body = parser.AppendCompactInt32Array(body, part.ISR)
+			body = parser.AppendCompactInt32Array(body, part.OfflineReplicas)
+			body = parser.AppendUVarInt(body, 0)
+		}
+
+		authorizedOps := acl.OmittedOperations
+		if req.IncludeTopicAuthorizedOperations {
+			authorizedOps = acl.TopicAuthorizedOperations(c.Principal, name)
+		}
+		body = parser.AppendInt32(body, authorizedOps)
+		body = parser.AppendUVarInt(body, 0)
+	}
+
+	if apiVersion >= 13 {
+		body = parser.AppendInt16(body, errors.ErrNone)
+	}
+	body = parser.AppendUVarInt(body, 0)
+
+	return frameResponse(header, body), nil
+}
+
+// lookupMetadataTopic resolves one requested topic; the caller holds the
+// state read lock.
+func lookupMetadataTopic(state *topic.BrokerState, t MetadataTopicRequest) (string, topic.Meta, int16) {
+	if t.NullName {
+		if name, meta, ok := state.TopicByID(t.ID); ok {
+			return name, meta, errors.ErrNone
+		}
+		return "", topic.Meta{ID: t.ID}, errors.ErrUnknownTopicID
+	}
+	meta, exists := state.Topics[t.Name]
+	if !exists {
+		if topic.ValidateName(t.Name) != nil {
+			return t.Name, topic.Meta{}, errors.ErrInvalidTopicException
+		}
+		return t.Name, topic.Meta{}, errors.ErrUnknownTopicOrPartition
+	}
+	return t.Name, meta, errors.ErrNone
+}
+
+func parseMetadataRequest(reqBody []byte) (MetadataRequest, error) {
+	br := parser.BytesReader{B: reqBody}
+	req := MetadataRequest{}
+
+	nTopics, err := parser.ReadCompactArrayLen(&br, maxRequestTopics, 18)
+	if err != nil {
+		return req, err
+	}
+	req.AllTopics = nTopics < 0
+	for i := 0; i < nTopics; i++ {
+		t := MetadataTopicRequest{}
+		if br.CanRead(16) {
+			copy(t.ID[:], br.B[br.Off:br.Off+16])
+			br.Off += 16
+		}
+		t.Name, t.NullName = parser.ReadCompactNullableString(&br)
+		_ = parser.ReadUVarInt(&br)
+		req.Topics = append(req.Topics, t)
+	}
+
+	req.AllowAutoTopicCreation = parser.ReadInt8(&br) != 0
+	req.IncludeTopicAuthorizedOperations = parser.ReadInt8(&br) != 0
+	if br.Short {
+		return req, errors.NewKafkaError(errors.ErrInvalidRequest, "metadata request is truncated")
+	}
+	return req, nil
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"github.com/codecrafters-io/kafka-starter-go/app/coordinator"
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+)
+
+type OffsetCommitRequest struct {
+	GroupID string
+	Topics  []OffsetCommitTopic
+}
+
+type OffsetCommitTopic struct {
+	Name       string
+	Partitions []OffsetCommitPartition
+}
+
+type OffsetCommitPartition struct {
+	Index       int32
+	Offset      int64
+	LeaderEpoch int32
+	Metadata    string
+}
+
+// HandleOffsetCommit serves the flexible OffsetCommit versions (v8 and v9).
+// Group membership isn't tracked, so the generation and member ID are not
+// checked; every commit for a known topic partition is accepted and written
+// to the group's offsets checkpoint before the response goes out. A broker
+// that doesn't lead the group's __consumer_offsets partition answers
+// NOT_COORDINATOR so the client looks the coordinator up again.
+func HandleOffsetCommit(corrID int32, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	req, parseErr := parseOffsetCommitRequest(reqBody)
+	if parseErr != nil && len(req.Topics) == 0 {
+		return nil, errors.From(parseErr)
+	}
+
+	coordinated := req.GroupID == "" || coordinator.IsGroupCoordinator(c.State, req.GroupID)
+	codes := make([][]int16, len(req.Topics))
+	commits := map[coordinator.TopicPartition]coordinator.CommittedOffset{}
+	for i, topicReq := range req.Topics {
+		c.State.RLock()
+		meta, exists := c.State.Topics[topicReq.Name]
+		c.State.RUnlock()
+
+		codes[i] = make([]int16, len(topicReq.Partitions))
+		for j, partReq := range topicReq.Partitions {
+			switch {
+			case parseErr != nil:
+				codes[i][j] = errors.CodeOf(parseErr)
+			case req.GroupID == "":
+				codes[i][j] = errors.ErrInvalidGroupID
+			case !coordinated:
+				codes[i][j] = errors.ErrNotCoordinator
+			case !exists || partReq.Index < 0 || partReq.Index >= int32(max(meta.Partitions, 1)):
+				codes[i][j] = errors.ErrUnknownTopicOrPartition
+			case len(partReq.Metadata) > coordinator.MaxOffsetMetadataBytes:
+				codes[i][j] = errors.ErrOffsetMetadataTooLarge
+			default:
+				commits[coordinator.TopicPartition{Topic: topicReq.Name, Partition: partReq.Index}] = coordinator.CommittedOffset{
+					Offset:      partReq.Offset,
+					LeaderEpoch: partReq.LeaderEpoch,
+					Metadata:    partReq.Metadata,
+				}
+			}
+		}
+	}
+
+	if len(commits) > 0 {
+		if err := coordinator.CommitOffsets(req.GroupID, commits); err != nil {
+			code := errors.From(err).Code
+			for i := range codes {
+				for j := range codes[i] {
+					if codes[i][j] == errors.ErrNone {
+						codes[i][j] = code
+					}
+				}
+			}
+		}
+	}
+
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	body := parser.AppendInt32(nil, 0)
+	body = parser.AppendUVarInt(body, uint32(len(req.Topics)+1))
+	for i, topicReq := range req.Topics {
+		body = parser.AppendCompactString(body, topicReq.Name)
+		body = parser.AppendUVarInt(body, uint32(len(topicReq.Partitions)+1))
+		for j, partReq := range topicReq.Partitions {
+			body = parser.AppendInt32(body, partReq.Index)
+			body = parser.AppendInt16(body, codes[i][j])
+			body = parser.AppendUVarInt(body, 0)
+		}
+		body = parser.AppendUVarInt(body, 0)
+	}
+	body = parser.AppendUVarInt(body, 0)
+
+	return frameResponse(header, body), nil
+}
+
+func parseOffsetCommitRequest(reqBody []byte) (OffsetCommitRequest, error) {
+	br := parser.BytesReader{B: reqBody}
+	req := OffsetCommitRequest{}
+
+	req.GroupID = parser.ReadCompactString(&br)
+	_ = parser.ReadInt32(&br)
+	_ = parser.ReadCompactString(&br)
+	_, _ = parser.ReadCompactNullableString(&br)
+
+	nTopics, limitErr := parser.ReadCompactArrayLen(&br, maxRequestTopics, 3)
+	if limitErr != nil && !overLimit(nTopics, limitErr) || nTopics < 0 {
+		return req, limitErr
+	}
+	for i := 0; i < nTopics; i++ {
+		topicReq := OffsetCommitTopic{}
+		topicReq.Name = parser.ReadCompactString(&br)
+
+		nPartitions, err := parser.ReadCompactArrayLen(&br, maxRequestPartitions, 18)
+		if err != nil {
+			return req, err
+		}
+		topicReq.Partitions = make([]OffsetCommitPartition, 0, max(nPartitions, 0))
+		for j := 0; j < nPartitions; j++ {
+			partReq := OffsetCommitPartition{}
+			partReq.Index = parser.ReadInt32(&br)
+			partReq.Offset = parser.ReadInt64(&br)
+			partReq.LeaderEpoch = parser.ReadInt32(&br)
+			partReq.Metadata, _ = parser.ReadCompactNullableString(&br)
+			_ = parser.ReadUVarInt(&br)
+			topicReq.Partitions = append(topicReq.Partitions, partReq)
+		}
+		_ = parser.ReadUVarInt(&br)
+		req.Topics = append(req.Topics, topicReq)
+	}
+
+	return req, limitErr
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"sort"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/coordinator"
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+)
+
+type OffsetFetchGroup struct {
+	GroupID string
+	// Topics is nil when the request asks for every committed offset of
+	// the group.
+	Topics []OffsetFetchTopic
+}
+
+type OffsetFetchTopic struct {
+	Name       string
+	Partitions []int32
+}
+
+// HandleOffsetFetch serves the flexible OffsetFetch versions (v6 through v9).
+// Up to v7 a request names a single group; from v8 it carries a list of
+// groups and the response nests the topics under each. Groups coordinated
+// by another broker are answered with NOT_COORDINATOR. A v8+ request that
+// fails to parse part way answers each group it read with the error.
+func HandleOffsetFetch(corrID int32, apiVersion int16, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	multiGroup := apiVersion >= 8
+
+	groupRequests, parseErr := parseOffsetFetchRequest(reqBody, apiVersion)
+	if parseErr != nil && (!multiGroup || len(groupRequests) == 0) {
+		return nil, errors.From(parseErr)
+	}
+
+	header := parser.AppendInt32(nil, corrID)
+	header = parser.AppendUVarInt(header, 0)
+
+	body := parser.AppendInt32(nil, 0)
+	if multiGroup {
+		body = parser.AppendUVarInt(body, uint32(len(groupRequests)+1))
+	}
+	for _, group := range groupRequests {
+		errorCode := errors.ErrNone
+		var committed map[coordinator.TopicPartition]coordinator.CommittedOffset
+		if parseErr != nil {
+			errorCode = errors.CodeOf(parseErr)
+			group.Topics = []OffsetFetchTopic{}
+		} else if group.GroupID == "" {
+			errorCode = errors.ErrInvalidGroupID
+		} else if !coordinator.IsGroupCoordinator(c.State, group.GroupID) {
+			errorCode = errors.ErrNotCoordinator
+		} else if offsets, err := coordinator.FetchOffsets(group.GroupID); err != nil {
+			errorCode = errors.From(err).Code
+		} else {
+			committed = offsets
+		}
+
+		topics := group.Topics
+		if topics == nil {
+			topics = committedTopics(committed)
+		}
+
+		if multiGroup {
+			body = parser.AppendCompactString(body, group.GroupID)
+		}
+		body = parser.AppendUVarInt(body, uint32(len(topics)+1))
+		for _, t := range topics {
+			body = parser.AppendCompactString(body, t.Name)
+			body = parser.AppendUVarInt(body, uint32(len(t.Partitions)+1))
+			for _, idx := range t.Partitions {
+				o, ok := committed[coordinator.TopicPartition{Topic: t.Name, Partition: idx}]
+				if !ok {
+					o = coordinator.CommittedOffset{Offset: -1, LeaderEpoch: -1}
+				}
+				body = parser.AppendInt32(body, idx)
+				body = parser.AppendInt64(body, o.Offset)
+				body = parser.AppendInt32(body, o.LeaderEpoch)
+				body = parser.AppendCompactNullableString(body, o.Metadata, false)
+				body = parser.AppendInt16(body, errors.ErrNone)
+				body = parser.AppendUVarInt(body, 0)
+			}
+			body = parser.AppendUVarInt(body, 0)
+		}
+		body = parser.AppendInt16(body, errorCode)
+		if multiGroup {
+			body = parser.AppendUVarInt(body, 0)
+		}
+	}
+	body = parser.AppendUVarInt(body, 0)
+
+	return frameResponse(header, body), nil
+}
+
+// committedTopics lists every partition the group has committed, in topic
+// and partition order, for requests that leave the topic list null.
+func committedTopics(committed map[coordinator.TopicPartition]coordinator.CommittedOffset) []OffsetFetchTopic {
+	byTopic := map[string][]int32{}
+	for tp := range committed {
+		byTopic[tp.Topic] = append(byTopic[tp.Topic], tp.Partition)
+	}
+	topics := make([]OffsetFetchTopic, 0, len(byTopic))
+	for name, parts := range byTopic {
+		sort.Slice(parts, func(i, j int) bool { return parts[i] < parts[j] })
+		topics = append(topics, OffsetFetchTopic{Name: name, Partitions: parts})
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	return topics
+}
+
+func parseOffsetFetchRequest(reqBody []byte, apiVersion int16) ([]OffsetFetchGroup, error) {
+	br := parser.BytesReader{B: reqBody}
+
+	if apiVersion < 8 {
+		group := OffsetFetchGroup{GroupID: parser.ReadCompactString(&br)}
+		topics, err := parseOffsetFetchTopics(&br)
+		group.Topics = topics
+		return []OffsetFetchGroup{group}, err
+	}
+
+	nGroups, limitErr := parser.ReadCompactArrayLen(&br, maxRequestTopics, 3)
+	if limitErr != nil && !overLimit(nGroups, limitErr) || nGroups < 0 {
+		return nil, limitErr
+	}
+	groups := make([]OffsetFetchGroup, 0, nGroups)
+	for i := 0; i < nGroups; i++ {
+		group := OffsetFetchGroup{GroupID: parser.ReadCompactString(&br)}
+		if apiVersion >= 9 {
+			_, _ = parser.ReadCompactNullableString(&br)
+			_ = parser.ReadInt32(&br)
+		}
+		topics, err := parseOffsetFetchTopics(&br)
+		if err != nil {
+			return groups, err
+		}
+		group.Topics = topics
+		_ = parser.ReadUVarInt(&br)
+		groups = append(groups, group)
+	}
+	return groups, limitErr
+}
+
+// parseOffsetFetchTopics reads a nullable topic list; null means every topic.
+func parseOffsetFetchTopics(br *parser.BytesReader) ([]OffsetFetchTopic, error) {
+	nTopics, err := parser.ReadCompactArrayLen(br, maxRequestTopics, 3)
+	if err != nil || nTopics < 0 {
+		return nil, err
+	}
+	topics := make([]OffsetFetchTopic, 0, nTopics)
+	for i := 0; i < nTopics; i++ {
+		t := OffsetFetchTopic{Name: parser.ReadCompactString(br)}
+		t.Partitions = parser.ReadCompactInt32Array(br)
+		_ = parser.ReadUVarInt(br)
+		topics = append(topics, t)
+	}
+	return topics, nil
+}
@@ -1,14 +1,23 @@
 package handlers
 
 import (
+	"math/rand/v2"
+	"time"
+
 	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
 	"github.com/codecrafters-io/kafka-starter-go/app/parser"
 	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/purgatory"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/stats"
 	"github.com/codecrafters-io/kafka-starter-go/app/topic"
 )
 
 type ProduceTopicRequest struct {
 	Name       string
+	ID         [16]byte
 	Partitions []ProducePartitionRequest
 }
 
@@ -17,102 +26,304 @@ type ProducePartitionRequest struct {
 	Records []byte
 }
 
-func HandleProduceV11(corrID int32, reqBody []byte, state *topic.BrokerState) []byte {
-	topicRequests := parseProduceRequestV11(reqBody)
+// producePartitionResult is the outcome of validating and appending one
+// partition's records; it maps directly onto a partition_responses entry.
+type producePartitionResult struct {
+	errorCode      int16
+	baseOffset     int64
+	logAppendTime  int64
+	logStartOffset int64
+}
 
-	header := parser.AppendInt32(nil, corrID)
-	header = parser.AppendUVarInt(header, 0)
+// HandleProduce serves Produce v3 through v13. Topics are addressed by name
+// up to v12 and by topic ID from v13 onwards; the encoding is flexible from v9.
+func HandleProduce(corrID int32, apiVersion int16, reqBody []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	byID := apiVersion >= 13
 
-	body := parser.AppendUVarInt(nil, uint32(len(topicRequests)+1))
+	acks, topicRequests, parseErr := parseProduceRequest(reqBody, apiVersion)
+	if parseErr != nil && len(topicRequests) == 0 {
+		return nil, errors.From(parseErr)
+	}
 
-	for _, topicReq := range topicRequests {
-		body = parser.AppendCompactString(body, topicReq.Name)
+	// A partition named twice would be appended twice, with two answers
+	// the client cannot tell apart; every repeat is refused instead.
+	type partitionKey struct {
+		name  string
+		id    [16]byte
+		index int32
+	}
+	seen := map[partitionKey]bool{}
 
-		topicMeta, topicExists := state.Topics[topicReq.Name]
+	results := make([][]producePartitionResult, len(topicRequests))
+	for i, topicReq := range topicRequests {
+		topicName, exists := resolveTopic(c.State, topicReq.Name, topicReq.ID, byID)
+		results[i] = make([]producePartitionResult, len(topicReq.Partitions))
+		for j, partReq := range topicReq.Partitions {
+			key := partitionKey{name: topicReq.Name, id: topicReq.ID, index: partReq.Index}
+			duplicate := seen[key]
+			seen[key] = true
 
-		body = parser.AppendUVarInt(body, uint32(len(topicReq.Partitions)+1))
+			var res producePartitionResult
+			if duplicate {
+				res = producePartitionResult{errorCode: errors.ErrInvalidRequest, baseOffset: -1, logAppendTime: -1, logStartOffset: -1}
+			} else if !exists && byID && parseErr == nil {
+				res = producePartitionResult{errorCode: errors.ErrUnknownTopicID, baseOffset: -1, logAppendTime: -1, logStartOffset: -1}
+			} else {
+				res = validateProducePartition(c.State, topicName, partReq, acks, parseErr)
+			}
+			if res.errorCode == errors.ErrNone {
+				res = appendProducePartition(c, topicName, partReq)
+				if res.errorCode == errors.ErrNone && acks == -1 && !hasMinInsyncReplicas(c.State, topicName, partReq.Index) {
+					res.errorCode = errors.ErrNotEnoughReplicasAfterAppend
+				}
+			}
+			results[i][j] = res
+		}
+	}
 
-		for _, partReq := range topicReq.Partitions {
-			errorCode := errors.ErrUnknownTopicOrPartition
-			baseOffset := int64(-1)
-			logAppendTime := int64(-1)
-			logStartOffset := int64(-1)
+	throttleMs := quota.ProduceThrottleMs(c.ClientID)
+	c.Throttle(throttleMs)
 
-			if topicExists {
-				numPartitions := topicMeta.Partitions
-				if numPartitions == 0 {
-					numPartitions = 1
-				}
+	return encodeProduceResponse(corrID, apiVersion, topicRequests, results, throttleMs), nil
+}
 
-				if partReq.Index >= 0 && partReq.Index < int32(numPartitions) {
-					if err := partition.WriteRecords(topicReq.Name, partReq.Index, partReq.Records); err == nil {
-						errorCode = errors.ErrNone
-						baseOffset = 0
-						logAppendTime = -1
-						logStartOffset = 0
-					}
-				}
-			}
+func validateProducePartition(state *topic.BrokerState, topicName string, partReq ProducePartitionRequest, acks int16, parseErr error) producePartitionResult {
+	res := producePartitionResult{errorCode: errors.ErrNone, baseOffset: -1, logAppendTime: -1, logStartOffset: -1}
+	if parseErr != nil {
+		res.errorCode = errors.CodeOf(parseErr)
+		return res
+	}
+
+	state.RLock()
+	topicMeta, exists := state.Topics[topicName]
+	partMeta := topicMeta.Partition(partReq.Index)
+	state.RUnlock()
+
+	numPartitions := topicMeta.Partitions
+	if numPartitions == 0 {
+		numPartitions = 1
+	}
+	if !exists || partReq.Index < 0 || partReq.Index >= int32(numPartitions) {
+		res.errorCode = errors.ErrUnknownTopicOrPartition
+		return res
+	}
+
+	if err := partition.ValidateBatches(partReq.Records, partition.MaxMessageBytes); err != nil {
+		res.errorCode = errors.CodeOf(err)
+		return res
+	}
+
+	before, after := topicMeta.TimestampWindow()
+	if err := partition.ValidateTimestamps(partReq.Records, time.Now().UnixMilli(), before, after); err != nil {
+		res.errorCode = errors.CodeOf(err)
+		return res
+	}
+
+	if acks == -1 && len(partMeta.ISR) < topicMeta.MinInsyncReplicas() {
+		res.errorCode = errors.ErrNotEnoughReplicas
+	}
+	return res
+}
+
+// hasMinInsyncReplicas re-checks the ISR once an acks=all write has been
+// appended; if it shrank below min.insync.replicas in the meantime the records
+// stay in the log but the producer is told they are not safely replicated.
+func hasMinInsyncReplicas(state *topic.BrokerState, topicName string, index int32) bool {
+	state.RLock()
+	defer state.RUnlock()
+	topicMeta, exists := state.Topics[topicName]
+	if !exists {
+		return false
+	}
+	return len(topicMeta.Partition(index).ISR) >= topicMeta.MinInsyncReplicas()
+}
+
+func appendProducePartition(c *session.Connection, topicName string, partReq ProducePartitionRequest) producePartitionResult {
+	c.State.RLock()
+	topicMeta := c.State.Topics[topicName]
+	c.State.RUnlock()
+
+	if latency, errorRate, errorCode := topicMeta.ProduceFault(); latency > 0 || errorRate > 0 {
+		time.Sleep(latency)
+		if rand.Float64() < errorRate {
+			logger.Debug("%s: injecting %s into append to %s-%d", c, errors.Name(errorCode), topicName, partReq.Index)
+			return producePartitionResult{errorCode: errorCode, baseOffset: -1, logAppendTime: -1, logStartOffset: -1}
+		}
+	}
+
+	records := partReq.Records
+	if codec, ok := topicMeta.CompressionCodec(); ok {
+		recompressed, err := partition.RecompressBatches(records, codec)
+		if err != nil {
+			logger.Warn("%s: recompressing batches for %s-%d failed: %v", c, topicName, partReq.Index, err)
+			return producePartitionResult{errorCode: errors.ErrCorruptMessage, baseOffset: -1, logAppendTime: -1, logStartOffset: -1}
+		}
+		records = recompressed
+	}
+
+	logAppendTime := topicMeta.LogAppendTime()
+	var appendTime int64 = -1
+	if logAppendTime {
+		appendTime = time.Now().UnixMilli()
+		partition.StampLogAppendTime(records, appendTime)
+	}
+
+	appended, err := partition.Logs.Append(topicName, partReq.Index, records)
+	if err != nil {
+		logger.Warn("%s: append to %s-%d failed: %v", c, topicName, partReq.Index, err)
+		return producePartitionResult{errorCode: errors.From(err).Code, baseOffset: -1, logAppendTime: -1, logStartOffset: -1}
+	}
+	if logAppendTime {
+		appended.LogAppendTime = appendTime
+	}
+	if discardIfDeleted(c.State, topicName, partReq.Index) {
+		return producePartitionResult{errorCode: errors.ErrUnknownTopicOrPartition, baseOffset: -1, logAppendTime: -1, logStartOffset: -1}
+	}
+
+	stats.Default.RecordBytesIn(topicName, c.ClientID, len(partReq.Records))
+	purgatory.Default.CheckAndComplete(purgatory.PartitionKey(topicName, partReq.Index))
+
+	return producePartitionResult{
+		errorCode:      errors.ErrNone,
+		baseOffset:     appended.BaseOffset,
+		logAppendTime:  appended.LogAppendTime,
+		logStartOffset: appended.LogStartOffset,
+	}
+}
+
+// discardIfDeleted removes a partition log that an append recreated after
+// its topic was deleted, so the records cannot resurface in a topic later
+// created under the same name, and reports whether it did. The check and the
+// removal hold the state lock, under whose write lock a recreated topic's
+// directories are made, so they never touch a new topic's log.
+func discardIfDeleted(state *topic.BrokerState, topicName string, index int32) bool {
+	state.RLock()
+	defer state.RUnlock()
+	if _, exists := state.Topics[topicName]; exists {
+		return false
+	}
+	if err := partition.DeleteLogDir(topicName, index); err != nil {
+		logger.Warn("removing %s-%d after its topic was deleted failed: %v", topicName, index, err)
+	}
+	return true
+}
+
+func encodeProduceResponse(corrID int32, apiVersion int16, topicRequests []ProduceTopicRequest, results [][]producePartitionResult, throttleMs int32) []byte {
+	byID := apiVersion >= 13
+	flexible := apiVersion >= 9
 
+	header := parser.AppendInt32(nil, corrID)
+	header = appendTaggedFields(header, flexible)
+
+	body := appendArrayLen(nil, len(topicRequests), flexible)
+	for i, topicReq := range topicRequests {
+		if byID {
+			body = append(body, topicReq.ID[:]...)
+		} else {
+			body = appendString(body, topicReq.Name, flexible)
+		}
+		body = appendArrayLen(body, len(topicReq.Partitions), flexible)
+
+		for j, partReq := range topicReq.Partitions {
+			res := results[i][j]
 			body = parser.AppendInt32(body, partReq.Index)
-			body = parser.AppendInt16(body, errorCode)
-			body = parser.AppendInt64(body, baseOffset)
-			body = parser.AppendInt64(body, logAppendTime)
-			body = parser.AppendInt64(body, logStartOffset)
-			body = parser.AppendUVarInt(body, 1)
-			body = parser.AppendCompactString(body, "")
-			body = parser.AppendUVarInt(body, 0)
+			body = parser.AppendInt16(body, res.errorCode)
+			body = parser.AppendInt64(body, res.baseOffset)
+			body = parser.AppendInt64(body, res.logAppendTime)
+			if apiVersion >= 5 {
+				body = parser.AppendInt64(body, res.logStartOffset)
+			}
+			if apiVersion >= 8 {
+				body = appendArrayLen(body, 0, flexible)
+				body = appendNullableString(body, "", !flexible, flexible)
+			}
+			body = appendTaggedFields(body, flexible)
 		}
 
-		body = parser.AppendUVarInt(body, 0)
+		body = appendTaggedFields(body, flexible)
 	}
 
-	body = parser.AppendInt32(body, 0)
-	body = parser.AppendUVarInt(body, 0)
+	body = parser.AppendInt32(body, throttleMs)
+	body = appendTaggedFields(body, flexible)
 
 	return frameResponse(header, body)
 }
 
-func parseProduceRequestV11(reqBody []byte) []ProduceTopicRequest {
+func parseProduceRequest(reqBody []byte, apiVersion int16) (int16, []ProduceTopicRequest, error) {
+	flexible := apiVersion >= 9
 	br := parser.BytesReader{B: reqBody}
 
-	_, _ = parser.ReadCompactNullableString(&br)
-	_ = parser.ReadUVarInt(&br)
-	_ = parser.ReadInt16(&br)
+	_, _ = readNullableString(&br, flexible)
+	acks := parser.ReadInt16(&br)
 	_ = parser.ReadInt32(&br)
 
-	nTopics := int(parser.ReadUVarInt(&br)) - 1
-	if nTopics < 0 {
-		return nil
+	minTopicSize := 3
+	if apiVersion >= 13 {
+		minTopicSize = 18
+	} else if !flexible {
+		minTopicSize = 6
+	}
+	nTopics, limitErr := readArrayLen(&br, maxRequestTopics, minTopicSize, flexible)
+	if limitErr != nil && !overLimit(nTopics, limitErr) || nTopics < 0 {
+		return acks, nil, limitErr
+	}
+
+	minPartitionSize := 6
+	if !flexible {
+		minPartitionSize = 8
 	}
 
 	topicRequests := make([]ProduceTopicRequest, 0, nTopics)
 	for i := 0; i < nTopics; i++ {
 		topicReq := ProduceTopicRequest{}
-		topicReq.Name = parser.ReadCompactString(&br)
+		if apiVersion >= 13 {
+			if !br.CanRead(16) {
+				return acks, topicRequests, truncatedProduceRequest()
+			}
+			copy(topicReq.ID[:], br.B[br.Off:br.Off+16])
+			br.Off += 16
+		} else {
+			topicReq.Name = readString(&br, flexible)
+		}
 
-		nPartitions := int(parser.ReadUVarInt(&br)) - 1
-		topicReq.Partitions = make([]ProducePartitionRequest, 0, nPartitions)
+		nPartitions, err := readArrayLen(&br, maxRequestPartitions, minPartitionSize, flexible)
+		if err != nil {
+			return acks, append(topicRequests, topicReq), err
+		}
+		topicReq.Partitions = make([]ProducePartitionRequest, 0, max(nPartitions, 0))
 
 		for j := 0; j < nPartitions; j++ {
+			if !br.CanRead(4) {
+				return acks, append(topicRequests, topicReq), truncatedProduceRequest()
+			}
 			partReq := ProducePartitionRequest{}
 			partReq.Index = parser.ReadInt32(&br)
 
-			recordsLen := int(parser.ReadUVarInt(&br)) - 1
+			recordsLen := readBytesLen(&br, flexible)
 			if recordsLen > 0 && br.CanRead(recordsLen) {
 				partReq.Records = make([]byte, recordsLen)
 				copy(partReq.Records, br.B[br.Off:br.Off+recordsLen])
 				br.Off += recordsLen
 			}
 
-			_ = parser.ReadUVarInt(&br)
+			skipTaggedFields(&br, flexible)
 
 			topicReq.Partitions = append(topicReq.Partitions, partReq)
+			if br.Short {
+				return acks, append(topicRequests, topicReq), truncatedProduceRequest()
+			}
 		}
 
-		_ = parser.ReadUVarInt(&br)
+		skipTaggedFields(&br, flexible)
 		topicRequests = append(topicRequests, topicReq)
+		if br.Short {
+			return acks, topicRequests, truncatedProduceRequest()
+		}
 	}
 
-	return topicRequests
+	return acks, topicRequests, limitErr
+}
+
+func truncatedProduceRequest() error {
+	return errors.NewKafkaError(errors.ErrInvalidRequest, "produce request is truncated")
 }
@@ -0,0 +1,43 @@
+package kraft
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/coordinator"
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+// TailLocalLog keeps state in step with the local metadata log after
+// startup, so topics a controller sharing the log dir creates, changes or
+// removes later show up without a restart. Every interval it applies the
+// batches appended since the last look and installs the image if any were.
+// A log that no longer continues from there, rewritten or truncated, is
+// replayed from the start.
+func TailLocalLog(state *topic.BrokerState, interval time.Duration) {
+	go func() {
+		image, next := topic.NewMetadataImage(), int64(0)
+		for ; ; time.Sleep(interval) {
+			data, err := partition.ReadRecordsFrom(topic.MetadataTopic, 0, next, math.MaxInt32)
+			if err != nil || len(data) < recordbatch.HeaderSize {
+				continue
+			}
+			if base := int64(binary.BigEndian.Uint64(data)); next > 0 && base != next {
+				logger.Warn("metadata log no longer continues from offset %d, replaying from the start", next)
+				image, next = topic.NewMetadataImage(), 0
+				continue
+			}
+			end, _, applied := image.ApplyBatches(data, math.MaxInt64)
+			if !applied {
+				continue
+			}
+			next = end
+			image.Install(state)
+			coordinator.SyncOwnership(state)
+		}
+	}()
+}
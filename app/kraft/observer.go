@@ -0,0 +1,397 @@
+package kraft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/coordinator"
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+const (
+	apiKeyFetch     = int16(1)
+	fetchAPIVersion = int16(13)
+	clientID        = "kraft-observer"
+
+	fetchMaxWaitMs  = 500
+	fetchMaxBytes   = 8 << 20
+	dialTimeout     = 5 * time.Second
+	retryBackoff    = time.Second
+	uncommittedWait = 100 * time.Millisecond
+)
+
+// metadataTopicID is the fixed topic ID of __cluster_metadata.
+var metadataTopicID = [16]byte{15: 1}
+
+type Voter struct {
+	ID      int32
+	Address string
+}
+
+var (
+	mu        sync.RWMutex
+	voters    []Voter
+	clusterID string
+)
+
+// ParseVoters reads a controller.quorum.voters list such as
+// "1@controller-1:9093,2@controller-2:9093".
+func ParseVoters(spec string) ([]Voter, error) {
+	var out []Voter
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		idStr, addr, ok := strings.Cut(item, "@")
+		if !ok {
+			return nil, fmt.Errorf("voter %q must look like id@host:port", item)
+		}
+		id, err := strconv.ParseInt(idStr, 10, 32)
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("voter %q has invalid id %q", item, idStr)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("voter %q: %v", item, err)
+		}
+		out = append(out, Voter{ID: int32(id), Address: addr})
+	}
+	return out, nil
+}
+
+// LoadProperties reads process.roles and controller.quorum.voters from the
+// broker's properties, and the cluster ID from meta.properties in the log
+// dir when one has been formatted. The quorum is only followed by a
+// broker-only node: in combined mode (process.roles=broker,controller) this
+// node is itself a controller, and its local metadata log is the one to
+// read, as it is without voters.
+func LoadProperties(props map[string]string) error {
+	list, err := ParseVoters(props["controller.quorum.voters"])
+	if err != nil {
+		return err
+	}
+	for _, role := range strings.Split(props["process.roles"], ",") {
+		switch role = strings.TrimSpace(role); role {
+		case "", "broker":
+		case "controller":
+			list = nil
+		default:
+			return fmt.Errorf("unknown process role %q", role)
+		}
+	}
+
+	id := ""
+	if meta, err := os.ReadFile(filepath.Join(partition.LogDir, "meta.properties")); err == nil {
+		for _, line := range strings.Split(string(meta), "\n") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok && key == "cluster.id" {
+				id = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	mu.Lock()
+	voters, clusterID = list, id
+	mu.Unlock()
+	return nil
+}
+
+// Enabled reports whether a controller quorum is configured, in which case
+// metadata comes from the controller rather than the local log.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(voters) > 0
+}
+
+// Observer follows the controller quorum's metadata log as a KRaft observer:
+// it fetches __cluster_metadata from the leader with replica ID -1, so the
+// leader never counts it towards the high watermark, and installs every
+// committed prefix into the broker state.
+type Observer struct {
+	state  *topic.BrokerState
+	image  *topic.MetadataImage
+	voters []Voter
+	// clusterID is sent so a misconfigured broker is rejected with
+	// INCONSISTENT_CLUSTER_ID; left empty, the controller accepts any.
+	clusterID string
+
+	fetchOffset      int64
+	lastFetchedEpoch int32
+	leaderID         int32
+	leaderEpoch      int32
+	corrID           int32
+	caughtUp         bool
+}
+
+// Start begins following the configured quorum in the background.
+func Start(state *topic.BrokerState) {
+	mu.RLock()
+	o := &Observer{
+		state:            state,
+		image:            topic.NewMetadataImage(),
+		voters:           append([]Voter(nil), voters...),
+		clusterID:        clusterID,
+		lastFetchedEpoch: -1,
+		leaderID:         -1,
+		leaderEpoch:      -1,
+	}
+	mu.RUnlock()
+	go o.run()
+}
+
+func (o *Observer) run() {
+	next := 0
+	for {
+		v := o.voters[next%len(o.voters)]
+		if o.leaderID >= 0 {
+			for _, candidate := range o.voters {
+				if candidate.ID == o.leaderID {
+					v = candidate
+				}
+			}
+		}
+		err := o.follow(v)
+		logger.Warn("metadata fetch from controller %d at %s stopped: %v", v.ID, v.Address, err)
+		next++
+		time.Sleep(retryBackoff)
+	}
+}
+
+// follow fetches from one controller until it stops being the leader or the
+// connection fails.
+func (o *Observer) follow(v Voter) error {
+	conn, err := net.DialTimeout("tcp", v.Address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		o.corrID++
+		if _, err := conn.Write(o.fetchRequest()); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Duration(fetchMaxWaitMs)*time.Millisecond + dialTimeout))
+		body, err := readResponse(r, o.corrID)
+		if err != nil {
+			return err
+		}
+		res, err := parseFetchResponse(body)
+		if err != nil {
+			return err
+		}
+
+		if res.currentLeaderEpoch >= 0 {
+			o.leaderID, o.leaderEpoch = res.currentLeaderID, res.currentLeaderEpoch
+		}
+		switch {
+		case res.errorCode == errors.ErrNone:
+		case res.errorCode == errors.ErrNotLeaderOrFollower, res.errorCode == errors.ErrFencedLeaderEpoch:
+			if res.currentLeaderEpoch >= 0 && o.leaderID == v.ID {
+				continue
+			}
+			return errors.Newf(res.errorCode, "controller %d is not the leader, leader is %d", v.ID, o.leaderID)
+		default:
+			return errors.Newf(res.errorCode, "controller returned %s", errors.Name(res.errorCode))
+		}
+
+		if res.snapshotEndOffset >= 0 {
+			return fmt.Errorf("controller log starts at offset %d past fetch offset %d and snapshot fetching is not supported", res.logStartOffset, o.fetchOffset)
+		}
+		if res.divergingEndOffset >= 0 {
+			// Only committed batches are ever applied, so this should not
+			// happen; replay from the start rather than trust the image.
+			logger.Warn("metadata log diverged at offset %d, replaying from the start", res.divergingEndOffset)
+			o.image = topic.NewMetadataImage()
+			o.fetchOffset, o.lastFetchedEpoch = 0, -1
+			continue
+		}
+
+		next, epoch, applied := o.image.ApplyBatches(res.records, res.highWatermark)
+		if applied {
+			o.fetchOffset, o.lastFetchedEpoch = next, epoch
+			o.image.Install(o.state)
+			coordinator.SyncOwnership(o.state)
+		} else if len(res.records) > 0 {
+			// Everything returned is beyond the high watermark. The leader
+			// answers at once while our offset trails its log end, so back
+			// off instead of spinning until the records commit.
+			time.Sleep(uncommittedWait)
+		}
+		if !o.caughtUp && o.fetchOffset >= res.highWatermark {
+			o.caughtUp = true
+			logger.Success("Caught up with controller metadata at offset %d", o.fetchOffset)
+		}
+	}
+}
+
+func (o *Observer) fetchRequest() []byte {
+	b := parser.AppendInt16(nil, apiKeyFetch)
+	b = parser.AppendInt16(b, fetchAPIVersion)
+	b = parser.AppendInt32(b, o.corrID)
+	b = parser.AppendInt16(b, int16(len(clientID)))
+	b = append(b, clientID...)
+	b = parser.AppendUVarInt(b, 0)
+
+	b = parser.AppendInt32(b, -1)
+	b = parser.AppendInt32(b, fetchMaxWaitMs)
+	b = parser.AppendInt32(b, 1)
+	b = parser.AppendInt32(b, fetchMaxBytes)
+	b = parser.AppendInt8(b, 0)
+	b = parser.AppendInt32(b, 0)
+	b = parser.AppendInt32(b, -1)
+
+	b = parser.AppendUVarInt(b, 2)
+	b = append(b, metadataTopicID[:]...)
+	b = parser.AppendUVarInt(b, 2)
+	b = parser.AppendInt32(b, 0)
+	b = parser.AppendInt32(b, o.leaderEpoch)
+	b = parser.AppendInt64(b, o.fetchOffset)
+	b = parser.AppendInt32(b, o.lastFetchedEpoch)
+	b = parser.AppendInt64(b, -1)
+	b = parser.AppendInt32(b, fetchMaxBytes)
+	b = parser.AppendUVarInt(b, 0)
+	b = parser.AppendUVarInt(b, 0)
+
+	b = parser.AppendUVarInt(b, 1)
+	b = parser.AppendCompactString(b, "")
+
+	if o.clusterID == "" {
+		b = parser.AppendUVarInt(b, 0)
+	} else {
+		field := parser.AppendCompactString(nil, o.clusterID)
+		b = parser.AppendUVarInt(b, 1)
+		b = parser.AppendUVarInt(b, 0)
+		b = parser.AppendUVarInt(b, uint32(len(field)))
+		b = append(b, field...)
+	}
+
+	return append(parser.AppendInt32(nil, int32(len(b))), b...)
+}
+
+func readResponse(r *bufio.Reader, corrID int32) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := int32(binary.BigEndian.Uint32(size[:]))
+	if n < 4 || n > fetchMaxBytes+(1<<20) {
+		return nil, fmt.Errorf("invalid response size %d", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	br := parser.BytesReader{B: payload}
+	if got := parser.ReadInt32(&br); got != corrID {
+		return nil, fmt.Errorf("response correlation ID %d, expected %d", got, corrID)
+	}
+	skipTaggedFields(&br)
+	return payload[br.Off:], nil
+}
+
+type fetchResult struct {
+	errorCode          int16
+	highWatermark      int64
+	logStartOffset     int64
+	records            []byte
+	divergingEndOffset int64
+	currentLeaderID    int32
+	currentLeaderEpoch int32
+	snapshotEndOffset  int64
+}
+
+// parseFetchResponse extracts the metadata partition from a Fetch v13
+// response, including the KRaft-only tagged fields: the diverging epoch, the
+// current leader and the snapshot ID.
+func parseFetchResponse(body []byte) (fetchResult, error) {
+	res := fetchResult{divergingEndOffset: -1, currentLeaderID: -1, currentLeaderEpoch: -1, snapshotEndOffset: -1}
+	br := parser.BytesReader{B: body}
+
+	_ = parser.ReadInt32(&br)
+	res.errorCode = parser.ReadInt16(&br)
+	_ = parser.ReadInt32(&br)
+	if res.errorCode != errors.ErrNone {
+		return res, nil
+	}
+
+	nTopics := int(parser.ReadUVarInt(&br)) - 1
+	for i := 0; i < nTopics; i++ {
+		if !br.CanRead(16) {
+			return res, fmt.Errorf("truncated fetch response")
+		}
+		br.Off += 16
+		nPartitions := int(parser.ReadUVarInt(&br)) - 1
+		for j := 0; j < nPartitions; j++ {
+			_ = parser.ReadInt32(&br)
+			res.errorCode = parser.ReadInt16(&br)
+			res.highWatermark = parser.ReadInt64(&br)
+			_ = parser.ReadInt64(&br)
+			res.logStartOffset = parser.ReadInt64(&br)
+			nAborted := int(parser.ReadUVarInt(&br)) - 1
+			for k := 0; k < nAborted; k++ {
+				_ = parser.ReadInt64(&br)
+				_ = parser.ReadInt64(&br)
+				skipTaggedFields(&br)
+			}
+			_ = parser.ReadInt32(&br)
+			if n := int(parser.ReadUVarInt(&br)) - 1; n > 0 {
+				if !br.CanRead(n) {
+					return res, fmt.Errorf("truncated records in fetch response")
+				}
+				res.records = br.B[br.Off : br.Off+n]
+				br.Off += n
+			}
+
+			nTags := int(parser.ReadUVarInt(&br))
+			for k := 0; k < nTags; k++ {
+				tag := parser.ReadUVarInt(&br)
+				size := int(parser.ReadUVarInt(&br))
+				if !br.CanRead(size) {
+					return res, fmt.Errorf("truncated tagged field in fetch response")
+				}
+				field := parser.BytesReader{B: br.B[br.Off : br.Off+size]}
+				switch tag {
+				case 0:
+					_ = parser.ReadInt32(&field)
+					res.divergingEndOffset = parser.ReadInt64(&field)
+				case 1:
+					res.currentLeaderID = parser.ReadInt32(&field)
+					res.currentLeaderEpoch = parser.ReadInt32(&field)
+				case 2:
+					res.snapshotEndOffset = parser.ReadInt64(&field)
+				}
+				br.Off += size
+			}
+		}
+		skipTaggedFields(&br)
+	}
+	return res, nil
+}
+
+func skipTaggedFields(br *parser.BytesReader) {
+	nTags := int(parser.ReadUVarInt(br))
+	for i := 0; i < nTags && br.Off < len(br.B); i++ {
+		_ = parser.ReadUVarInt(br)
+		size := int(parser.ReadUVarInt(br))
+		if !br.CanRead(size) {
+			br.Off = len(br.B)
+			return
+		}
+		br.Off += size
+	}
+}
@@ -0,0 +1,250 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DefaultName = "PLAINTEXT"
+
+const (
+	ProtocolPlaintext = "PLAINTEXT"
+	ProtocolSSL       = "SSL"
+)
+
+type Endpoint struct {
+	Name string
+	Host string
+	Port int32
+	// SecurityProtocol is PLAINTEXT or SSL, taken from
+	// listener.security.protocol.map or, failing that, the listener name.
+	SecurityProtocol string
+}
+
+func (e Endpoint) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(int(e.Port)))
+}
+
+var (
+	mu         sync.RWMutex
+	bind       = []Endpoint{{Name: DefaultName, Host: "0.0.0.0", Port: 9092, SecurityProtocol: ProtocolPlaintext}}
+	advertised = map[string]Endpoint{}
+	tlsConfig  *tls.Config
+
+	// controllerNames are the listeners named in controller.listener.names.
+	controllerNames = map[string]bool{}
+)
+
+// Parse reads a listeners-style list such as
+// "PLAINTEXT://0.0.0.0:9092,EXTERNAL://host.docker.internal:39092".
+func Parse(spec string) ([]Endpoint, error) {
+	var out []Endpoint
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(item, "://")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("listener %q must look like NAME://host:port", item)
+		}
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("listener %q: %v", item, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 0 || port > 65535 {
+			return nil, fmt.Errorf("listener %q has invalid port %q", item, portStr)
+		}
+		out = append(out, Endpoint{Name: strings.ToUpper(name), Host: host, Port: int32(port), SecurityProtocol: ProtocolPlaintext})
+	}
+	return out, nil
+}
+
+// Configure sets the bind listeners and the advertised endpoints. An
+// advertised endpoint replaces the bind address of the listener with the same
+// name in metadata responses, which is what lets a broker bound to 0.0.0.0
+// inside a container hand out host.docker.internal:39092 to clients outside
+// it.
+func Configure(listeners, advertisedListeners []Endpoint) {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(listeners) > 0 {
+		bind = listeners
+	}
+	advertised = make(map[string]Endpoint, len(advertisedListeners))
+	for _, e := range advertisedListeners {
+		advertised[e.Name] = e
+	}
+}
+
+// LoadProperties applies listeners and advertised.listeners from the
+// broker's properties; without them the defaults stay.
+// KAFKA_ADVERTISED_LISTENERS, when set, overrides the advertised list so a
+// container can be retargeted without editing the file. SSL listeners take
+// their certificate from the ssl.* properties. Listeners named in
+// controller.listener.names are marked as controller listeners.
+func LoadProperties(props map[string]string) error {
+	advertisedSpec := props["advertised.listeners"]
+	if env := os.Getenv("KAFKA_ADVERTISED_LISTENERS"); env != "" {
+		advertisedSpec = env
+	}
+
+	listeners, err := Parse(props["listeners"])
+	if err != nil {
+		return err
+	}
+	adv, err := Parse(advertisedSpec)
+	if err != nil {
+		return err
+	}
+	protocols, err := parseProtocolMap(props["listener.security.protocol.map"])
+	if err != nil {
+		return err
+	}
+	needTLS := false
+	for i := range listeners {
+		proto, ok := protocols[listeners[i].Name]
+		if !ok {
+			proto = listeners[i].Name
+		}
+		if proto != ProtocolPlaintext && proto != ProtocolSSL {
+			return fmt.Errorf("listener %s has unsupported security protocol %s", listeners[i].Name, proto)
+		}
+		listeners[i].SecurityProtocol = proto
+		needTLS = needTLS || proto == ProtocolSSL
+	}
+
+	var cfg *tls.Config
+	if needTLS {
+		if cfg, err = loadTLSConfig(props); err != nil {
+			return err
+		}
+	}
+
+	controllers := map[string]bool{}
+	for _, name := range strings.Split(props["controller.listener.names"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			controllers[strings.ToUpper(name)] = true
+		}
+	}
+
+	Configure(listeners, adv)
+	mu.Lock()
+	tlsConfig = cfg
+	controllerNames = controllers
+	mu.Unlock()
+	return nil
+}
+
+// parseProtocolMap reads a listener.security.protocol.map value such as
+// "PLAINTEXT:PLAINTEXT,EXTERNAL:SSL".
+func parseProtocolMap(spec string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, proto, ok := strings.Cut(item, ":")
+		if !ok || name == "" || proto == "" {
+			return nil, fmt.Errorf("security protocol mapping %q must look like NAME:PROTOCOL", item)
+		}
+		out[strings.ToUpper(name)] = strings.ToUpper(proto)
+	}
+	return out, nil
+}
+
+// loadTLSConfig builds the server TLS config from PEM files:
+// ssl.keystore.location holds the certificate chain and private key, and
+// ssl.truststore.location the CAs that client certificates are checked
+// against when ssl.client.auth is "required" or "requested".
+func loadTLSConfig(props map[string]string) (*tls.Config, error) {
+	keystore := props["ssl.keystore.location"]
+	if keystore == "" {
+		return nil, fmt.Errorf("SSL listener configured without ssl.keystore.location")
+	}
+	pem, err := os.ReadFile(keystore)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(pem, pem)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", keystore, err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	switch clientAuth := props["ssl.client.auth"]; clientAuth {
+	case "", "none":
+		return cfg, nil
+	case "required":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case "requested":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid ssl.client.auth %q", clientAuth)
+	}
+
+	truststore := props["ssl.truststore.location"]
+	if truststore == "" {
+		return nil, fmt.Errorf("ssl.client.auth set without ssl.truststore.location")
+	}
+	caPEM, err := os.ReadFile(truststore)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", truststore)
+	}
+	return cfg, nil
+}
+
+// TLSConfig returns the server TLS config for SSL listeners, or nil when
+// every listener is plaintext.
+func TLSConfig() *tls.Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	return tlsConfig
+}
+
+// IsController reports whether the named listener is a controller listener,
+// which in KRaft carries quorum and registration traffic rather than client
+// requests.
+func IsController(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return controllerNames[name]
+}
+
+func Listeners() []Endpoint {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Endpoint(nil), bind...)
+}
+
+// Advertised returns the endpoint clients connected through the named
+// listener should be told to use. Without an advertised.listeners entry the
+// bind address is used, with a wildcard host replaced by localhost.
+func Advertised(name string) Endpoint {
+	mu.RLock()
+	defer mu.RUnlock()
+	if e, ok := advertised[name]; ok {
+		return e
+	}
+	for _, e := range bind {
+		if e.Name == name {
+			if e.Host == "" || e.Host == "0.0.0.0" || e.Host == "::" {
+				e.Host = "localhost"
+			}
+			return e
+		}
+	}
+	return Endpoint{Name: name, Host: "localhost", Port: 9092}
+}
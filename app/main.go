@@ -1,38 +1,196 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/codecrafters-io/kafka-starter-go/app/capture"
+	"github.com/codecrafters-io/kafka-starter-go/app/config"
+	"github.com/codecrafters-io/kafka-starter-go/app/coordinator"
+	"github.com/codecrafters-io/kafka-starter-go/app/kraft"
+	"github.com/codecrafters-io/kafka-starter-go/app/listener"
 	"github.com/codecrafters-io/kafka-starter-go/app/logger"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/quota"
 	"github.com/codecrafters-io/kafka-starter-go/app/server"
+	"github.com/codecrafters-io/kafka-starter-go/app/stats"
 	"github.com/codecrafters-io/kafka-starter-go/app/topic"
 )
 
 func main() {
-	logger.Info("Kafka broker starting on :9092")
-
-	state := topic.BrokerState{Topics: map[string]topic.Meta{}}
 	if len(os.Args) > 1 {
-		if err := topic.LoadFromProperties(os.Args[1], &state); err != nil {
-			logger.Warn("failed to load properties: %v", err)
+		switch os.Args[1] {
+		case "metadata-replay":
+			os.Exit(runMetadataReplay(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
 		}
 	}
 
-	l, err := net.Listen("tcp", "0.0.0.0:9092")
+	cfg, err := config.FromArgs(os.Args[1:])
 	if err != nil {
-		logger.Error("Failed to bind to port 9092")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := cfg.LoadProperties(); err != nil {
+		logger.Warn("failed to load broker properties: %v", err)
+	}
+	partition.SetLogDir(cfg.LogDir)
+	topic.SetNodeID(cfg.NodeID)
+
+	logger.Info("Kafka broker starting as node %d, logs in %s", cfg.NodeID, cfg.LogDir)
+
+	if seed, err := strconv.ParseUint(os.Getenv("KAFKA_UUID_SEED"), 10, 64); err == nil {
+		parser.SeedUUIDs(seed)
+		logger.Info("Generating topic IDs from seed %d", seed)
+	}
+
+	state := topic.BrokerState{Topics: map[string]topic.Meta{}}
+	if err := kraft.LoadProperties(cfg.Properties); err != nil {
+		logger.Warn("failed to load controller quorum properties: %v", err)
 	}
+	if kraft.Enabled() {
+		logger.Info("Following cluster metadata from the controller quorum")
+		kraft.Start(&state)
+	} else {
+		topic.LoadFromProperties(cfg.Properties, &state)
+		poll := time.Second
+		if ms, err := strconv.Atoi(os.Getenv("KAFKA_METADATA_POLL_MS")); err == nil && ms > 0 {
+			poll = time.Duration(ms) * time.Millisecond
+		}
+		kraft.TailLocalLog(&state, poll)
+	}
+	coordinator.LoadProperties(cfg.Properties)
+	topic.LoadBrokerDefaults(cfg.Properties)
+	quota.LoadProperties(cfg.Properties)
+	if err := listener.LoadProperties(cfg.Properties); err != nil {
+		logger.Warn("failed to load listener properties: %v", err)
+	}
+
+	stats.Default.SetGaugeFunc("under_replicated_partitions", func() int64 {
+		n, _ := state.ReplicationHealth()
+		return int64(n)
+	})
+	stats.Default.SetGaugeFunc("under_min_isr_partitions", func() int64 {
+		_, n := state.ReplicationHealth()
+		return int64(n)
+	})
+	stats.Default.SetGaugeVecFunc("consumer_group_lag", func() []stats.Sample {
+		var samples []stats.Sample
+		for _, groupID := range coordinator.Groups() {
+			lag, err := coordinator.GroupLag(groupID)
+			if err != nil {
+				continue
+			}
+			tps := make([]coordinator.TopicPartition, 0, len(lag))
+			for tp := range lag {
+				tps = append(tps, tp)
+			}
+			sort.Slice(tps, func(i, j int) bool {
+				if tps[i].Topic != tps[j].Topic {
+					return tps[i].Topic < tps[j].Topic
+				}
+				return tps[i].Partition < tps[j].Partition
+			})
+			for _, tp := range tps {
+				samples = append(samples, stats.Sample{
+					Labels: []string{"group", groupID, "topic", tp.Topic, "partition", strconv.Itoa(int(tp.Partition))},
+					Value:  lag[tp],
+				})
+			}
+		}
+		return samples
+	})
+
+	if addr := os.Getenv("KAFKA_METRICS_ADDR"); addr != "" {
+		go func() {
+			logger.Info("Serving metrics on %s", addr)
+			if err := http.ListenAndServe(addr, stats.Default); err != nil {
+				logger.Error("metrics listener stopped: %v", err)
+			}
+		}()
+	}
+
+	if dir := os.Getenv("KAFKA_CAPTURE_DIR"); dir != "" {
+		capture.SetDir(dir)
+		logger.Info("Capturing request and response frames to %s", dir)
+	}
+
+	if ms, err := strconv.Atoi(os.Getenv("KAFKA_WRITE_COALESCE_MS")); err == nil && ms > 0 {
+		partition.EnableWriteCoalescing(time.Duration(ms) * time.Millisecond)
+		logger.Info("Coalescing partition writes within %dms", ms)
+	}
+
+	if n, err := strconv.ParseInt(cfg.Properties["queued.max.request.bytes"], 10, 64); err == nil && n > 0 {
+		server.SetProduceMemoryBytes(n)
+		stats.Default.SetGaugeFunc("produce_bytes_in_flight", server.ProduceBytesInFlight)
+		logger.Info("Holding at most %d bytes of produce requests in memory", n)
+	}
+
+	endpoints := listener.Listeners()
+	listeners := make([]net.Listener, len(endpoints))
+	for i, e := range endpoints {
+		l, err := net.Listen("tcp", e.Address())
+		if err != nil {
+			logger.Error("Failed to bind %s listener to %s", e.Name, e.Address())
+			os.Exit(1)
+		}
+		if e.SecurityProtocol == listener.ProtocolSSL {
+			l = tls.NewListener(l, listener.TLSConfig())
+		}
+		listeners[i] = l
+		adv := listener.Advertised(e.Name)
+		logger.Info("%s listener (%s) on %s, advertised as %s", e.Name, e.SecurityProtocol, e.Address(), adv.Address())
+	}
+
+	drainTimeout := 30 * time.Second
+	if ms, err := strconv.Atoi(os.Getenv("KAFKA_SHUTDOWN_DRAIN_MS")); err == nil && ms >= 0 {
+		drainTimeout = time.Duration(ms) * time.Millisecond
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
 	logger.Success("Broker ready, accepting connections")
 
+	for i := range listeners {
+		go acceptLoop(listeners[i], endpoints[i].Name, &state)
+	}
+
+	sig := <-signals
+	logger.Info("Received %s, shutting down", sig)
+	for _, l := range listeners {
+		l.Close()
+	}
+	if !server.Shutdown(drainTimeout) {
+		logger.Warn("Connections still busy after %s, closed them", drainTimeout)
+	}
+	if err := partition.Sync(); err != nil {
+		logger.Error("Failed to sync partition logs: %v", err)
+		os.Exit(1)
+	}
+	logger.Success("Broker stopped")
+}
+
+func acceptLoop(l net.Listener, name string, state *topic.BrokerState) {
 	for {
 		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			logger.Error("Error accepting connection: %v", err)
 			continue
 		}
-		go server.HandleConnection(conn, &state)
+		go server.HandleConnection(conn, name, state)
 	}
 }
@@ -1,19 +1,33 @@
 package parser
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
+	mrand "math/rand/v2"
 	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
 )
 
 type BytesReader struct {
 	B   []byte
 	Off int
+
+	// Short is set once any read or CanRead check runs past the end of B,
+	// letting callers tell a truncated message from zero-valued fields.
+	Short bool
 }
 
 func (br *BytesReader) CanRead(n int) bool {
-	return br.Off+n <= len(br.B)
+	if br.Off+n > len(br.B) || n < 0 {
+		br.Short = true
+		return false
+	}
+	return true
 }
 
 func ReadInt8(br *BytesReader) int8 {
@@ -118,6 +132,83 @@ func ReadCompactNullableString(br *BytesReader) (string, bool) {
 	return s, false
 }
 
+// ReadString reads a non-flexible (int16 length-prefixed) string, treating
+// null as empty.
+func ReadString(br *BytesReader) string {
+	s, _ := ReadNullableString(br)
+	return s
+}
+
+func ReadNullableString(br *BytesReader) (string, bool) {
+	n := int(ReadInt16(br))
+	if n < 0 {
+		return "", true
+	}
+	if !br.CanRead(n) {
+		return "", false
+	}
+	s := string(br.B[br.Off : br.Off+n])
+	br.Off += n
+	return s, false
+}
+
+// ReadArrayLen is ReadCompactArrayLen for non-flexible (int32 length-prefixed)
+// arrays.
+func ReadArrayLen(br *BytesReader, max, minElemSize int) (int, error) {
+	n := int(ReadInt32(br))
+	if n < 0 {
+		return -1, nil
+	}
+	return checkArrayLen(br, n, max, minElemSize)
+}
+
+// ReadCompactArrayLen reads a compact array length, returning -1 for a null
+// array. Lengths that could not fit in the remaining bytes given the smallest
+// possible element encoding are rejected with INVALID_REQUEST before any
+// allocation. Lengths above max are rejected with POLICY_VIOLATION, but as
+// the entries are known to fit, the length is returned with the error, for a
+// caller that would rather read them and answer each with it.
+func ReadCompactArrayLen(br *BytesReader, max, minElemSize int) (int, error) {
+	n := int(ReadUVarInt(br)) - 1
+	if n < 0 {
+		return -1, nil
+	}
+	return checkArrayLen(br, n, max, minElemSize)
+}
+
+func checkArrayLen(br *BytesReader, n, max, minElemSize int) (int, error) {
+	if !br.CanRead(n * minElemSize) {
+		return 0, errors.Newf(errors.ErrInvalidRequest, "array of %d entries exceeds request size", n)
+	}
+	if n > max {
+		return n, errors.Newf(errors.ErrPolicyViolation, "array of %d entries exceeds limit of %d", n, max)
+	}
+	return n, nil
+}
+
+func ReadCompactInt32Array(br *BytesReader) []int32 {
+	n := int(ReadUVarInt(br)) - 1
+	if n < 0 || !br.CanRead(4*n) {
+		return nil
+	}
+	out := make([]int32, n)
+	for i := range out {
+		out[i] = ReadInt32(br)
+	}
+	return out
+}
+
+func AppendInt8(b []byte, v int8) []byte {
+	return append(b, byte(v))
+}
+
+func AppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
 func AppendInt16(b []byte, v int16) []byte {
 	var tmp [2]byte
 	binary.BigEndian.PutUint16(tmp[:], uint16(v))
@@ -146,11 +237,73 @@ func AppendUVarInt(b []byte, x uint32) []byte {
 	}
 }
 
+func AppendVarInt(b []byte, v int64) []byte {
+	x := uint64(v<<1) ^ uint64(v>>63)
+	for x >= 0x80 {
+		b = append(b, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(b, byte(x))
+}
+
+func AppendCompactInt32Array(b []byte, vs []int32) []byte {
+	b = AppendUVarInt(b, uint32(len(vs)+1))
+	for _, v := range vs {
+		b = AppendInt32(b, v)
+	}
+	return b
+}
+
 func AppendCompactString(b []byte, s string) []byte {
 	b = AppendUVarInt(b, uint32(len(s)+1))
 	return append(b, []byte(s)...)
 }
 
+func AppendString(b []byte, s string) []byte {
+	b = AppendInt16(b, int16(len(s)))
+	return append(b, s...)
+}
+
+func AppendNullableString(b []byte, s string, null bool) []byte {
+	if null {
+		return AppendInt16(b, -1)
+	}
+	return AppendString(b, s)
+}
+
+func AppendCompactNullableString(b []byte, s string, null bool) []byte {
+	if null {
+		return AppendUVarInt(b, 0)
+	}
+	return AppendCompactString(b, s)
+}
+
+var (
+	uuidMu     sync.Mutex
+	uuidSource io.Reader = rand.Reader
+)
+
+// SeedUUIDs makes RandomUUID deterministic for the rest of the process, so a
+// test run can reproduce the same topic IDs.
+func SeedUUIDs(seed uint64) {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	uuidMu.Lock()
+	uuidSource = mrand.NewChaCha8(key)
+	uuidMu.Unlock()
+}
+
+// RandomUUID returns an RFC 4122 version 4 UUID.
+func RandomUUID() [16]byte {
+	var out [16]byte
+	uuidMu.Lock()
+	_, _ = io.ReadFull(uuidSource, out[:])
+	uuidMu.Unlock()
+	out[6] = (out[6] & 0x0f) | 0x40
+	out[8] = (out[8] & 0x3f) | 0x80
+	return out
+}
+
 func ParseUUID(in string) ([16]byte, error) {
 	var out [16]byte
 	s := strings.ReplaceAll(strings.TrimSpace(in), "-", "")
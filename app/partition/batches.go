@@ -0,0 +1,147 @@
+package partition
+
+import (
+	"encoding/binary"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
+)
+
+// legacyFormat reports the magic of the first message format v0/v1 message
+// set in data. Both legacy formats share v2's offset and length prefix, so
+// they can be stepped over without being parsed.
+func legacyFormat(data []byte) (int8, bool) {
+	for off := 0; off < len(data); {
+		h, size, _ := recordbatch.ParseHeader(data[off:])
+		if size == 0 {
+			break
+		}
+		if h.Magic < 2 {
+			return h.Magic, true
+		}
+		off += size
+	}
+	return 0, false
+}
+
+func forEachBatch(data []byte, fn func(h recordbatch.Header)) {
+	forEachBatchSpan(data, func(_, _ int, h recordbatch.Header) bool {
+		fn(h)
+		return true
+	})
+}
+
+// forEachBatchSpan calls fn with the byte span and header of each batch in
+// data until fn returns false. It stops at the first incomplete batch, and at
+// a v0/v1 message set rather than misparse it as a v2 header.
+func forEachBatchSpan(data []byte, fn func(start, end int, h recordbatch.Header) bool) {
+	for off := 0; off < len(data); {
+		h, size, err := recordbatch.ParseHeader(data[off:])
+		if err != nil {
+			return
+		}
+		if !fn(off, off+size, h) {
+			return
+		}
+		off += size
+	}
+}
+
+// assignOffsets rewrites the baseOffset of each batch in data so they are
+// numbered consecutively from next, returning the offset after the last
+// record. baseOffset sits outside the CRC, so the batches stay valid.
+func assignOffsets(data []byte, next int64) int64 {
+	forEachBatchSpan(data, func(start, _ int, h recordbatch.Header) bool {
+		binary.BigEndian.PutUint64(data[start:start+8], uint64(next))
+		next += int64(h.LastOffsetDelta) + 1
+		return true
+	})
+	return next
+}
+
+// StampLogAppendTime rewrites every batch in data as LogAppendTime batches
+// stamped with ts, recomputing their CRCs.
+func StampLogAppendTime(data []byte, ts int64) {
+	forEachBatchSpan(data, func(start, end int, _ recordbatch.Header) bool {
+		recordbatch.SetLogAppendTime(data[start:end], ts)
+		return true
+	})
+}
+
+// RecompressBatches re-encodes every batch in data with codec, as a topic
+// with a compression.type other than producer stores them. Control batches
+// are kept as they are.
+func RecompressBatches(data []byte, codec int) ([]byte, error) {
+	var out []byte
+	var err error
+	forEachBatchSpan(data, func(start, end int, h recordbatch.Header) bool {
+		batch := data[start:end]
+		if !recordbatch.IsControl(h.Attributes) {
+			if batch, err = recordbatch.Recompress(batch, codec); err != nil {
+				return false
+			}
+		}
+		out = append(out, batch...)
+		return true
+	})
+	return out, err
+}
+
+// RecordsFrom drops the leading batches that end before offset, so a fetch
+// resumes with the batch containing it.
+func RecordsFrom(data []byte, offset int64) []byte {
+	start := len(data)
+	forEachBatchSpan(data, func(s, _ int, h recordbatch.Header) bool {
+		if h.LastOffset() >= offset {
+			start = s
+			return false
+		}
+		return true
+	})
+	return data[start:]
+}
+
+// batchPrefixSize is the baseOffset and batchLength ahead of every batch,
+// which batchLength does not count.
+const batchPrefixSize = 12
+
+// batchesWithin returns the length of the whole batches at the start of
+// data that fit in maxBytes, the first counting even if it alone does not.
+// When data ends before that can be told, need is how many bytes of it would
+// tell; otherwise need is zero. Only the batch length prefixes are read, so
+// a batch can be sized before all of it is at hand.
+func batchesWithin(data []byte, maxBytes int) (end, need int) {
+	for {
+		if end > 0 && end >= maxBytes {
+			return end, 0
+		}
+		if len(data)-end < batchPrefixSize {
+			return end, end + batchPrefixSize
+		}
+		size := batchPrefixSize + int(int32(binary.BigEndian.Uint32(data[end+8:])))
+		if size <= batchPrefixSize {
+			return end, 0
+		}
+		if end > 0 && end+size > maxBytes {
+			return end, 0
+		}
+		if end+size > len(data) {
+			return end, end + size
+		}
+		end += size
+	}
+}
+
+// TruncateToBatches cuts data at the last whole batch that fits in maxBytes.
+// With atLeastOne set the first batch is kept even if it alone exceeds the
+// limit, so an oversized batch can never stall a consumer.
+func TruncateToBatches(data []byte, maxBytes int, atLeastOne bool) []byte {
+	end := 0
+	forEachBatchSpan(data, func(_, e int, _ recordbatch.Header) bool {
+		if e > maxBytes && !(atLeastOne && end == 0) {
+			return false
+		}
+		end = e
+		return true
+	})
+	return data[:end]
+}
@@ -0,0 +1,100 @@
+package partition
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// offsetsCheckpoint is where a partition's log start offset and recovery
+// point are kept across restarts. The recovery point is the base offset of
+// the active segment when the last one was rolled: every segment below it is
+// sealed, so the log end offset can be found by scanning from there on.
+type offsetsCheckpoint struct {
+	LogStartOffset int64
+	RecoveryPoint  int64
+}
+
+func offsetsCheckpointPath(topicName string, partition int32) string {
+	return filepath.Join(Dir(topicName, partition), "offsets-checkpoint")
+}
+
+// readOffsetsCheckpoint parses the partition's offsets-checkpoint file: a
+// version line, then "logStartOffset recoveryPoint".
+func readOffsetsCheckpoint(topicName string, partition int32) (offsetsCheckpoint, error) {
+	path := offsetsCheckpointPath(topicName, partition)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return offsetsCheckpoint{}, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		return offsetsCheckpoint{}, fmt.Errorf("malformed offsets checkpoint %s", path)
+	}
+	if v := strings.TrimSpace(lines[0]); v != "0" {
+		return offsetsCheckpoint{}, fmt.Errorf("unsupported offsets checkpoint version %s", v)
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) != 2 {
+		return offsetsCheckpoint{}, fmt.Errorf("malformed offsets checkpoint %s", path)
+	}
+	var cp offsetsCheckpoint
+	if cp.LogStartOffset, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return offsetsCheckpoint{}, err
+	}
+	if cp.RecoveryPoint, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return offsetsCheckpoint{}, err
+	}
+	return cp, nil
+}
+
+// writeOffsetsCheckpoint replaces the checkpoint through a temporary file, so
+// a crash leaves either the old one or the new one.
+func writeOffsetsCheckpoint(topicName string, partition int32, cp offsetsCheckpoint) error {
+	path := offsetsCheckpointPath(topicName, partition)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "0\n%d %d\n", cp.LogStartOffset, cp.RecoveryPoint); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadOffsets recovers a partition's offsets from its log. With a checkpoint
+// whose recovery point still starts a segment, only the segments from there
+// on are scanned; otherwise, or for a checkpoint left stale by hand-edited
+// segments, the whole log is. With a single replica every appended record is
+// committed, so the high watermark is the log end offset.
+func loadOffsets(topicName string, partition int32, segs []segment) Offsets {
+	cp, err := readOffsetsCheckpoint(topicName, partition)
+	if err != nil || len(segs) == 0 {
+		return computeOffsets(readSegments(segs, 0))
+	}
+	i := segmentFor(segs, cp.RecoveryPoint)
+	if segs[i].baseOffset != cp.RecoveryPoint || cp.LogStartOffset > cp.RecoveryPoint || cp.LogStartOffset < segs[0].baseOffset {
+		return computeOffsets(readSegments(segs, 0))
+	}
+
+	o := computeOffsets(readSegments(segs[i:], 0))
+	if o.LogEndOffset < cp.RecoveryPoint {
+		o.LogEndOffset = cp.RecoveryPoint
+	}
+	o.LogStartOffset = cp.LogStartOffset
+	o.HighWatermark = o.LogEndOffset
+	return o
+}
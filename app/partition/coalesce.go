@@ -0,0 +1,86 @@
+package partition
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var coalesceWindow atomic.Int64
+
+type pendingWrite struct {
+	records []byte
+	done    chan writeResult
+}
+
+type writeResult struct {
+	baseOffset int64
+	err        error
+}
+
+type partitionWriter struct {
+	mu        sync.Mutex
+	pending   []pendingWrite
+	scheduled bool
+}
+
+// EnableWriteCoalescing groups writes to the same partition that arrive
+// within window into a single write+fsync. A zero window disables it.
+func EnableWriteCoalescing(window time.Duration) {
+	coalesceWindow.Store(int64(window))
+}
+
+func coalescingEnabled() bool {
+	return coalesceWindow.Load() > 0
+}
+
+func coalescedWrite(topicName string, partition int32, records []byte) (int64, error) {
+	w := &stateFor(topicName, partition).writer
+	done := make(chan writeResult, 1)
+
+	w.mu.Lock()
+	w.pending = append(w.pending, pendingWrite{records: records, done: done})
+	if !w.scheduled {
+		w.scheduled = true
+		time.AfterFunc(time.Duration(coalesceWindow.Load()), func() {
+			w.flush(topicName, partition)
+		})
+	}
+	w.mu.Unlock()
+
+	res := <-done
+	return res.baseOffset, res.err
+}
+
+func (w *partitionWriter) flush(topicName string, partition int32) {
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = nil
+	w.scheduled = false
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	writes := make([][]byte, len(batch))
+	for i, p := range batch {
+		writes[i] = p.records
+	}
+	bases, err := writeLog(topicName, partition, writes, true)
+	for i, p := range batch {
+		if err != nil {
+			p.done <- writeResult{baseOffset: -1, err: err}
+			continue
+		}
+		p.done <- writeResult{baseOffset: bases[i]}
+	}
+}
+
+// flushPending writes out every partition's pending coalesced writes now
+// rather than when their window ends.
+func flushPending() {
+	for _, st := range allStates() {
+		st.writer.flush(st.topicName, st.partition)
+	}
+}
@@ -0,0 +1,53 @@
+package partition
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultLogDir is where the broker keeps its logs unless configured
+// otherwise.
+const DefaultLogDir = "/tmp/kraft-combined-logs"
+
+// LogDir is the broker's single log.dirs entry.
+var LogDir = DefaultLogDir
+
+// SetLogDir moves the log dir. It must be called before the broker touches
+// any log.
+func SetLogDir(dir string) {
+	LogDir = dir
+}
+
+// Dir returns the directory holding a partition's log. Topic names may
+// themselves contain '-' and digits, so "my-topic-1" partition 0 lives in
+// "my-topic-1-0"; ParseDir undoes this by splitting on the last '-'.
+func Dir(topicName string, partition int32) string {
+	return filepath.Join(LogDir, fmt.Sprintf("%s-%d", topicName, partition))
+}
+
+// ParseDir splits a directory name under LogDir into its topic and partition.
+// Only the text after the last '-' is the partition, and it must be a plain
+// non-negative decimal, which rules out the "-delete", "-future" and "-stray"
+// directories the Java broker leaves behind.
+func ParseDir(name string) (string, int32, bool) {
+	dash := strings.LastIndexByte(name, '-')
+	if dash <= 0 || dash == len(name)-1 {
+		return "", 0, false
+	}
+	topicName, suffix := name[:dash], name[dash+1:]
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return "", 0, false
+		}
+	}
+	if len(suffix) > 1 && suffix[0] == '0' {
+		return "", 0, false
+	}
+	p, err := strconv.ParseInt(suffix, 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+	return topicName, int32(p), true
+}
@@ -0,0 +1,73 @@
+package partition
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type EpochEntry struct {
+	Epoch       int32
+	StartOffset int64
+}
+
+// ReadLeaderEpochs parses the partition's leader-epoch-checkpoint file: a
+// version line, an entry count, then one "epoch startOffset" pair per line.
+func ReadLeaderEpochs(topicName string, partition int32) ([]EpochEntry, error) {
+	path := filepath.Join(Dir(topicName, partition), "leader-epoch-checkpoint")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("malformed leader epoch checkpoint %s", path)
+	}
+	if v := strings.TrimSpace(lines[0]); v != "0" {
+		return nil, fmt.Errorf("unsupported leader epoch checkpoint version %s", v)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(lines[1]))
+	if err != nil || count != len(lines)-2 {
+		return nil, fmt.Errorf("malformed leader epoch checkpoint %s", path)
+	}
+
+	entries := make([]EpochEntry, 0, count)
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed leader epoch entry %q", line)
+		}
+		epoch, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		start, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, EpochEntry{Epoch: int32(epoch), StartOffset: start})
+	}
+	return entries, nil
+}
+
+// EpochForOffset returns the leader epoch that was active when offset was
+// written, or -1 when the checkpoint's first epoch starts after it. ok is
+// false when the partition has no readable checkpoint at all.
+func EpochForOffset(topicName string, partition int32, offset int64) (epoch int32, ok bool) {
+	entries, err := ReadLeaderEpochs(topicName, partition)
+	if err != nil {
+		return -1, false
+	}
+	epoch = -1
+	for _, e := range entries {
+		if e.StartOffset > offset {
+			break
+		}
+		epoch = e.Epoch
+	}
+	return epoch, true
+}
@@ -0,0 +1,228 @@
+package partition
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
+)
+
+// IndexIntervalBytes mirrors log.index.interval.bytes: roughly how many log
+// bytes separate consecutive offset index entries.
+const IndexIntervalBytes = 4096
+
+const indexEntrySize = 8
+
+// indexEntry is one entry of a segment's sparse offset index, in the Java
+// broker's layout: the last offset of a batch relative to the segment's base
+// offset, and the byte position the batch starts at.
+type indexEntry struct {
+	relOffset int32
+	position  int32
+}
+
+// indexTail is what appending to a segment's indexes needs to know about
+// what is already in them.
+type indexTail struct {
+	position           int64 // batch position of the last offset index entry
+	maxTimestamp       int64 // largest batch max timestamp in the segment
+	maxTimestampOffset int64 // last offset of the batch holding it
+	timeIndexed        int64 // timestamp of the last time index entry
+}
+
+func newIndexTail() indexTail {
+	return indexTail{maxTimestamp: -1, maxTimestampOffset: -1, timeIndexed: -1}
+}
+
+func indexPath(segPath string) string {
+	return strings.TrimSuffix(segPath, ".log") + ".index"
+}
+
+// readIndex loads the entries of a segment's offset index that fall within
+// its first seg.size bytes. A missing index, or one that is not a whole number
+// of entries increasing in both offset and position, is rebuilt.
+func readIndex(seg segment) []indexEntry {
+	if data, err := os.ReadFile(indexPath(seg.path)); err == nil {
+		if entries, ok := decodeIndex(data, seg.size); ok {
+			return entries
+		}
+	}
+	entries, _ := rebuildIndexes(seg)
+	return entries
+}
+
+// rebuildIndexes regenerates both of a segment's indexes from its first
+// seg.size bytes and rewrites them. A file that cannot be written is removed
+// so the next reader tries again.
+func rebuildIndexes(seg segment) ([]indexEntry, []timeIndexEntry) {
+	data, err := os.ReadFile(seg.path)
+	if err != nil {
+		return nil, nil
+	}
+	entries, times, _ := indexEntriesFor(seg.baseOffset, data[:min(int64(len(data)), seg.size)], 0, newIndexTail())
+
+	st := segmentState(seg)
+	st.indexMu.Lock()
+	defer st.indexMu.Unlock()
+	st.forgetTimeIndex(seg.path)
+	if err := os.WriteFile(indexPath(seg.path), encodeIndex(entries), 0644); err != nil {
+		_ = os.Remove(indexPath(seg.path))
+	}
+	if err := os.WriteFile(timeIndexPath(seg.path), encodeTimeIndex(times), 0644); err != nil {
+		_ = os.Remove(timeIndexPath(seg.path))
+	}
+	if st.tailPath == seg.path {
+		st.tailPath = ""
+	}
+	return entries, times
+}
+
+// decodeIndex parses an index file. Entries at or past segSize belong to a
+// write the caller's view of the segment predates; they are dropped, not
+// treated as corruption.
+func decodeIndex(data []byte, segSize int64) ([]indexEntry, bool) {
+	if len(data)%indexEntrySize != 0 {
+		return nil, false
+	}
+	entries := make([]indexEntry, 0, len(data)/indexEntrySize)
+	for off := 0; off < len(data); off += indexEntrySize {
+		e := indexEntry{
+			relOffset: int32(binary.BigEndian.Uint32(data[off : off+4])),
+			position:  int32(binary.BigEndian.Uint32(data[off+4 : off+8])),
+		}
+		if e.relOffset < 0 || e.position < 0 {
+			return nil, false
+		}
+		if n := len(entries); n > 0 && (e.relOffset <= entries[n-1].relOffset || e.position <= entries[n-1].position) {
+			return nil, false
+		}
+		if int64(e.position) < segSize {
+			entries = append(entries, e)
+		}
+	}
+	return entries, true
+}
+
+func encodeIndex(entries []indexEntry) []byte {
+	b := make([]byte, 0, len(entries)*indexEntrySize)
+	for _, e := range entries {
+		b = binary.BigEndian.AppendUint32(b, uint32(e.relOffset))
+		b = binary.BigEndian.AppendUint32(b, uint32(e.position))
+	}
+	return b
+}
+
+// indexEntriesFor returns the index entries for the batches in data, which
+// sits at position start of a segment whose indexes end at tail. As in the
+// Java broker, a batch gets an offset index entry once more than
+// IndexIntervalBytes have passed since the previous one, and alongside it a
+// time index entry if the segment's max timestamp has risen since the last.
+func indexEntriesFor(segBase int64, data []byte, start int64, tail indexTail) ([]indexEntry, []timeIndexEntry, indexTail) {
+	var entries []indexEntry
+	var times []timeIndexEntry
+	forEachBatchSpan(data, func(s, _ int, h recordbatch.Header) bool {
+		pos := start + int64(s)
+		lastOffset := h.LastOffset()
+		if h.MaxTimestamp > tail.maxTimestamp {
+			tail.maxTimestamp = h.MaxTimestamp
+			tail.maxTimestampOffset = lastOffset
+		}
+		if pos-tail.position > IndexIntervalBytes {
+			entries = append(entries, indexEntry{relOffset: int32(lastOffset - segBase), position: int32(pos)})
+			tail.position = pos
+			if tail.maxTimestamp > tail.timeIndexed {
+				times = append(times, timeIndexEntry{timestamp: tail.maxTimestamp, relOffset: int32(tail.maxTimestampOffset - segBase)})
+				tail.timeIndexed = tail.maxTimestamp
+			}
+		}
+		return true
+	})
+	return entries, times, tail
+}
+
+// indexPosition returns where in seg a read for offset should start: the
+// batch of the last index entry at or below offset, or the segment start.
+func indexPosition(seg segment, offset int64) int64 {
+	entries := readIndex(seg)
+	i := sort.Search(len(entries), func(i int) bool {
+		return seg.baseOffset+int64(entries[i].relOffset) > offset
+	})
+	if i == 0 {
+		return 0
+	}
+	return int64(entries[i-1].position)
+}
+
+// loadIndexTail recovers the tail of a segment's indexes from the index files
+// and the batches written after the last offset index entry, which it
+// returns along with their position.
+func loadIndexTail(seg segment) (indexTail, []byte, int64) {
+	tail := newIndexTail()
+	if seg.size == 0 {
+		return tail, nil, 0
+	}
+	if entries := readIndex(seg); len(entries) > 0 {
+		tail.position = int64(entries[len(entries)-1].position)
+	}
+	if times := readTimeIndex(seg); len(times) > 0 {
+		last := times[len(times)-1]
+		tail.maxTimestamp, tail.timeIndexed = last.timestamp, last.timestamp
+		tail.maxTimestampOffset = seg.baseOffset + int64(last.relOffset)
+	}
+	data, err := readSegmentFrom(seg.path, tail.position)
+	if err != nil {
+		return tail, nil, tail.position
+	}
+	return tail, data[:min(int64(len(data)), seg.size-tail.position)], tail.position
+}
+
+// appendIndex extends the indexes of st's segment seg for data just appended
+// at position start. The indexes are only accelerators: if one cannot be
+// written it is removed, and the next read rebuilds it.
+func appendIndex(st *partitionState, seg segment, data []byte, start int64) {
+	st.indexMu.Lock()
+	tail, ok := st.tail, st.tailPath == seg.path
+	st.indexMu.Unlock()
+	if !ok {
+		var pending []byte
+		var pendingStart int64
+		tail, pending, pendingStart = loadIndexTail(segment{baseOffset: seg.baseOffset, path: seg.path, size: start})
+		data = append(pending, data...)
+		start = pendingStart
+	}
+
+	entries, times, tail := indexEntriesFor(seg.baseOffset, data, start, tail)
+
+	st.indexMu.Lock()
+	defer st.indexMu.Unlock()
+	if len(times) > 0 {
+		st.forgetTimeIndex(seg.path)
+	}
+	err := appendFile(indexPath(seg.path), encodeIndex(entries))
+	if err != nil {
+		_ = os.Remove(indexPath(seg.path))
+	}
+	terr := appendFile(timeIndexPath(seg.path), encodeTimeIndex(times))
+	if terr != nil {
+		_ = os.Remove(timeIndexPath(seg.path))
+	}
+	if err != nil || terr != nil {
+		st.tailPath = ""
+		return
+	}
+	st.tailPath, st.tail = seg.path, tail
+}
+
+func appendFile(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
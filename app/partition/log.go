@@ -0,0 +1,29 @@
+package partition
+
+type AppendResult struct {
+	BaseOffset     int64
+	LogAppendTime  int64
+	LogStartOffset int64
+}
+
+// LogManager is the storage stage of the produce path. The produce handler and
+// anything else that writes already-validated batches go through it.
+type LogManager interface {
+	Append(topicName string, partition int32, records []byte) (AppendResult, error)
+}
+
+var Logs LogManager = fileLogs{}
+
+type fileLogs struct{}
+
+func (fileLogs) Append(topicName string, partition int32, records []byte) (AppendResult, error) {
+	baseOffset, err := WriteRecords(topicName, partition, records)
+	if err != nil {
+		return AppendResult{}, err
+	}
+	return AppendResult{
+		BaseOffset:     baseOffset,
+		LogAppendTime:  -1,
+		LogStartOffset: GetOffsets(topicName, partition).LogStartOffset,
+	}, nil
+}
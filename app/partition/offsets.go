@@ -0,0 +1,85 @@
+package partition
+
+import (
+	"os"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
+	"github.com/codecrafters-io/kafka-starter-go/app/stats"
+)
+
+type Offsets struct {
+	LogStartOffset int64
+	LogEndOffset   int64
+	HighWatermark  int64
+}
+
+// GetOffsets returns the cached offsets for a partition, recovering them from
+// its checkpoint and log once on first use.
+func GetOffsets(topicName string, partition int32) Offsets {
+	st := stateFor(topicName, partition)
+
+	st.offsetsMu.RLock()
+	o, ok := st.offsets, st.offsetsLoaded
+	st.offsetsMu.RUnlock()
+	if ok {
+		return o
+	}
+
+	st.offsetsMu.Lock()
+	defer st.offsetsMu.Unlock()
+	if st.offsetsLoaded {
+		return st.offsets
+	}
+	segs := segments(topicName, partition)
+	o = loadOffsets(topicName, partition, segs)
+	st.offsets, st.offsetsLoaded = o, true
+
+	var size int64
+	var flushed time.Time
+	for _, s := range segs {
+		size += s.size
+	}
+	if len(segs) > 0 {
+		if info, err := os.Stat(segs[len(segs)-1].path); err == nil {
+			flushed = info.ModTime()
+		}
+	}
+	recordLogGauges(topicName, partition, size, len(segs), o, flushed)
+	return o
+}
+
+func (st *partitionState) setOffsets(o Offsets) {
+	st.offsetsMu.Lock()
+	st.offsets, st.offsetsLoaded = o, true
+	st.offsetsMu.Unlock()
+}
+
+// recordLogGauges publishes a partition's log gauges: size is the total across
+// all of its segments.
+func recordLogGauges(topicName string, partition int32, size int64, segments int, o Offsets, flushed time.Time) {
+	stats.Default.SetPartitionLog(topicName, partition, stats.PartitionLog{
+		SizeBytes:      size,
+		LogStartOffset: o.LogStartOffset,
+		LogEndOffset:   o.LogEndOffset,
+		Segments:       segments,
+		LastFlush:      flushed,
+	})
+}
+
+func computeOffsets(data []byte) Offsets {
+	var o Offsets
+	first := true
+	forEachBatch(data, func(h recordbatch.Header) {
+		if first {
+			o.LogStartOffset = h.BaseOffset
+			first = false
+		}
+		o.LogEndOffset = h.LastOffset() + 1
+	})
+	if first {
+		return Offsets{}
+	}
+	o.HighWatermark = o.LogEndOffset
+	return o
+}
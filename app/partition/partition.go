@@ -3,27 +3,226 @@ package partition
 import (
 	"fmt"
 	"os"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/stats"
 )
 
+// ReadRecords returns a partition's whole log, every segment in offset order.
 func ReadRecords(topicName string, partition int32) []byte {
-	logPath := fmt.Sprintf("/tmp/kraft-combined-logs/%s-%d/00000000000000000000.log", topicName, partition)
+	return readSegments(segments(topicName, partition), 0)
+}
 
-	data, err := os.ReadFile(logPath)
-	if err != nil {
+// ReadRecordsFrom returns the whole batches from the one containing offset
+// onwards that fit in maxBytes, the first of them even if it alone does not.
+// Only the segments that can hold them are read, and only the byte range
+// needed: from the position the offset index points at, for as far as the
+// batch headers say the answer reaches. Segments written in message format
+// v0/v1, as an old broker may have left them, are refused with
+// UNSUPPORTED_FOR_MESSAGE_FORMAT rather than served as if they were v2.
+func ReadRecordsFrom(topicName string, partition int32, offset int64, maxBytes int) ([]byte, error) {
+	segs := segments(topicName, partition)
+	if len(segs) == 0 {
+		return nil, nil
+	}
+	i := segmentFor(segs, offset)
+	start := indexPosition(segs[i], offset)
+	want := int64(maxBytes) + IndexIntervalBytes
+	for {
+		data, eof := readSegmentsRange(segs[i:], start, want)
+		if magic, ok := legacyFormat(data); ok {
+			return nil, errors.Newf(errors.ErrUnsupportedForMessageFormat, "log of %s-%d holds message format v%d batches", topicName, partition, magic)
+		}
+		records := RecordsFrom(data, offset)
+		end, need := batchesWithin(records, maxBytes)
+		if need == 0 || eof {
+			return records[:end], nil
+		}
+		// The batches skipped to reach offset, or those to return, run
+		// past what was read. Read again as far as the headers say, or
+		// twice as far while the batch holding offset is still cut off.
+		if len(records) == 0 {
+			want *= 2
+		} else {
+			want = int64(len(data)-len(records)) + int64(need)
+		}
+	}
+}
+
+func CheckLogDir(topicName string, partition int32) error {
+	logDir := Dir(topicName, partition)
+
+	info, err := os.Stat(logDir)
+	if os.IsNotExist(err) {
 		return nil
 	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", logDir)
+	}
+
+	f, err := os.Open(logDir)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func CreateLogDir(topicName string, partition int32) error {
+	return os.MkdirAll(Dir(topicName, partition), 0755)
+}
+
+// DeleteLogDir removes a partition's directory and forgets its cached
+// offsets and gauges, so a topic later recreated under the same name starts
+// from an empty log. It waits for an append in progress, and appends queued
+// behind it fail with UNKNOWN_TOPIC_OR_PARTITION.
+func DeleteLogDir(topicName string, partition int32) error {
+	st := stateFor(topicName, partition)
+	st.appendMu.Lock()
+	defer st.appendMu.Unlock()
+	if err := os.RemoveAll(Dir(topicName, partition)); err != nil {
+		return err
+	}
+	st.deleted = true
+	st.forgetTimeIndexes()
+	dropState(topicName, partition)
+	stats.Default.RemovePartitionLog(topicName, partition)
+	return nil
+}
+
+// WriteRecords appends a validated record set to the end of a partition's
+// log and returns the offset assigned to its first record. The baseOffset of
+// every batch is rewritten in place so the batches follow on from the current
+// log end offset.
+func WriteRecords(topicName string, partition int32, records []byte) (int64, error) {
+	if coalescingEnabled() {
+		return coalescedWrite(topicName, partition, records)
+	}
+	bases, err := writeLog(topicName, partition, [][]byte{records}, false)
+	if err != nil {
+		return -1, err
+	}
+	return bases[0], nil
+}
+
+// Sync makes every partition's log durable before the broker exits: pending
+// coalesced writes are flushed, then each active segment is fsynced under
+// its append lock. Writes made without coalescing may otherwise still be in
+// the page cache. Only partitions used since startup can have such writes.
+// It returns the first error met but syncs what it can.
+func Sync() error {
+	flushPending()
 
-	return data
+	var first error
+	for _, st := range allStates() {
+		if err := st.syncActiveSegment(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
 }
 
-func WriteRecords(topicName string, partition int32, records []byte) error {
-	logDir := fmt.Sprintf("/tmp/kraft-combined-logs/%s-%d", topicName, partition)
+func (st *partitionState) syncActiveSegment() error {
+	st.appendMu.Lock()
+	defer st.appendMu.Unlock()
 
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	segs := segments(st.topicName, st.partition)
+	if len(segs) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(segs[len(segs)-1].path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
 		return err
 	}
+	return f.Close()
+}
+
+// writeLog appends the record sets in order with a single write, returning the
+// base offset assigned to each.
+func writeLog(topicName string, partition int32, writes [][]byte, fsync bool) ([]int64, error) {
+	st := stateFor(topicName, partition)
+	st.appendMu.Lock()
+	defer st.appendMu.Unlock()
+	if st.deleted {
+		return nil, errors.Newf(errors.ErrUnknownTopicOrPartition, "%s-%d was deleted", topicName, partition)
+	}
 
-	logPath := fmt.Sprintf("%s/00000000000000000000.log", logDir)
+	if err := os.MkdirAll(Dir(topicName, partition), 0755); err != nil {
+		return nil, err
+	}
+
+	o := GetOffsets(topicName, partition)
+	next := o.LogEndOffset
+	bases := make([]int64, len(writes))
+	var data []byte
+	for i, records := range writes {
+		bases[i] = next
+		next = assignOffsets(records, next)
+		data = append(data, records...)
+	}
 
-	return os.WriteFile(logPath, records, 0644)
+	// Roll to a new segment, named after the first offset it will hold, once
+	// the active one would grow past log.segment.bytes. A write is never split
+	// across segments, and an empty segment always takes the write.
+	segs := segments(topicName, partition)
+	target := segment{baseOffset: o.LogEndOffset, path: segmentPath(topicName, partition, o.LogEndOffset)}
+	var size int64
+	if len(segs) > 0 {
+		active := segs[len(segs)-1]
+		if active.size == 0 || active.size+int64(len(data)) <= segmentBytes.Load() {
+			target = active
+		}
+		for _, s := range segs {
+			size += s.size
+		}
+	}
+
+	// Rolling seals the active segment, so checkpoint the new recovery point
+	// before the first batch lands past it.
+	if len(segs) > 0 && target.path != segs[len(segs)-1].path {
+		cp := offsetsCheckpoint{LogStartOffset: o.LogStartOffset, RecoveryPoint: o.LogEndOffset}
+		if err := writeOffsetsCheckpoint(topicName, partition, cp); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(target.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if o.LogEndOffset == 0 {
+		o.LogStartOffset = bases[0]
+	}
+	o.LogEndOffset = next
+	o.HighWatermark = next
+	st.setOffsets(o)
+	numSegments := len(segs)
+	appendIndex(st, target, data, target.size)
+
+	if len(segs) == 0 || target.path != segs[len(segs)-1].path {
+		numSegments++
+	}
+	recordLogGauges(topicName, partition, size+int64(len(data)), numSegments, o, time.Now())
+	return bases, nil
 }
@@ -0,0 +1,156 @@
+package partition
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultSegmentBytes mirrors the broker default for log.segment.bytes.
+const DefaultSegmentBytes = 1 << 30
+
+var segmentBytes atomic.Int64
+
+func init() {
+	segmentBytes.Store(DefaultSegmentBytes)
+}
+
+// SetSegmentBytes sets the size at which a partition's active segment is
+// rolled. Non-positive values are ignored.
+func SetSegmentBytes(n int64) {
+	if n > 0 {
+		segmentBytes.Store(n)
+	}
+}
+
+// segment is one file of a partition's log. Like the Java broker's, it is
+// named after the offset of its first record, zero-padded to 20 digits.
+type segment struct {
+	baseOffset int64
+	path       string
+	size       int64
+}
+
+func segmentPath(topicName string, partition int32, baseOffset int64) string {
+	return filepath.Join(Dir(topicName, partition), fmt.Sprintf("%020d.log", baseOffset))
+}
+
+// segments lists a partition's log segments in offset order. Files that are
+// not named like a segment (indexes, checkpoints, ".deleted" leftovers) are
+// skipped.
+func segments(topicName string, partition int32) []segment {
+	entries, err := os.ReadDir(Dir(topicName, partition))
+	if err != nil {
+		return nil
+	}
+
+	var segs []segment
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok || len(name) != 20 || e.IsDir() {
+			continue
+		}
+		base, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segment{
+			baseOffset: base,
+			path:       filepath.Join(Dir(topicName, partition), e.Name()),
+			size:       info.Size(),
+		})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].baseOffset < segs[j].baseOffset })
+	return segs
+}
+
+// segmentFor returns the index of the segment holding offset: the last one
+// whose base offset is not past it.
+func segmentFor(segs []segment, offset int64) int {
+	i := sort.Search(len(segs), func(i int) bool { return segs[i].baseOffset > offset })
+	return max(i-1, 0)
+}
+
+// readSegments concatenates segs, starting the first at byte position start.
+func readSegments(segs []segment, start int64) []byte {
+	var data []byte
+	for i, s := range segs {
+		if i > 0 {
+			start = 0
+		}
+		b, err := readSegmentFrom(s.path, start)
+		if err != nil {
+			continue
+		}
+		if data == nil {
+			data = b
+			continue
+		}
+		data = append(data, b...)
+	}
+	return data
+}
+
+func readSegmentFrom(path string, start int64) ([]byte, error) {
+	if start == 0 {
+		return os.ReadFile(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
+
+// readSegmentsRange reads up to n bytes of segs, starting the first at byte
+// position start and carrying on into the next ones. eof reports that it ran
+// out of log before n bytes.
+func readSegmentsRange(segs []segment, start int64, n int64) (data []byte, eof bool) {
+	data = make([]byte, 0, min(n, 1<<20))
+	for i, s := range segs {
+		if i > 0 {
+			start = 0
+		}
+		b, err := readSegmentRange(s.path, start, n-int64(len(data)))
+		if err != nil {
+			continue
+		}
+		data = append(data, b...)
+		if int64(len(data)) >= n {
+			return data, false
+		}
+	}
+	return data, true
+}
+
+func readSegmentRange(path string, start, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.NewSectionReader(f, start, n))
+}
+
+// ActiveSegmentPath returns the file new batches for a partition are appended
+// to, or "" when the partition has no log yet.
+func ActiveSegmentPath(topicName string, partition int32) string {
+	segs := segments(topicName, partition)
+	if len(segs) == 0 {
+		return ""
+	}
+	return segs[len(segs)-1].path
+}
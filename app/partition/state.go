@@ -0,0 +1,126 @@
+package partition
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// partitionState is everything kept in memory about one partition's log. It
+// is created on the partition's first use, so a broker with many partitions
+// pays only for those it serves, and it holds no open files: segments are
+// opened for each read or append and closed straight after.
+type partitionState struct {
+	topicName string
+	partition int32
+
+	// appendMu serialises offset assignment and the file append, so
+	// concurrent producers never interleave or reuse offsets. deleted, set
+	// under it once the log is removed, fails the appends that were
+	// waiting for it rather than let them recreate the directory.
+	appendMu sync.Mutex
+	deleted  bool
+
+	// offsetsMu guards offsets, recovered from the checkpoint and log once
+	// on first use.
+	offsetsMu     sync.RWMutex
+	offsets       Offsets
+	offsetsLoaded bool
+
+	// indexMu guards the index files and tail, the cached tail of the
+	// indexes of the segment at tailPath, so appends need not reread them.
+	// Only the active segment is ever appended to, so one tail is enough.
+	indexMu  sync.Mutex
+	tailPath string
+	tail     indexTail
+
+	// times holds the decoded time indexes of the partition's segments by
+	// path, under indexMu, so concurrent timestamp lookups share one copy
+	// instead of each rereading the file. Rewriting or appending to an
+	// index drops its entry and bumps timesGen.
+	times    map[string][]timeIndexEntry
+	timesGen uint64
+
+	writer partitionWriter
+}
+
+// stateShardCount spreads the partitions over enough maps that looking one
+// up rarely waits on another partition's lookup.
+const stateShardCount = 64
+
+type stateShard struct {
+	mu     sync.RWMutex
+	states map[string]*partitionState
+}
+
+var stateShards [stateShardCount]stateShard
+
+func partitionKey(topicName string, partition int32) string {
+	return topicName + "-" + strconv.Itoa(int(partition))
+}
+
+// shardFor hashes key with FNV-1a.
+func shardFor(key string) *stateShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &stateShards[h%stateShardCount]
+}
+
+// stateFor returns the partition's state, creating it on first use.
+func stateFor(topicName string, partition int32) *partitionState {
+	key := partitionKey(topicName, partition)
+	shard := shardFor(key)
+
+	shard.mu.RLock()
+	st, ok := shard.states[key]
+	shard.mu.RUnlock()
+	if ok {
+		return st
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if st, ok := shard.states[key]; ok {
+		return st
+	}
+	if shard.states == nil {
+		shard.states = map[string]*partitionState{}
+	}
+	st = &partitionState{topicName: topicName, partition: partition}
+	shard.states[key] = st
+	return st
+}
+
+// segmentState returns the state of the partition seg belongs to, named by
+// its directory.
+func segmentState(seg segment) *partitionState {
+	topicName, partition, _ := ParseDir(filepath.Base(filepath.Dir(seg.path)))
+	return stateFor(topicName, partition)
+}
+
+// dropState forgets a partition's state, so one recreated under the same
+// name starts afresh.
+func dropState(topicName string, partition int32) {
+	key := partitionKey(topicName, partition)
+	shard := shardFor(key)
+	shard.mu.Lock()
+	delete(shard.states, key)
+	shard.mu.Unlock()
+}
+
+// allStates returns the state of every partition used since startup.
+func allStates() []*partitionState {
+	var out []*partitionState
+	for i := range stateShards {
+		shard := &stateShards[i]
+		shard.mu.RLock()
+		for _, st := range shard.states {
+			out = append(out, st)
+		}
+		shard.mu.RUnlock()
+	}
+	return out
+}
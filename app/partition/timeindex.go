@@ -0,0 +1,200 @@
+package partition
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
+)
+
+const timeIndexEntrySize = 12
+
+// timeIndexEntry is one entry of a segment's time index, in the Java broker's
+// layout: the segment's largest timestamp so far, and the last offset of the
+// batch holding it relative to the segment's base offset.
+type timeIndexEntry struct {
+	timestamp int64
+	relOffset int32
+}
+
+func timeIndexPath(segPath string) string {
+	return strings.TrimSuffix(segPath, ".log") + ".timeindex"
+}
+
+// maxCachedTimeIndexEntries bounds the time index entries held across all
+// partitions, about 48MB; indexes read past it are used once and dropped.
+const maxCachedTimeIndexEntries = 4 << 20
+
+var cachedTimeIndexEntries atomic.Int64
+
+// readTimeIndex returns a segment's time index, loading it when it isn't
+// cached and rebuilding it when it is missing or its entries do not
+// increase. The result is shared and must not be modified.
+func readTimeIndex(seg segment) []timeIndexEntry {
+	st := segmentState(seg)
+	st.indexMu.Lock()
+	times, ok := st.times[seg.path]
+	gen := st.timesGen
+	st.indexMu.Unlock()
+	if ok {
+		return times
+	}
+
+	times = loadTimeIndex(seg)
+	n := int64(len(times))
+	st.indexMu.Lock()
+	defer st.indexMu.Unlock()
+	if _, ok := st.times[seg.path]; ok || st.timesGen != gen {
+		return times
+	}
+	if cachedTimeIndexEntries.Add(n) > maxCachedTimeIndexEntries {
+		cachedTimeIndexEntries.Add(-n)
+		return times
+	}
+	if st.times == nil {
+		st.times = map[string][]timeIndexEntry{}
+	}
+	st.times[seg.path] = times
+	return times
+}
+
+func loadTimeIndex(seg segment) []timeIndexEntry {
+	if data, err := os.ReadFile(timeIndexPath(seg.path)); err == nil {
+		if times, ok := decodeTimeIndex(data); ok {
+			return times
+		}
+	}
+	_, times := rebuildIndexes(seg)
+	return times
+}
+
+// forgetTimeIndex drops a segment's cached time index; the caller holds
+// st.indexMu and is about to change the file.
+func (st *partitionState) forgetTimeIndex(path string) {
+	st.timesGen++
+	if times, ok := st.times[path]; ok {
+		cachedTimeIndexEntries.Add(-int64(len(times)))
+		delete(st.times, path)
+	}
+}
+
+// forgetTimeIndexes drops all of a partition's cached time indexes, once
+// its log is gone.
+func (st *partitionState) forgetTimeIndexes() {
+	st.indexMu.Lock()
+	defer st.indexMu.Unlock()
+	for path := range st.times {
+		st.forgetTimeIndex(path)
+	}
+}
+
+func decodeTimeIndex(data []byte) ([]timeIndexEntry, bool) {
+	if len(data)%timeIndexEntrySize != 0 {
+		return nil, false
+	}
+	times := make([]timeIndexEntry, 0, len(data)/timeIndexEntrySize)
+	for off := 0; off < len(data); off += timeIndexEntrySize {
+		e := timeIndexEntry{
+			timestamp: int64(binary.BigEndian.Uint64(data[off : off+8])),
+			relOffset: int32(binary.BigEndian.Uint32(data[off+8 : off+12])),
+		}
+		if e.relOffset < 0 {
+			return nil, false
+		}
+		if n := len(times); n > 0 && (e.timestamp <= times[n-1].timestamp || e.relOffset < times[n-1].relOffset) {
+			return nil, false
+		}
+		times = append(times, e)
+	}
+	return times, true
+}
+
+func encodeTimeIndex(times []timeIndexEntry) []byte {
+	b := make([]byte, 0, len(times)*timeIndexEntrySize)
+	for _, e := range times {
+		b = binary.BigEndian.AppendUint64(b, uint64(e.timestamp))
+		b = binary.BigEndian.AppendUint32(b, uint32(e.relOffset))
+	}
+	return b
+}
+
+// OffsetForTimestamp finds the first record whose timestamp is at or after
+// ts, returning its offset and timestamp; ok is false when no record is that
+// recent. In each segment the time index gives the last offset known to
+// precede ts, the offset index turns that into a position, and the batches
+// from there are scanned.
+func OffsetForTimestamp(topicName string, partition int32, ts int64) (offset, timestamp int64, ok bool) {
+	for _, seg := range segments(topicName, partition) {
+		times := readTimeIndex(seg)
+		i := sort.Search(len(times), func(i int) bool { return times[i].timestamp >= ts })
+
+		var pos int64
+		if i > 0 {
+			pos = indexPosition(seg, seg.baseOffset+int64(times[i-1].relOffset))
+		}
+		data, err := readSegmentFrom(seg.path, pos)
+		if err != nil {
+			continue
+		}
+
+		forEachBatchSpan(data, func(s, e int, h recordbatch.Header) bool {
+			if h.MaxTimestamp < ts {
+				return true
+			}
+			offset, timestamp, ok = firstRecordAtOrAfter(data[s:e], ts)
+			return !ok
+		})
+		if ok {
+			return offset, timestamp, true
+		}
+	}
+	return -1, -1, false
+}
+
+// MaxTimestampOffset finds the record with the largest timestamp in the log,
+// returning its offset and timestamp; ok is false for an empty log. A
+// segment's largest timestamp is at or past the batch its time index's last
+// entry names, so each segment is only scanned from there.
+func MaxTimestampOffset(topicName string, partition int32) (offset, timestamp int64, ok bool) {
+	var best []byte
+	for _, seg := range segments(topicName, partition) {
+		var pos int64
+		if times := readTimeIndex(seg); len(times) > 0 {
+			pos = indexPosition(seg, seg.baseOffset+int64(times[len(times)-1].relOffset))
+		}
+		data, err := readSegmentFrom(seg.path, pos)
+		if err != nil {
+			continue
+		}
+		forEachBatchSpan(data, func(s, e int, h recordbatch.Header) bool {
+			if !ok || h.MaxTimestamp > timestamp {
+				best, timestamp, ok = data[s:e], h.MaxTimestamp, true
+			}
+			return true
+		})
+	}
+	if !ok {
+		return -1, -1, false
+	}
+	offset, timestamp, _ = firstRecordAtOrAfter(best, timestamp)
+	return offset, timestamp, true
+}
+
+// firstRecordAtOrAfter finds the first record in a batch timestamped at or
+// after ts. A batch whose records cannot be decoded is answered with its
+// first offset and max timestamp.
+func firstRecordAtOrAfter(data []byte, ts int64) (int64, int64, bool) {
+	batch, _, err := recordbatch.Decode(data)
+	if err != nil || recordbatch.IsLogAppendTime(batch.Attributes) {
+		return batch.BaseOffset, batch.MaxTimestamp, true
+	}
+	for _, rec := range batch.Records {
+		if recordTs := batch.Timestamp(rec); recordTs >= ts {
+			return batch.Offset(rec), recordTs, true
+		}
+	}
+	return 0, 0, false
+}
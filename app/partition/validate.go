@@ -0,0 +1,137 @@
+package partition
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
+)
+
+// MaxMessageBytes mirrors the broker default for max.message.bytes.
+const MaxMessageBytes = 1048588
+
+// ValidateBatches checks a produced record set before it is appended: every
+// batch must be a complete magic v2 batch with a matching CRC32C, no batch may
+// exceed maxBytes, and a batch carrying a producer ID must carry a valid epoch.
+// Message format v0/v1 sets are refused as unsupported before their (shorter)
+// layout can be mistaken for a corrupt v2 batch.
+func ValidateBatches(records []byte, maxBytes int) error {
+	if len(records) == 0 {
+		return errors.NewKafkaError(errors.ErrCorruptMessage, "record set contains no complete batch")
+	}
+	for off := 0; off < len(records); {
+		if len(records)-off > 16 && records[off+16] < 2 {
+			return errors.Newf(errors.ErrUnsupportedForMessageFormat, "message format v%d is not supported, only v2 record batches", records[off+16])
+		}
+		if off == 0 && len(records) < recordbatch.HeaderSize {
+			return errors.NewKafkaError(errors.ErrCorruptMessage, "record set contains no complete batch")
+		}
+		if len(records)-off < recordbatch.HeaderSize {
+			return errors.NewKafkaError(errors.ErrCorruptMessage, "trailing bytes after last batch")
+		}
+		batchLen := int(int32(binary.BigEndian.Uint32(records[off+8 : off+12])))
+		end := off + 12 + batchLen
+		if batchLen < recordbatch.HeaderSize-12 || end > len(records) {
+			return errors.Newf(errors.ErrCorruptMessage, "batch at byte %d has invalid length %d", off, batchLen)
+		}
+		if end-off > maxBytes {
+			return errors.Newf(errors.ErrMessageTooLarge, "batch of %d bytes exceeds max.message.bytes %d", end-off, maxBytes)
+		}
+		batch := records[off:end]
+		if magic := batch[16]; magic != 2 {
+			return errors.Newf(errors.ErrUnsupportedForMessageFormat, "unsupported batch magic %d", magic)
+		}
+		if !recordbatch.ValidCRC(batch) {
+			return errors.NewKafkaError(errors.ErrCorruptMessage, "batch CRC mismatch")
+		}
+		h, _, err := recordbatch.ParseHeader(batch)
+		if err != nil {
+			return errors.NewKafkaError(errors.ErrCorruptMessage, err.Error())
+		}
+		if h.ProducerID >= 0 && h.ProducerEpoch < 0 {
+			return errors.Newf(errors.ErrInvalidProducerEpoch, "producer %d sent batch without an epoch", h.ProducerID)
+		}
+		if err := validateRecordCount(h, batch); err != nil {
+			return err
+		}
+		off = end
+	}
+	return nil
+}
+
+// validateRecordCount checks that a batch's recordCount and lastOffsetDelta
+// describe the records it actually carries: exactly recordCount records whose
+// offset deltas run densely from 0 to lastOffsetDelta. Fetching consumers
+// trust both fields, so a mismatch is refused rather than stored.
+func validateRecordCount(h recordbatch.Header, batch []byte) error {
+	raw, err := recordbatch.Decompress(recordbatch.Codec(h.Attributes), h.Records(batch))
+	if err != nil {
+		return errors.Newf(errors.ErrCorruptMessage, "batch records cannot be decompressed: %v", err)
+	}
+
+	if h.RecordCount <= 0 {
+		return errors.Newf(errors.ErrInvalidRecord, "batch has record count %d", h.RecordCount)
+	}
+	if h.LastOffsetDelta != h.RecordCount-1 {
+		return errors.Newf(errors.ErrInvalidRecord, "batch of %d records has last offset delta %d", h.RecordCount, h.LastOffsetDelta)
+	}
+
+	records, err := recordbatch.DecodeRecords(raw, int(h.RecordCount))
+	if err != nil {
+		return errors.Newf(errors.ErrInvalidRecord, "batch claims %d records: %v", h.RecordCount, err)
+	}
+	for i, rec := range records {
+		if rec.OffsetDelta != int32(i) {
+			return errors.Newf(errors.ErrInvalidRecord, "record %d has offset delta %d", i, rec.OffsetDelta)
+		}
+	}
+	return nil
+}
+
+// ValidateTimestamps checks every CreateTime record timestamp in a produced
+// record set against the broker clock: none may be more than before
+// milliseconds behind now or after milliseconds ahead of it. Records without
+// a timestamp and LogAppendTime batches are exempt; a batch whose records
+// cannot be decoded is judged by its first and max timestamps.
+func ValidateTimestamps(records []byte, now, before, after int64) error {
+	if before == math.MaxInt64 && after == math.MaxInt64 {
+		return nil
+	}
+	check := func(ts int64) error {
+		if ts == -1 {
+			return nil
+		}
+		if before != math.MaxInt64 && ts < now-before {
+			return errors.Newf(errors.ErrInvalidTimestamp, "timestamp %d is more than %dms before the broker clock %d", ts, before, now)
+		}
+		if after != math.MaxInt64 && ts > now+after {
+			return errors.Newf(errors.ErrInvalidTimestamp, "timestamp %d is more than %dms after the broker clock %d", ts, after, now)
+		}
+		return nil
+	}
+
+	var err error
+	forEachBatchSpan(records, func(s, e int, h recordbatch.Header) bool {
+		if recordbatch.IsLogAppendTime(h.Attributes) {
+			return true
+		}
+		batch, _, derr := recordbatch.Decode(records[s:e])
+		if derr != nil {
+			if err = check(h.FirstTimestamp); err == nil {
+				err = check(h.MaxTimestamp)
+			}
+			return err == nil
+		}
+		if h.FirstTimestamp == -1 {
+			return true
+		}
+		for _, rec := range batch.Records {
+			if err = check(batch.Timestamp(rec)); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	return err
+}
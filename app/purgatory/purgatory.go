@@ -0,0 +1,132 @@
+package purgatory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTick      = time.Millisecond
+	defaultWheelSize = 20
+)
+
+// Purgatory parks requests that cannot be answered yet (fetch max_wait,
+// acks=all, rebalance delays) until they are completed or their timeout
+// fires, whichever happens first.
+type Purgatory struct {
+	timer *Timer
+
+	mu       sync.Mutex
+	watchers map[string]map[*Operation]struct{}
+}
+
+type Operation struct {
+	mu          sync.Mutex
+	completed   bool
+	task        *Task
+	keys        []string
+	tryComplete func() bool
+	onComplete  func(expired bool)
+}
+
+var Default = New()
+
+func New() *Purgatory {
+	return &Purgatory{
+		timer:    NewTimer(defaultTick, defaultWheelSize),
+		watchers: make(map[string]map[*Operation]struct{}),
+	}
+}
+
+func PartitionKey(topicName string, partition int32) string {
+	return fmt.Sprintf("%s-%d", topicName, partition)
+}
+
+func (p *Purgatory) Park(timeout time.Duration, onComplete func(expired bool)) *Operation {
+	op := &Operation{onComplete: onComplete}
+	op.mu.Lock()
+	op.task = p.timer.AfterFunc(timeout, func() { p.finish(op, true) })
+	op.mu.Unlock()
+	return op
+}
+
+// ParkWatching parks an operation that CheckAndComplete re-evaluates whenever
+// one of keys changes. tryComplete is checked once more after the watchers are
+// registered, so a change racing with the park is never missed.
+func (p *Purgatory) ParkWatching(timeout time.Duration, keys []string, tryComplete func() bool, onComplete func(expired bool)) *Operation {
+	op := &Operation{keys: keys, tryComplete: tryComplete, onComplete: onComplete}
+
+	p.mu.Lock()
+	for _, key := range keys {
+		if p.watchers[key] == nil {
+			p.watchers[key] = make(map[*Operation]struct{})
+		}
+		p.watchers[key][op] = struct{}{}
+	}
+	p.mu.Unlock()
+
+	op.mu.Lock()
+	op.task = p.timer.AfterFunc(timeout, func() { p.finish(op, true) })
+	op.mu.Unlock()
+
+	if tryComplete() {
+		p.Complete(op)
+	}
+	return op
+}
+
+// CheckAndComplete completes every operation watching key whose condition is
+// now satisfied and returns how many were completed.
+func (p *Purgatory) CheckAndComplete(key string) int {
+	p.mu.Lock()
+	ops := make([]*Operation, 0, len(p.watchers[key]))
+	for op := range p.watchers[key] {
+		ops = append(ops, op)
+	}
+	p.mu.Unlock()
+
+	completed := 0
+	for _, op := range ops {
+		if op.tryComplete() && p.Complete(op) {
+			completed++
+		}
+	}
+	return completed
+}
+
+// Complete finishes op before its timeout, reporting false if it had already
+// completed or expired.
+func (p *Purgatory) Complete(op *Operation) bool {
+	op.mu.Lock()
+	task := op.task
+	op.mu.Unlock()
+	if task != nil {
+		p.timer.Cancel(task)
+	}
+	return p.finish(op, false)
+}
+
+func (p *Purgatory) finish(op *Operation, expired bool) bool {
+	op.mu.Lock()
+	if op.completed {
+		op.mu.Unlock()
+		return false
+	}
+	op.completed = true
+	op.mu.Unlock()
+
+	if len(op.keys) > 0 {
+		p.mu.Lock()
+		for _, key := range op.keys {
+			delete(p.watchers[key], op)
+			if len(p.watchers[key]) == 0 {
+				delete(p.watchers, key)
+			}
+		}
+		p.mu.Unlock()
+	}
+
+	op.onComplete(expired)
+	return true
+}
@@ -0,0 +1,251 @@
+package purgatory
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Timer schedules callbacks on a hierarchical timing wheel. Tasks are kept in
+// per-tick buckets, and only buckets (not tasks) go into the priority queue
+// that drives the clock, so parking thousands of delayed requests costs a
+// list insertion each rather than a goroutine or a runtime timer each.
+type Timer struct {
+	mu    sync.Mutex
+	wheel *timingWheel
+	queue bucketQueue
+	wake  chan struct{}
+	done  chan struct{}
+}
+
+type Task struct {
+	expiration int64
+	fn         func()
+	bucket     *bucket
+	elem       *list.Element
+	cancelled  bool
+}
+
+func NewTimer(tick time.Duration, wheelSize int) *Timer {
+	t := &Timer{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	t.wheel = newTimingWheel(tick.Milliseconds(), wheelSize, nowMs(), &t.queue)
+	go t.run()
+	return t
+}
+
+func (t *Timer) AfterFunc(d time.Duration, fn func()) *Task {
+	task := &Task{expiration: nowMs() + d.Milliseconds(), fn: fn}
+
+	t.mu.Lock()
+	added := t.wheel.add(task)
+	t.mu.Unlock()
+
+	if !added {
+		go fn()
+		return task
+	}
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return task
+}
+
+// Cancel stops the task if it has not fired yet and reports whether it did.
+func (t *Timer) Cancel(task *Task) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if task.cancelled || task.bucket == nil {
+		return false
+	}
+	task.cancelled = true
+	task.bucket.remove(task)
+	return true
+}
+
+func (t *Timer) Stop() {
+	close(t.done)
+}
+
+func (t *Timer) run() {
+	for {
+		t.mu.Lock()
+		wait := time.Duration(-1)
+		if len(t.queue) > 0 {
+			wait = time.Duration(t.queue[0].expiration-nowMs()) * time.Millisecond
+		}
+		t.mu.Unlock()
+
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+
+		select {
+		case <-t.done:
+			return
+		case <-t.wake:
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		for _, fn := range t.expire(nowMs()) {
+			fn()
+		}
+	}
+}
+
+func (t *Timer) expire(now int64) []func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var due []func()
+	for len(t.queue) > 0 && t.queue[0].expiration <= now {
+		b := heap.Pop(&t.queue).(*bucket)
+		t.wheel.advanceClock(b.expiration)
+		for _, task := range b.flush() {
+			if task.cancelled {
+				continue
+			}
+			if !t.wheel.add(task) {
+				task.cancelled = true
+				due = append(due, task.fn)
+			}
+		}
+	}
+	return due
+}
+
+type timingWheel struct {
+	tickMs      int64
+	wheelSize   int64
+	interval    int64
+	currentTime int64
+	buckets     []*bucket
+	overflow    *timingWheel
+	queue       *bucketQueue
+}
+
+func newTimingWheel(tickMs int64, wheelSize int, startMs int64, queue *bucketQueue) *timingWheel {
+	if tickMs <= 0 {
+		tickMs = 1
+	}
+	w := &timingWheel{
+		tickMs:      tickMs,
+		wheelSize:   int64(wheelSize),
+		interval:    tickMs * int64(wheelSize),
+		currentTime: startMs - startMs%tickMs,
+		buckets:     make([]*bucket, wheelSize),
+		queue:       queue,
+	}
+	for i := range w.buckets {
+		w.buckets[i] = &bucket{expiration: -1, index: -1}
+	}
+	return w
+}
+
+func (w *timingWheel) add(task *Task) bool {
+	switch {
+	case task.cancelled:
+		return false
+	case task.expiration < w.currentTime+w.tickMs:
+		return false
+	case task.expiration < w.currentTime+w.interval:
+		virtualID := task.expiration / w.tickMs
+		b := w.buckets[virtualID%w.wheelSize]
+		b.add(task)
+		if b.expiration != virtualID*w.tickMs {
+			b.expiration = virtualID * w.tickMs
+			if b.index < 0 {
+				heap.Push(w.queue, b)
+			} else {
+				heap.Fix(w.queue, b.index)
+			}
+		}
+		return true
+	default:
+		if w.overflow == nil {
+			w.overflow = newTimingWheel(w.interval, int(w.wheelSize), w.currentTime, w.queue)
+		}
+		return w.overflow.add(task)
+	}
+}
+
+func (w *timingWheel) advanceClock(timeMs int64) {
+	if timeMs < w.currentTime+w.tickMs {
+		return
+	}
+	w.currentTime = timeMs - timeMs%w.tickMs
+	if w.overflow != nil {
+		w.overflow.advanceClock(w.currentTime)
+	}
+}
+
+type bucket struct {
+	expiration int64
+	index      int
+	tasks      list.List
+}
+
+func (b *bucket) add(task *Task) {
+	task.bucket = b
+	task.elem = b.tasks.PushBack(task)
+}
+
+func (b *bucket) remove(task *Task) {
+	if task.bucket == b && task.elem != nil {
+		b.tasks.Remove(task.elem)
+	}
+	task.bucket = nil
+	task.elem = nil
+}
+
+func (b *bucket) flush() []*Task {
+	tasks := make([]*Task, 0, b.tasks.Len())
+	for e := b.tasks.Front(); e != nil; e = e.Next() {
+		task := e.Value.(*Task)
+		task.bucket = nil
+		task.elem = nil
+		tasks = append(tasks, task)
+	}
+	b.tasks.Init()
+	b.expiration = -1
+	return tasks
+}
+
+type bucketQueue []*bucket
+
+func (q bucketQueue) Len() int           { return len(q) }
+func (q bucketQueue) Less(i, j int) bool { return q[i].expiration < q[j].expiration }
+func (q bucketQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *bucketQueue) Push(x any) {
+	b := x.(*bucket)
+	b.index = len(*q)
+	*q = append(*q, b)
+}
+
+func (q *bucketQueue) Pop() any {
+	old := *q
+	b := old[len(old)-1]
+	old[len(old)-1] = nil
+	b.index = -1
+	*q = old[:len(old)-1]
+	return b
+}
+
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
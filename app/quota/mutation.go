@@ -0,0 +1,72 @@
+package quota
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mutationBurstSeconds is the token bucket's depth in seconds of the rate,
+// the broker default of 11 one-second quota samples.
+const mutationBurstSeconds = 11
+
+var (
+	mutationLimit atomic.Int64
+
+	mutationMu      sync.Mutex
+	mutationBuckets = map[string]*tokenBucket{}
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// SetControllerMutationRate limits how many partitions each client may
+// create or delete per second (KIP-599). Zero disables the quota.
+func SetControllerMutationRate(partitionsPerSec int64) {
+	mutationLimit.Store(partitionsPerSec)
+}
+
+// MutationThrottleMs returns how long the client must wait before its next
+// topic or partition mutation is accepted, or zero if it may go ahead.
+func MutationThrottleMs(clientID string) int32 {
+	limit := mutationLimit.Load()
+	if limit <= 0 {
+		return 0
+	}
+	mutationMu.Lock()
+	defer mutationMu.Unlock()
+	b := refill(clientID, float64(limit), time.Now())
+	if b.tokens >= 0 {
+		return 0
+	}
+	return int32(math.Ceil(-b.tokens / float64(limit) * 1000))
+}
+
+// RecordMutations charges n partition mutations to the client. As in the
+// Java broker a mutation is admitted whenever the bucket isn't already in
+// debt, so one large request may overdraw it and throttle the next ones.
+func RecordMutations(clientID string, n int) {
+	limit := mutationLimit.Load()
+	if limit <= 0 || n <= 0 {
+		return
+	}
+	mutationMu.Lock()
+	defer mutationMu.Unlock()
+	refill(clientID, float64(limit), time.Now()).tokens -= float64(n)
+}
+
+func refill(clientID string, limit float64, now time.Time) *tokenBucket {
+	burst := limit * mutationBurstSeconds
+	b, ok := mutationBuckets[clientID]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		mutationBuckets[clientID] = b
+		return b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit)
+	b.last = now
+	return b
+}
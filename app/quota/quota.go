@@ -0,0 +1,54 @@
+package quota
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/stats"
+)
+
+const windowMs = 10_000
+
+var (
+	produceLimit atomic.Int64
+	fetchLimit   atomic.Int64
+)
+
+// LoadProperties applies the default client quotas from server.properties:
+// producer_byte_rate and consumer_byte_rate in bytes per second and
+// controller_mutation_rate in partitions per second, per client ID as
+// Kafka's client quota configs of the same names.
+func LoadProperties(props map[string]string) {
+	if n, err := strconv.ParseInt(props["producer_byte_rate"], 10, 64); err == nil && n > 0 {
+		SetProduceByteRate(n)
+	}
+	if n, err := strconv.ParseInt(props["consumer_byte_rate"], 10, 64); err == nil && n > 0 {
+		SetFetchByteRate(n)
+	}
+	if n, err := strconv.ParseInt(props["controller_mutation_rate"], 10, 64); err == nil && n > 0 {
+		SetControllerMutationRate(n)
+	}
+}
+
+func SetProduceByteRate(bytesPerSec int64) {
+	produceLimit.Store(bytesPerSec)
+}
+
+func SetFetchByteRate(bytesPerSec int64) {
+	fetchLimit.Store(bytesPerSec)
+}
+
+func ProduceThrottleMs(clientID string) int32 {
+	return throttleMs(stats.Default.ClientBytesInRate(clientID), produceLimit.Load())
+}
+
+func FetchThrottleMs(clientID string) int32 {
+	return throttleMs(stats.Default.ClientBytesOutRate(clientID), fetchLimit.Load())
+}
+
+func throttleMs(observed float64, limit int64) int32 {
+	if limit <= 0 || observed <= float64(limit) {
+		return 0
+	}
+	return int32((observed - float64(limit)) / float64(limit) * windowMs)
+}
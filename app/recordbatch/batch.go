@@ -0,0 +1,126 @@
+// Package recordbatch decodes and encodes magic v2 record batches and the
+// records inside them.
+package recordbatch
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Batch is a decoded record batch: its header and its records, inflated if
+// the batch was compressed.
+type Batch struct {
+	Header
+	Records []Record
+}
+
+// Decode decodes the batch at the start of data and returns it with the
+// batch's size. A batch whose records cannot be inflated comes back with its
+// header, no records, and the error; any error with a non-zero size means
+// the batch can be stepped over.
+func Decode(data []byte) (Batch, int, error) {
+	h, size, err := ParseHeader(data)
+	if err != nil {
+		return Batch{Header: h}, size, err
+	}
+	raw, err := Decompress(Codec(h.Attributes), h.Records(data[:size]))
+	if err != nil {
+		return Batch{Header: h}, size, err
+	}
+	records, err := DecodeRecords(raw, int(h.RecordCount))
+	return Batch{Header: h, Records: records}, size, err
+}
+
+// Offset is the absolute offset of the batch's record rec.
+func (b Batch) Offset(rec Record) int64 {
+	return b.BaseOffset + int64(rec.OffsetDelta)
+}
+
+// Timestamp is the absolute timestamp of the batch's record rec. Records of
+// a LogAppendTime batch all carry the batch's max timestamp.
+func (b Batch) Timestamp(rec Record) int64 {
+	if IsLogAppendTime(b.Attributes) {
+		return b.MaxTimestamp
+	}
+	return b.FirstTimestamp + rec.TimestampDelta
+}
+
+// Encode encodes the batch, compressing its records with the codec its
+// attributes name, and derives the length, CRC, last offset delta and record
+// count from the records. A codec Compress doesn't know is dropped and the
+// records are written uncompressed.
+func (b Batch) Encode() []byte {
+	var records []byte
+	for _, rec := range b.Records {
+		records = AppendRecord(records, rec)
+	}
+	lastOffsetDelta := int32(-1)
+	if n := len(b.Records); n > 0 {
+		lastOffsetDelta = b.Records[n-1].OffsetDelta
+	}
+	attributes := b.Attributes
+	if compressed, err := Compress(Codec(attributes), records); err == nil {
+		records = compressed
+	} else {
+		attributes &^= attrCodecMask
+	}
+
+	// Everything from attributes onwards is covered by the CRC.
+	crcd := parser.AppendInt16(nil, attributes)
+	crcd = parser.AppendInt32(crcd, lastOffsetDelta)
+	crcd = parser.AppendInt64(crcd, b.FirstTimestamp)
+	crcd = parser.AppendInt64(crcd, b.MaxTimestamp)
+	crcd = parser.AppendInt64(crcd, b.ProducerID)
+	crcd = parser.AppendInt16(crcd, b.ProducerEpoch)
+	crcd = parser.AppendInt32(crcd, b.BaseSequence)
+	crcd = parser.AppendInt32(crcd, int32(len(b.Records)))
+	crcd = append(crcd, records...)
+
+	out := parser.AppendInt64(nil, b.BaseOffset)
+	out = parser.AppendInt32(out, int32(4+1+4+len(crcd)))
+	out = parser.AppendInt32(out, b.LeaderEpoch)
+	out = parser.AppendInt8(out, 2)
+	out = binary.BigEndian.AppendUint32(out, crc32.Checksum(crcd, crc32c))
+	return append(out, crcd...)
+}
+
+// ValidCRC reports whether a batch's CRC32C matches its contents.
+func ValidCRC(batch []byte) bool {
+	return len(batch) >= HeaderSize && binary.BigEndian.Uint32(batch[17:21]) == crc32.Checksum(batch[21:], crc32c)
+}
+
+// Recompress re-encodes the batch at the start of data with codec, keeping
+// every header field but the codec bits, length and CRC. A batch already in
+// codec is returned as is.
+func Recompress(data []byte, codec int) ([]byte, error) {
+	b, size, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if Codec(b.Attributes) == codec {
+		return data[:size], nil
+	}
+	b.Attributes = b.Attributes&^attrCodecMask | int16(codec)
+	return b.Encode(), nil
+}
+
+// UpdateCRC recomputes a batch's CRC32C after its attributes or anything past
+// them were rewritten in place.
+func UpdateCRC(batch []byte) {
+	binary.BigEndian.PutUint32(batch[17:21], crc32.Checksum(batch[21:], crc32c))
+}
+
+// SetLogAppendTime marks a batch as LogAppendTime stamped with ts, the way
+// the broker rewrites batches produced to a LogAppendTime topic: the
+// attribute bit is set, the max timestamp becomes ts, and the CRC is
+// recomputed since both fields are covered by it.
+func SetLogAppendTime(batch []byte, ts int64) {
+	attrs := int16(binary.BigEndian.Uint16(batch[21:23])) | attrLogAppendTime
+	binary.BigEndian.PutUint16(batch[21:23], uint16(attrs))
+	binary.BigEndian.PutUint64(batch[35:43], uint64(ts))
+	UpdateCRC(batch)
+}
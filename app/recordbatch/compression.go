@@ -0,0 +1,299 @@
+package recordbatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	CompressionNone   = 0
+	CompressionGzip   = 1
+	CompressionSnappy = 2
+	CompressionLZ4    = 3
+	CompressionZstd   = 4
+
+	// attrCodecMask, attrLogAppendTime, attrTransactional and attrControl
+	// are the batch attribute bits holding the compression codec, marking
+	// records stamped with the broker's append time, marking a batch written
+	// inside a transaction, and marking a control batch.
+	attrCodecMask     = 0x07
+	attrLogAppendTime = 0x08
+	attrTransactional = 0x10
+	attrControl       = 0x20
+)
+
+// ErrUnsupportedCodec is returned, wrapped, for batches compressed with a
+// codec Decompress cannot handle.
+var ErrUnsupportedCodec = errors.New("unsupported compression codec")
+
+// Codec returns the compression codec from a batch's attributes.
+func Codec(attributes int16) int {
+	return int(attributes & attrCodecMask)
+}
+
+// IsControl reports whether a batch's attributes mark it as a transaction
+// control batch.
+func IsControl(attributes int16) bool {
+	return attributes&attrControl != 0
+}
+
+// IsTransactional reports whether a batch's attributes mark it as written
+// inside a transaction.
+func IsTransactional(attributes int16) bool {
+	return attributes&attrTransactional != 0
+}
+
+// IsLogAppendTime reports whether a batch's attributes mark its records as
+// stamped with the broker's append time, carried as the max timestamp.
+func IsLogAppendTime(attributes int16) bool {
+	return attributes&attrLogAppendTime != 0
+}
+
+// Decompress inflates the records section of a batch compressed with codec.
+// Codecs outside the five Kafka defines come back as ErrUnsupportedCodec.
+func Decompress(codec int, data []byte) ([]byte, error) {
+	switch codec {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case CompressionSnappy:
+		return decodeXerialSnappy(data)
+	case CompressionLZ4:
+		return decodeLZ4Frame(data)
+	case CompressionZstd:
+		return decodeZstd(data)
+	default:
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedCodec, codec)
+	}
+}
+
+// Compress deflates a records section with codec, in the framing the Java
+// client uses for it so any consumer can read the batch back.
+func Compress(codec int, data []byte) ([]byte, error) {
+	switch codec {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		return encodeXerialSnappy(data), nil
+	case CompressionLZ4:
+		return encodeLZ4Frame(data), nil
+	case CompressionZstd:
+		return encodeZstd(data), nil
+	default:
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedCodec, codec)
+	}
+}
+
+// greedyMatches scans src for repeats of at least four bytes no more than
+// maxOffset back and calls emit for each one, in order and without overlap.
+// Matches start before startLimit and end by endLimit, for formats that
+// insist on a literal tail.
+func greedyMatches(src []byte, maxOffset, startLimit, endLimit int, emit func(start, offset, length int)) {
+	var table [1 << 14]int32
+	for s := 0; s < startLimit; {
+		cur := binary.LittleEndian.Uint32(src[s:])
+		h := (cur * 2654435761) >> 18
+		cand := int(table[h]) - 1
+		table[h] = int32(s + 1)
+		if cand < 0 || s-cand > maxOffset || binary.LittleEndian.Uint32(src[cand:]) != cur {
+			s++
+			continue
+		}
+		n := 4
+		for s+n < endLimit && src[cand+n] == src[s+n] {
+			n++
+		}
+		emit(s, s-cand, n)
+		s += n
+	}
+}
+
+var xerialMagic = []byte{0x82, 'S', 'N', 'A', 'P', 'P', 'Y', 0}
+
+// decodeXerialSnappy handles the Java client's snappy framing: a 16-byte
+// header followed by length-prefixed raw snappy blocks. Unframed input is
+// decoded as a single raw block.
+func decodeXerialSnappy(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, xerialMagic) {
+		return decodeSnappyBlock(data)
+	}
+	if len(data) < 16 {
+		return nil, fmt.Errorf("snappy: short xerial header")
+	}
+	var out []byte
+	for off := 16; off < len(data); {
+		if off+4 > len(data) {
+			return nil, fmt.Errorf("snappy: truncated chunk length")
+		}
+		n := int(binary.BigEndian.Uint32(data[off : off+4]))
+		off += 4
+		if n < 0 || off+n > len(data) {
+			return nil, fmt.Errorf("snappy: truncated chunk")
+		}
+		block, err := decodeSnappyBlock(data[off : off+n])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, block...)
+		off += n
+	}
+	return out, nil
+}
+
+// snappyChunkSize is the uncompressed size of each xerial chunk, the Java
+// client's default block size.
+const snappyChunkSize = 32 << 10
+
+func encodeXerialSnappy(src []byte) []byte {
+	out := append([]byte(nil), xerialMagic...)
+	out = binary.BigEndian.AppendUint32(out, 1)
+	out = binary.BigEndian.AppendUint32(out, 1)
+	for off := 0; off < len(src); off += snappyChunkSize {
+		block := encodeSnappyBlock(src[off:min(off+snappyChunkSize, len(src))])
+		out = binary.BigEndian.AppendUint32(out, uint32(len(block)))
+		out = append(out, block...)
+	}
+	return out
+}
+
+func encodeSnappyBlock(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	anchor := 0
+	greedyMatches(src, 1<<16-1, len(src)-3, len(src), func(start, offset, length int) {
+		dst = appendSnappyLiteral(dst, src[anchor:start])
+		anchor = start + length
+		for length > 0 {
+			n := min(length, 64)
+			dst = append(dst, byte(2|(n-1)<<2), byte(offset), byte(offset>>8))
+			length -= n
+		}
+	})
+	return appendSnappyLiteral(dst, src[anchor:])
+}
+
+func appendSnappyLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	switch n := len(lit) - 1; {
+	case n < 60:
+		dst = append(dst, byte(n<<2))
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	default:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	}
+	return append(dst, lit...)
+}
+
+func decodeSnappyBlock(src []byte) ([]byte, error) {
+	n, hdr := binary.Uvarint(src)
+	if hdr <= 0 || n > 1<<28 {
+		return nil, fmt.Errorf("snappy: invalid block length")
+	}
+	// A copy tag inflates to at most 64 bytes, so a header claiming more
+	// than that per input byte is corrupt; don't allocate for it.
+	if n > uint64(len(src))*64 {
+		return nil, fmt.Errorf("snappy: invalid block length")
+	}
+	dst := make([]byte, 0, n)
+	for s := hdr; s < len(src); {
+		tag := src[s]
+		switch tag & 0x03 {
+		case 0:
+			length := int(tag >> 2)
+			s++
+			if length >= 60 {
+				extra := length - 59
+				if s+extra > len(src) {
+					return nil, fmt.Errorf("snappy: truncated literal length")
+				}
+				length = 0
+				for i := 0; i < extra; i++ {
+					length |= int(src[s+i]) << (8 * i)
+				}
+				s += extra
+			}
+			length++
+			if length <= 0 || s+length > len(src) {
+				return nil, fmt.Errorf("snappy: truncated literal")
+			}
+			if uint64(len(dst)+length) > n {
+				return nil, fmt.Errorf("snappy: block longer than its header says")
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case 1:
+			if s+2 > len(src) {
+				return nil, fmt.Errorf("snappy: truncated copy")
+			}
+			length := 4 + int(tag>>2)&0x07
+			offset := int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		case 2:
+			if s+3 > len(src) {
+				return nil, fmt.Errorf("snappy: truncated copy")
+			}
+			length := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint16(src[s+1 : s+3]))
+			s += 3
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		case 3:
+			if s+5 > len(src) {
+				return nil, fmt.Errorf("snappy: truncated copy")
+			}
+			length := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint32(src[s+1 : s+5]))
+			s += 5
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, fmt.Errorf("snappy: decoded %d bytes, header says %d", len(dst), n)
+	}
+	return dst, nil
+}
+
+func snappyCopy(dst *[]byte, offset, length int) error {
+	if offset <= 0 || offset > len(*dst) {
+		return fmt.Errorf("snappy: invalid copy offset %d", offset)
+	}
+	if len(*dst)+length > cap(*dst) {
+		return fmt.Errorf("snappy: block longer than its header says")
+	}
+	start := len(*dst) - offset
+	for i := 0; i < length; i++ {
+		*dst = append(*dst, (*dst)[start+i])
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package recordbatch
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var codecNames = map[string]int{
+	"gzip":   CompressionGzip,
+	"snappy": CompressionSnappy,
+	"lz4":    CompressionLZ4,
+	"zstd":   CompressionZstd,
+}
+
+// vector is one file of testdata/vectors.txt, written by testdata/gen.sh
+// with the reference lz4, zstd and snappy implementations.
+type vector struct {
+	file   string
+	codec  int
+	size   int
+	sha256 string
+	data   []byte
+}
+
+func loadVectors(t testing.TB) []vector {
+	t.Helper()
+	f, err := os.Open("testdata/vectors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var vs []vector
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 4 {
+			t.Fatalf("vectors.txt: bad line %q", sc.Text())
+		}
+		codec, ok := codecNames[fields[1]]
+		if !ok {
+			t.Fatalf("vectors.txt: unknown codec %q", fields[1])
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", fields[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vs = append(vs, vector{fields[0], codec, size, fields[3], data})
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return vs
+}
+
+func sum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func TestDecompressReferenceVectors(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		got, err := Decompress(v.codec, v.data)
+		if err != nil {
+			t.Errorf("%s: %v", v.file, err)
+			continue
+		}
+		if len(got) != v.size || sum(got) != v.sha256 {
+			t.Errorf("%s: decoded %d bytes with sha256 %s, want %d bytes with %s", v.file, len(got), sum(got), v.size, v.sha256)
+		}
+	}
+}
+
+// roundTripInputs covers the shapes a records section comes in: empty,
+// shorter than any match, incompressible, highly repetitive, and spanning
+// several blocks of every codec.
+func roundTripInputs() map[string][]byte {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 200<<10)
+	r.Read(random)
+	var text bytes.Buffer
+	for i := 0; text.Len() < 300<<10; i++ {
+		fmt.Fprintf(&text, "key-%d=value-%d;", r.Intn(500), i%97)
+	}
+	return map[string][]byte{
+		"empty":  nil,
+		"byte":   {'k'},
+		"short":  []byte("kafka"),
+		"random": random,
+		"zeros":  make([]byte, 1<<20),
+		"repeat": bytes.Repeat([]byte("abc"), 100000),
+		"text":   text.Bytes(),
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	for name, codec := range codecNames {
+		for input, data := range roundTripInputs() {
+			compressed, err := Compress(codec, data)
+			if err != nil {
+				t.Fatalf("%s/%s: compress: %v", name, input, err)
+			}
+			got, err := Decompress(codec, compressed)
+			if err != nil {
+				t.Errorf("%s/%s: decompress: %v", name, input, err)
+				continue
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s/%s: round trip changed %d bytes into %d", name, input, len(data), len(got))
+			}
+		}
+	}
+}
+
+// TestCompressReadByReferenceTools feeds what Compress writes to the lz4 and
+// zstd command-line tools, when they are installed.
+func TestCompressReadByReferenceTools(t *testing.T) {
+	for _, tool := range []struct {
+		name  string
+		codec int
+	}{{"lz4", CompressionLZ4}, {"zstd", CompressionZstd}} {
+		if _, err := exec.LookPath(tool.name); err != nil {
+			t.Logf("%s not installed, skipping", tool.name)
+			continue
+		}
+		for input, data := range roundTripInputs() {
+			compressed, err := Compress(tool.codec, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command(tool.name, "-d", "-c")
+			cmd.Stdin = bytes.NewReader(compressed)
+			got, err := cmd.Output()
+			if err != nil {
+				t.Errorf("%s/%s: %v", tool.name, input, err)
+				continue
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s/%s: tool decoded %d bytes, want %d", tool.name, input, len(got), len(data))
+			}
+		}
+	}
+}
+
+// TestDecompressTruncated cuts every reference frame short. Each cut must
+// fail, or at most yield a strict prefix where the framing allows a stream
+// to end early (between xerial chunks).
+func TestDecompressTruncated(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		want, err := Decompress(v.codec, v.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step := max(1, len(v.data)/500)
+		for n := 1; n < len(v.data); n += step {
+			got, err := Decompress(v.codec, v.data[:n])
+			if err == nil && (len(got) >= len(want) || !bytes.HasPrefix(want, got)) {
+				t.Errorf("%s cut to %d bytes: decoded %d bytes without an error", v.file, n, len(got))
+			}
+		}
+	}
+}
+
+// TestDecompressCorrupt flips bytes across every reference frame. Frames
+// carrying a content checksum must reject every change that reaches their
+// output; the rest only have to come back without panicking or inflating
+// past what the format allows.
+func TestDecompressCorrupt(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, v := range loadVectors(t) {
+		want, err := Decompress(v.codec, v.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 300 && len(v.data) > 0; i++ {
+			corrupt := bytes.Clone(v.data)
+			corrupt[r.Intn(len(corrupt))] ^= byte(1 + r.Intn(255))
+			got, err := Decompress(v.codec, corrupt)
+			if err == nil && len(got) > 64*len(corrupt)+len(want) {
+				t.Errorf("%s: corrupt frame inflated to %d bytes", v.file, len(got))
+			}
+			if err == nil && hasContentChecksum(v.codec, corrupt) && !bytes.Equal(got, want) {
+				t.Errorf("%s: corrupt frame decoded without a checksum error", v.file)
+			}
+		}
+	}
+}
+
+// hasContentChecksum reports whether a single lz4 or zstd frame still
+// declares a content checksum.
+func hasContentChecksum(codec int, frame []byte) bool {
+	switch {
+	case codec == CompressionLZ4 && len(frame) > 4 && bytes.HasPrefix(frame, []byte{0x04, 0x22, 0x4d, 0x18}):
+		return frame[4]&0x04 != 0
+	case codec == CompressionZstd && len(frame) > 4 && bytes.HasPrefix(frame, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return frame[4]&0x04 != 0
+	}
+	return false
+}
+
+func FuzzDecompress(f *testing.F) {
+	for _, v := range loadVectors(f) {
+		if len(v.data) < 4<<10 {
+			f.Add(v.codec, v.data)
+		}
+	}
+	for name, codec := range codecNames {
+		for _, s := range []string{"", "kafka", strings.Repeat("kafka-", 50)} {
+			b, err := Compress(codec, []byte(s))
+			if err != nil {
+				f.Fatal(name, err)
+			}
+			f.Add(codec, b)
+		}
+	}
+	f.Fuzz(func(t *testing.T, codec int, data []byte) {
+		if codec == CompressionGzip {
+			return
+		}
+		got, err := Decompress(codec, data)
+		if err == nil && len(got) > 1<<20 && len(got) > 1024*len(data) {
+			t.Errorf("%d input bytes inflated to %d", len(data), len(got))
+		}
+	})
+}
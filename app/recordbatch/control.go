@@ -0,0 +1,103 @@
+package recordbatch
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+)
+
+// ControlType is the type a control record's key names.
+type ControlType int16
+
+const (
+	ControlAbort          ControlType = 0
+	ControlCommit         ControlType = 1
+	ControlLeaderChange   ControlType = 2
+	ControlSnapshotHeader ControlType = 3
+	ControlSnapshotFooter ControlType = 4
+	ControlKRaftVersion   ControlType = 5
+	ControlKRaftVoters    ControlType = 6
+)
+
+// ControlKey encodes a control record key: a version, always 0, and the type.
+func ControlKey(t ControlType) []byte {
+	key := parser.AppendInt16(nil, 0)
+	return parser.AppendInt16(key, int16(t))
+}
+
+// ParseControlKey returns the type a control record's key names.
+func ParseControlKey(key []byte) (ControlType, error) {
+	if len(key) < 4 {
+		return 0, fmt.Errorf("control record key of %d bytes is too short", len(key))
+	}
+	if v := int16(binary.BigEndian.Uint16(key)); v != 0 {
+		return 0, fmt.Errorf("unsupported control record key version %d", v)
+	}
+	return ControlType(binary.BigEndian.Uint16(key[2:])), nil
+}
+
+// EndTxnMarker is the control record that ends a transaction: a commit or an
+// abort, with the epoch of the coordinator that wrote it so a zombie
+// coordinator's late markers can be fenced.
+func EndTxnMarker(commit bool, coordinatorEpoch int32) Record {
+	t := ControlAbort
+	if commit {
+		t = ControlCommit
+	}
+	value := parser.AppendInt16(nil, 0)
+	value = parser.AppendInt32(value, coordinatorEpoch)
+	return Record{Key: ControlKey(t), Value: value}
+}
+
+// LeaderChange is the control record a new Raft leader writes first in its
+// epoch: the LeaderChangeMessage naming it, the voters, and the voters that
+// elected it. The message is flexible, so its arrays are compact and every
+// struct ends in tagged fields.
+func LeaderChange(leaderID int32, voters, grantingVoters []int32) Record {
+	value := parser.AppendInt16(nil, 0)
+	value = parser.AppendInt32(value, leaderID)
+	for _, ids := range [][]int32{voters, grantingVoters} {
+		value = parser.AppendUVarInt(value, uint32(len(ids)+1))
+		for _, id := range ids {
+			value = parser.AppendInt32(value, id)
+			value = parser.AppendUVarInt(value, 0)
+		}
+	}
+	value = parser.AppendUVarInt(value, 0)
+	return Record{Key: ControlKey(ControlLeaderChange), Value: value}
+}
+
+// NewEndTxnMarkerBatch builds the batch a transaction coordinator appends to
+// each partition of a finished transaction: one marker record, flagged as a
+// transactional control batch of the producer's id and epoch.
+func NewEndTxnMarkerBatch(producerID int64, producerEpoch int16, commit bool, coordinatorEpoch int32, ts int64) Batch {
+	return Batch{
+		Header: Header{
+			Attributes:     attrControl | attrTransactional,
+			FirstTimestamp: ts,
+			MaxTimestamp:   ts,
+			ProducerID:     producerID,
+			ProducerEpoch:  producerEpoch,
+			BaseSequence:   -1,
+		},
+		Records: []Record{EndTxnMarker(commit, coordinatorEpoch)},
+	}
+}
+
+// NewLeaderChangeBatch builds the control batch opening a Raft leader's
+// epoch in the metadata log.
+func NewLeaderChangeBatch(leaderEpoch, leaderID int32, voters, grantingVoters []int32, ts int64) Batch {
+	return Batch{
+		Header: Header{
+			LeaderEpoch:    leaderEpoch,
+			Attributes:     attrControl,
+			FirstTimestamp: ts,
+			MaxTimestamp:   ts,
+			ProducerID:     -1,
+			ProducerEpoch:  -1,
+			BaseSequence:   -1,
+		},
+		Records: []Record{LeaderChange(leaderID, voters, grantingVoters)},
+	}
+}
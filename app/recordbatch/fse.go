@@ -0,0 +1,481 @@
+package recordbatch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Finite State Entropy and Huffman coding as zstd uses them (RFC 8878,
+// sections 4.1 and 4.2).
+
+// reverseBits reads a zstd backward bitstream: bits are consumed from the
+// highest one below the end-of-stream marker down towards bit 0 of the first
+// byte. Reading past the start yields zeros and leaves pos negative, which
+// callers check for once they are done.
+type reverseBits struct {
+	b   []byte
+	pos int
+}
+
+func newReverseBits(b []byte) (*reverseBits, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, fmt.Errorf("zstd: bitstream without end marker")
+	}
+	return &reverseBits{b: b, pos: (len(b)-1)*8 + bits.Len8(b[len(b)-1]) - 1}, nil
+}
+
+func (r *reverseBits) read(n uint8) uint64 {
+	var v uint64
+	for n > 0 {
+		if r.pos <= 0 {
+			r.pos -= int(n)
+			return v << n
+		}
+		top := uint8((r.pos - 1) & 7)
+		take := min(n, top+1)
+		v = v<<take | uint64(r.b[(r.pos-1)>>3]>>(top+1-take))&(1<<take-1)
+		r.pos -= int(take)
+		n -= take
+	}
+	return v
+}
+
+func (r *reverseBits) peek(n uint8) uint64 {
+	pos := r.pos
+	v := r.read(n)
+	r.pos = pos
+	return v
+}
+
+// forwardBits reads the little-endian bitstream of an FSE table description.
+type forwardBits struct {
+	b   []byte
+	pos int
+}
+
+func (r *forwardBits) peek(n int) int {
+	var v int
+	for i := 0; i < n; i++ {
+		if p := r.pos + i; p>>3 < len(r.b) && r.b[p>>3]>>(p&7)&1 != 0 {
+			v |= 1 << i
+		}
+	}
+	return v
+}
+
+func (r *forwardBits) read(n int) int {
+	v := r.peek(n)
+	r.pos += n
+	return v
+}
+
+// bitWriter builds a zstd backward bitstream: bits written last are read
+// first, and close adds the end-of-stream marker.
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	nbits uint8
+}
+
+func (w *bitWriter) write(v uint64, n uint8) {
+	w.acc |= (v & (1<<n - 1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) close() []byte {
+	w.write(1, 1)
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.acc))
+	}
+	return w.out
+}
+
+// readFSEDistribution decodes an FSE table description: the accuracy log and
+// the normalized count of each symbol, -1 marking a "less than one"
+// probability. It returns how many bytes the description took.
+func readFSEDistribution(src []byte, maxSymbol int, maxLog uint8) ([]int16, uint8, int, error) {
+	if len(src) == 0 {
+		return nil, 0, 0, fmt.Errorf("zstd: missing FSE table description")
+	}
+	r := forwardBits{b: src}
+	log := uint8(r.read(4) + 5)
+	if log > maxLog {
+		return nil, 0, 0, fmt.Errorf("zstd: FSE accuracy log %d exceeds %d", log, maxLog)
+	}
+
+	norm := make([]int16, maxSymbol+1)
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := int(log) + 1
+	symbol := 0
+	previousZero := false
+	for remaining > 1 {
+		if previousZero {
+			run := symbol
+			for {
+				flag := r.read(2)
+				run += flag
+				if flag != 3 {
+					break
+				}
+			}
+			if run > maxSymbol {
+				return nil, 0, 0, fmt.Errorf("zstd: FSE zero run past symbol %d", maxSymbol)
+			}
+			symbol = run
+		}
+		if symbol > maxSymbol {
+			return nil, 0, 0, fmt.Errorf("zstd: FSE distribution past symbol %d", maxSymbol)
+		}
+
+		most := 2*threshold - 1 - remaining
+		var count int
+		if v := r.peek(nbBits - 1); v < most {
+			count = v
+			r.pos += nbBits - 1
+		} else {
+			count = r.read(nbBits)
+			if count >= threshold {
+				count -= most
+			}
+		}
+		count--
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		norm[symbol] = int16(count)
+		symbol++
+		previousZero = count == 0
+		if remaining < 1 {
+			break
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 {
+		return nil, 0, 0, fmt.Errorf("zstd: FSE probabilities don't add up")
+	}
+	if r.pos > len(src)*8 {
+		return nil, 0, 0, fmt.Errorf("zstd: truncated FSE table description")
+	}
+	return norm, log, (r.pos + 7) / 8, nil
+}
+
+// spreadFSE lays the symbols of a distribution out over the table's states,
+// the step shared by the decoding and encoding tables.
+func spreadFSE(norm []int16, log uint8) ([]uint8, error) {
+	size := 1 << log
+	symbols := make([]uint8, size)
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			symbols[high] = uint8(s)
+			high--
+		}
+	}
+	step, mask, pos := size>>1+size>>3+3, size-1, 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			symbols[pos] = uint8(s)
+			for pos = (pos + step) & mask; pos > high; pos = (pos + step) & mask {
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, fmt.Errorf("zstd: FSE distribution doesn't fill its table")
+	}
+	return symbols, nil
+}
+
+type fseEntry struct {
+	symbol uint8
+	nbBits uint8
+	base   uint16
+}
+
+// fseTable is an FSE decoding table, indexed by state.
+type fseTable struct {
+	log     uint8
+	entries []fseEntry
+}
+
+func newFSETable(norm []int16, log uint8) (*fseTable, error) {
+	symbols, err := spreadFSE(norm, log)
+	if err != nil {
+		return nil, err
+	}
+	size := 1 << log
+	next := make([]int, len(norm))
+	for s, c := range norm {
+		next[s] = max(int(c), 1)
+	}
+	t := &fseTable{log: log, entries: make([]fseEntry, size)}
+	for u, s := range symbols {
+		state := next[s]
+		next[s]++
+		nb := log - uint8(bits.Len(uint(state))-1)
+		t.entries[u] = fseEntry{symbol: s, nbBits: nb, base: uint16(state<<nb - size)}
+	}
+	return t, nil
+}
+
+func mustFSETable(norm []int16, log uint8) *fseTable {
+	t, err := newFSETable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (t *fseTable) init(r *reverseBits) int {
+	return int(r.read(t.log))
+}
+
+func (t *fseTable) update(state int, r *reverseBits) int {
+	e := t.entries[state]
+	return int(e.base) + int(r.read(e.nbBits))
+}
+
+// fseEncoder is the encoding side of an FSE table.
+type fseEncoder struct {
+	log     uint8
+	states  []uint16
+	symbols []fseSymbolTransform
+}
+
+type fseSymbolTransform struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+func newFSEEncoder(norm []int16, log uint8) *fseEncoder {
+	symbols, err := spreadFSE(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	size := 1 << log
+	cumul := make([]int, len(norm)+1)
+	for s, c := range norm {
+		cumul[s+1] = cumul[s] + max(int(c), 1)
+		if c == 0 {
+			cumul[s+1] = cumul[s]
+		}
+	}
+	e := &fseEncoder{log: log, states: make([]uint16, size), symbols: make([]fseSymbolTransform, len(norm))}
+	for u, s := range symbols {
+		e.states[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+	total := 0
+	for s, c := range norm {
+		switch {
+		case c == 0:
+			e.symbols[s].deltaNbBits = uint32(int(log+1)<<16 - size)
+		case c == -1 || c == 1:
+			e.symbols[s] = fseSymbolTransform{deltaNbBits: uint32(int(log)<<16 - size), deltaFindState: int32(total - 1)}
+			total++
+		default:
+			maxBitsOut := int(log) - (bits.Len(uint(c-1)) - 1)
+			e.symbols[s] = fseSymbolTransform{deltaNbBits: uint32(maxBitsOut<<16 - int(c)<<maxBitsOut), deltaFindState: int32(total - int(c))}
+			total += int(c)
+		}
+	}
+	return e
+}
+
+func (e *fseEncoder) init(symbol uint8) uint32 {
+	tt := e.symbols[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - tt.deltaNbBits
+	return uint32(e.states[int32(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (e *fseEncoder) encode(w *bitWriter, state uint32, symbol uint8) uint32 {
+	tt := e.symbols[symbol]
+	nbBitsOut := uint8((state + tt.deltaNbBits) >> 16)
+	w.write(uint64(state), nbBitsOut)
+	return uint32(e.states[int32(state>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (e *fseEncoder) flush(w *bitWriter, state uint32) {
+	w.write(uint64(state), e.log)
+}
+
+const huffmanMaxBits = 11
+
+type huffmanEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+// huffmanTable decodes literals by peeking maxBits bits at a time.
+type huffmanTable struct {
+	maxBits uint8
+	entries []huffmanEntry
+}
+
+// readHuffmanTable decodes a Huffman tree description and returns the table
+// with how many bytes the description took.
+func readHuffmanTable(src []byte) (*huffmanTable, int, error) {
+	if len(src) == 0 {
+		return nil, 0, fmt.Errorf("zstd: missing Huffman tree description")
+	}
+	var weights []uint8
+	var n int
+	if header := int(src[0]); header < 128 {
+		n = 1 + header
+		if n > len(src) {
+			return nil, 0, fmt.Errorf("zstd: truncated Huffman weights")
+		}
+		var err error
+		if weights, err = decodeHuffmanWeights(src[1:n]); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		count := header - 127
+		n = 1 + (count+1)/2
+		if n > len(src) {
+			return nil, 0, fmt.Errorf("zstd: truncated Huffman weights")
+		}
+		for i := 0; i < count; i++ {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				b >>= 4
+			}
+			weights = append(weights, b&0x0f)
+		}
+	}
+
+	// The last symbol's weight is implied: it tops the total up to the next
+	// power of two.
+	total := 0
+	for _, w := range weights {
+		if w > huffmanMaxBits {
+			return nil, 0, fmt.Errorf("zstd: Huffman weight %d too large", w)
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 || len(weights) > 255 {
+		return nil, 0, fmt.Errorf("zstd: invalid Huffman weights")
+	}
+	maxBits := uint8(bits.Len(uint(total)))
+	rest := 1<<maxBits - total
+	if maxBits > huffmanMaxBits || rest&(rest-1) != 0 {
+		return nil, 0, fmt.Errorf("zstd: invalid Huffman weights")
+	}
+	weights = append(weights, uint8(bits.Len(uint(rest))))
+
+	var start [huffmanMaxBits + 2]int
+	for _, w := range weights {
+		if w > 0 {
+			start[w] += 1 << (w - 1)
+		}
+	}
+	for w, next := 1, 0; w <= int(maxBits); w++ {
+		start[w], next = next, next+start[w]
+	}
+	t := &huffmanTable{maxBits: maxBits, entries: make([]huffmanEntry, 1<<maxBits)}
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		e := huffmanEntry{symbol: uint8(s), nbBits: maxBits + 1 - w}
+		for i := 0; i < 1<<(w-1); i++ {
+			t.entries[start[w]+i] = e
+		}
+		start[w] += 1 << (w - 1)
+	}
+	return t, n, nil
+}
+
+// decodeHuffmanWeights decodes FSE-compressed Huffman weights, which are
+// interleaved over two states sharing one bitstream.
+func decodeHuffmanWeights(src []byte) ([]uint8, error) {
+	norm, log, n, err := readFSEDistribution(src, huffmanMaxBits+1, 6)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newFSETable(norm, log)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newReverseBits(src[n:])
+	if err != nil {
+		return nil, err
+	}
+	s1, s2 := t.init(r), t.init(r)
+	var weights []uint8
+	for len(weights) < 255 {
+		weights = append(weights, t.entries[s1].symbol)
+		if s1 = t.update(s1, r); r.pos < 0 {
+			return append(weights, t.entries[s2].symbol), nil
+		}
+		weights = append(weights, t.entries[s2].symbol)
+		if s2 = t.update(s2, r); r.pos < 0 {
+			return append(weights, t.entries[s1].symbol), nil
+		}
+	}
+	return nil, fmt.Errorf("zstd: too many Huffman weights")
+}
+
+// decode inflates regenerated literal bytes from one or four streams.
+func (t *huffmanTable) decode(src []byte, regenerated, streams int) ([]byte, error) {
+	if streams == 1 {
+		return t.decodeStream(make([]byte, 0, regenerated), src, regenerated)
+	}
+	if len(src) < 6 {
+		return nil, fmt.Errorf("zstd: truncated Huffman jump table")
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(src[0:])),
+		int(binary.LittleEndian.Uint16(src[2:])),
+		int(binary.LittleEndian.Uint16(src[4:])),
+	}
+	sizes[3] = len(src) - 6 - sizes[0] - sizes[1] - sizes[2]
+	segment := (regenerated + 3) / 4
+	if sizes[3] < 0 || 3*segment > regenerated {
+		return nil, fmt.Errorf("zstd: invalid Huffman jump table")
+	}
+	out := make([]byte, 0, regenerated)
+	src = src[6:]
+	for i, size := range sizes {
+		count := segment
+		if i == 3 {
+			count = regenerated - 3*segment
+		}
+		var err error
+		if out, err = t.decodeStream(out, src[:size], count); err != nil {
+			return nil, err
+		}
+		src = src[size:]
+	}
+	return out, nil
+}
+
+func (t *huffmanTable) decodeStream(dst, src []byte, count int) ([]byte, error) {
+	r, err := newReverseBits(src)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		e := t.entries[r.peek(t.maxBits)]
+		dst = append(dst, e.symbol)
+		r.pos -= int(e.nbBits)
+	}
+	if r.pos != 0 {
+		return nil, fmt.Errorf("zstd: Huffman stream size mismatch")
+	}
+	return dst, nil
+}
@@ -0,0 +1,263 @@
+package recordbatch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Kafka wraps lz4 batches in the standard LZ4 frame format (KIP-57).
+const (
+	lz4Magic = 0x184D2204
+
+	// lz4BlockSize is the largest block encodeLZ4Frame writes, the 64KB
+	// maximum the Java client uses by default.
+	lz4BlockSize = 64 << 10
+)
+
+// decodeLZ4Frame inflates one or more concatenated LZ4 frames, checking the
+// block and content checksums and content size when a frame carries them.
+// The header checksum isn't verified: Kafka's pre-KIP-57 clients computed it
+// over the magic number too.
+func decodeLZ4Frame(src []byte) ([]byte, error) {
+	var dst []byte
+	for len(src) > 0 {
+		if len(src) < 7 || binary.LittleEndian.Uint32(src) != lz4Magic {
+			return nil, fmt.Errorf("lz4: missing frame header")
+		}
+		flg, bd := src[4], src[5]
+		if flg>>6 != 1 {
+			return nil, fmt.Errorf("lz4: unsupported frame version %d", flg>>6)
+		}
+		if bd>>4&7 < 4 {
+			return nil, fmt.Errorf("lz4: invalid block maximum size")
+		}
+		maxBlock := lz4BlockSize << (2 * (bd>>4&7 - 4))
+		blockChecksum := flg&0x10 != 0
+		contentChecksum := flg&0x04 != 0
+		pos := 6
+		contentSize := int64(-1)
+		if flg&0x08 != 0 {
+			if pos+8 > len(src) {
+				return nil, fmt.Errorf("lz4: truncated frame header")
+			}
+			contentSize = int64(binary.LittleEndian.Uint64(src[pos:]) &^ (1 << 63))
+			pos += 8
+		}
+		if flg&0x01 != 0 {
+			pos += 4
+		}
+		pos++ // header checksum
+		frameStart := len(dst)
+		for {
+			if pos+4 > len(src) {
+				return nil, fmt.Errorf("lz4: truncated block size")
+			}
+			size := binary.LittleEndian.Uint32(src[pos:])
+			pos += 4
+			if size == 0 {
+				break
+			}
+			n := int(size &^ (1 << 31))
+			if n > maxBlock {
+				return nil, fmt.Errorf("lz4: block exceeds its maximum size")
+			}
+			if pos+n > len(src) {
+				return nil, fmt.Errorf("lz4: truncated block")
+			}
+			block := src[pos : pos+n]
+			pos += n
+			if blockChecksum {
+				if pos+4 > len(src) {
+					return nil, fmt.Errorf("lz4: truncated block checksum")
+				}
+				if binary.LittleEndian.Uint32(src[pos:]) != xxh32(block, 0) {
+					return nil, fmt.Errorf("lz4: block checksum mismatch")
+				}
+				pos += 4
+			}
+			if size&(1<<31) != 0 {
+				dst = append(dst, block...)
+				continue
+			}
+			var err error
+			if dst, err = decodeLZ4Block(dst, block, len(dst)+maxBlock); err != nil {
+				return nil, err
+			}
+		}
+		if contentChecksum {
+			if pos+4 > len(src) {
+				return nil, fmt.Errorf("lz4: truncated content checksum")
+			}
+			if binary.LittleEndian.Uint32(src[pos:]) != xxh32(dst[frameStart:], 0) {
+				return nil, fmt.Errorf("lz4: content checksum mismatch")
+			}
+			pos += 4
+		}
+		if contentSize >= 0 && int64(len(dst)-frameStart) != contentSize {
+			return nil, fmt.Errorf("lz4: decoded %d bytes, header says %d", len(dst)-frameStart, contentSize)
+		}
+		src = src[pos:]
+	}
+	return dst, nil
+}
+
+// decodeLZ4Block appends the inflated block to dst, failing once dst would
+// grow past limit. Matches may reach back into earlier blocks of the frame,
+// which dst still holds.
+func decodeLZ4Block(dst, src []byte, limit int) ([]byte, error) {
+	for s := 0; s < len(src); {
+		token := src[s]
+		s++
+		lit, n, err := lz4Length(src[s:], int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+		s += n
+		if s+lit > len(src) {
+			return nil, fmt.Errorf("lz4: truncated literal")
+		}
+		if len(dst)+lit > limit {
+			return nil, fmt.Errorf("lz4: block exceeds its maximum size")
+		}
+		dst = append(dst, src[s:s+lit]...)
+		s += lit
+		if s == len(src) {
+			break
+		}
+
+		if s+2 > len(src) {
+			return nil, fmt.Errorf("lz4: truncated match offset")
+		}
+		offset := int(binary.LittleEndian.Uint16(src[s:]))
+		s += 2
+		length, n, err := lz4Length(src[s:], int(token&0x0f))
+		if err != nil {
+			return nil, err
+		}
+		s += n
+		if offset == 0 || offset > len(dst) {
+			return nil, fmt.Errorf("lz4: invalid match offset %d", offset)
+		}
+		if len(dst)+length+4 > limit {
+			return nil, fmt.Errorf("lz4: block exceeds its maximum size")
+		}
+		start := len(dst) - offset
+		for i := 0; i < length+4; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	return dst, nil
+}
+
+// lz4Length extends a token nibble of 15 with the 255-run bytes that follow
+// it, returning the length and how many bytes it took.
+func lz4Length(src []byte, nibble int) (int, int, error) {
+	if nibble != 15 {
+		return nibble, 0, nil
+	}
+	for i, b := range src {
+		nibble += int(b)
+		if b != 255 {
+			return nibble, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("lz4: truncated length")
+}
+
+// encodeLZ4Frame writes a frame of independent 64KB blocks without block or
+// content checksums, storing any block that doesn't shrink.
+func encodeLZ4Frame(src []byte) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, lz4Magic)
+	descriptor := []byte{0x60, 0x40} // version 1, independent blocks; 64KB max block
+	out = append(out, descriptor...)
+	out = append(out, byte(xxh32(descriptor, 0)>>8))
+	for off := 0; off < len(src); off += lz4BlockSize {
+		raw := src[off:min(off+lz4BlockSize, len(src))]
+		if block := encodeLZ4Block(raw); len(block) < len(raw) {
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(block)))
+			out = append(out, block...)
+		} else {
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(raw))|1<<31)
+			out = append(out, raw...)
+		}
+	}
+	return binary.LittleEndian.AppendUint32(out, 0)
+}
+
+// encodeLZ4Block compresses one block. The format wants the last five bytes
+// as literals and no match starting in the last twelve.
+func encodeLZ4Block(src []byte) []byte {
+	var dst []byte
+	anchor := 0
+	greedyMatches(src, 1<<16-1, len(src)-12, len(src)-5, func(start, offset, length int) {
+		dst = appendLZ4Sequence(dst, src[anchor:start], length-4)
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+		dst = appendLZ4Length(dst, length-4)
+		anchor = start + length
+	})
+	return appendLZ4Sequence(dst, src[anchor:], 0)
+}
+
+// appendLZ4Sequence writes a sequence's token and literals; the caller adds
+// the match offset and length extension, if any.
+func appendLZ4Sequence(dst, lit []byte, matchLength int) []byte {
+	dst = append(dst, byte(min(len(lit), 15)<<4|min(matchLength, 15)))
+	dst = appendLZ4Length(dst, len(lit))
+	return append(dst, lit...)
+}
+
+func appendLZ4Length(dst []byte, n int) []byte {
+	if n < 15 {
+		return dst
+	}
+	for n -= 15; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+)
+
+// xxh32 is the XXH32 hash LZ4 frames take their checksums from.
+func xxh32(b []byte, seed uint32) uint32 {
+	n := len(b)
+	var h uint32
+	if n >= 16 {
+		v1, v2, v3, v4 := seed+xxhPrime1+xxhPrime2, seed+xxhPrime2, seed, seed-xxhPrime1
+		for ; len(b) >= 16; b = b[16:] {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint32(b[0:]))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint32(b[12:]))
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxhPrime5
+	}
+	h += uint32(n)
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b) * xxhPrime3
+		h = bits.RotateLeft32(h, 17) * xxhPrime4
+	}
+	for _, c := range b {
+		h += uint32(c) * xxhPrime5
+		h = bits.RotateLeft32(h, 11) * xxhPrime1
+	}
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxhRound(acc, in uint32) uint32 {
+	return bits.RotateLeft32(acc+in*xxhPrime2, 13) * xxhPrime1
+}
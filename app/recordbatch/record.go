@@ -0,0 +1,118 @@
+package recordbatch
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+)
+
+// Record is one record of a batch. Its offset and timestamp are deltas from
+// the batch's base offset and first timestamp. A nil Key or Value is null.
+type Record struct {
+	Attributes     int8
+	TimestampDelta int64
+	OffsetDelta    int32
+	Key            []byte
+	Value          []byte
+	Headers        []RecordHeader
+}
+
+// RecordHeader is one record header. A nil Value is null.
+type RecordHeader struct {
+	Key   string
+	Value []byte
+}
+
+// DecodeRecords decodes count records from an uncompressed records section.
+// It fails if a record is truncated or the section holds bytes past the last
+// record.
+func DecodeRecords(data []byte, count int) ([]Record, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("negative record count %d", count)
+	}
+	records := make([]Record, 0, min(count, len(data)))
+	br := parser.BytesReader{B: data}
+	for i := 0; i < count; i++ {
+		recLen := int(parser.ReadVarInt(&br))
+		if br.Short || recLen <= 0 || !br.CanRead(recLen) {
+			return records, fmt.Errorf("record %d of %d is missing or truncated", i, count)
+		}
+		rec, err := decodeRecord(br.B[br.Off : br.Off+recLen])
+		if err != nil {
+			return records, fmt.Errorf("record %d: %w", i, err)
+		}
+		records = append(records, rec)
+		br.Off += recLen
+	}
+	if br.Off != len(data) {
+		return records, fmt.Errorf("%d bytes follow the last of %d records", len(data)-br.Off, count)
+	}
+	return records, nil
+}
+
+func decodeRecord(data []byte) (Record, error) {
+	br := parser.BytesReader{B: data}
+	rec := Record{
+		Attributes:     parser.ReadInt8(&br),
+		TimestampDelta: parser.ReadVarInt(&br),
+		OffsetDelta:    int32(parser.ReadVarInt(&br)),
+	}
+	rec.Key = readVarBytes(&br)
+	rec.Value = readVarBytes(&br)
+
+	nHeaders := int(parser.ReadVarInt(&br))
+	if nHeaders < 0 || nHeaders > len(data) {
+		return rec, fmt.Errorf("invalid header count %d", nHeaders)
+	}
+	for i := 0; i < nHeaders; i++ {
+		key := readVarBytes(&br)
+		rec.Headers = append(rec.Headers, RecordHeader{Key: string(key), Value: readVarBytes(&br)})
+	}
+	if br.Short {
+		return rec, fmt.Errorf("truncated record")
+	}
+	if br.Off != len(data) {
+		return rec, fmt.Errorf("%d bytes follow the record's headers", len(data)-br.Off)
+	}
+	return rec, nil
+}
+
+// readVarBytes reads a varint length-prefixed byte string, where -1 is null.
+func readVarBytes(br *parser.BytesReader) []byte {
+	n := int(parser.ReadVarInt(br))
+	if n < 0 {
+		return nil
+	}
+	if !br.CanRead(n) {
+		br.Short = true
+		return nil
+	}
+	b := br.B[br.Off : br.Off+n : br.Off+n]
+	br.Off += n
+	return b
+}
+
+// AppendRecord appends a record with its varint length prefix.
+func AppendRecord(b []byte, rec Record) []byte {
+	body := parser.AppendInt8(nil, rec.Attributes)
+	body = parser.AppendVarInt(body, rec.TimestampDelta)
+	body = parser.AppendVarInt(body, int64(rec.OffsetDelta))
+	body = appendVarBytes(body, rec.Key)
+	body = appendVarBytes(body, rec.Value)
+	body = parser.AppendVarInt(body, int64(len(rec.Headers)))
+	for _, h := range rec.Headers {
+		body = appendVarBytes(body, []byte(h.Key))
+		body = appendVarBytes(body, h.Value)
+	}
+
+	b = parser.AppendVarInt(b, int64(len(body)))
+	return append(b, body...)
+}
+
+func appendVarBytes(b, v []byte) []byte {
+	if v == nil {
+		return parser.AppendVarInt(b, -1)
+	}
+	b = parser.AppendVarInt(b, int64(len(v)))
+	return append(b, v...)
+}
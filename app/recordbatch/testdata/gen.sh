@@ -0,0 +1,92 @@
+#!/bin/sh
+# Regenerates the compressed reference vectors with the reference tools:
+# the lz4 and zstd command-line programs and the github.com/golang/snappy
+# package (Kafka's snappy framing is added around its blocks). vectors.txt
+# lists each file with its codec and the size and SHA-256 of its content.
+set -eu
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+python3 - "$tmp" <<'PY'
+import random, sys
+out = sys.argv[1]
+r = random.Random(42)
+events = ["click", "view", "purchase", "refund", "login", "logout"]
+with open(out + "/records", "w") as f:
+    for i in range(1500):
+        f.write('{"id":%d,"user":"user-%04d","event":"%s","amount":%d.%02d,"ts":%d}\n'
+                % (i, r.randrange(300), r.choice(events), r.randrange(1000), r.randrange(100), 1700000000000 + i * r.randrange(1, 50)))
+with open(out + "/random", "wb") as f:
+    f.write(random.Random(7).randbytes(12000))
+with open(out + "/repeat", "wb") as f:
+    f.write(b"kafka-" * 50000)
+open(out + "/empty", "wb").close()
+PY
+
+rm -f vectors.txt *.lz4 *.zst *.snappy
+vector() { # file codec content
+	echo "$1 $2 $(wc -c <"$3" | tr -d ' ') $(sha256sum "$3" | cut -d' ' -f1)" >>vectors.txt
+}
+
+lz4q() { lz4 -q -f "$@"; }
+lz4q -B4 "$tmp/records" records.b4.lz4 && vector records.b4.lz4 lz4 "$tmp/records"
+lz4q -B4 -BD "$tmp/records" records.b4-linked.lz4 && vector records.b4-linked.lz4 lz4 "$tmp/records"
+lz4q -B4 -BX --content-size --no-frame-crc "$tmp/records" records.b4-blockcrc.lz4 && vector records.b4-blockcrc.lz4 lz4 "$tmp/records"
+lz4q -9 -B5 "$tmp/records" records.hc.lz4 && vector records.hc.lz4 lz4 "$tmp/records"
+lz4q -B4 "$tmp/random" random.lz4 && vector random.lz4 lz4 "$tmp/random"
+lz4q -B4 -BD "$tmp/repeat" repeat.lz4 && vector repeat.lz4 lz4 "$tmp/repeat"
+lz4q "$tmp/empty" empty.lz4 && vector empty.lz4 lz4 "$tmp/empty"
+
+zstdq() { zstd -q -f "$@"; }
+zstdq -1 "$tmp/records" -o records.1.zst && vector records.1.zst zstd "$tmp/records"
+zstdq -19 --no-check "$tmp/records" -o records.19.zst && vector records.19.zst zstd "$tmp/records"
+zstdq --ultra -22 "$tmp/records" -o records.22.zst && vector records.22.zst zstd "$tmp/records"
+zstd -q -3 <"$tmp/records" >records.stream.zst && vector records.stream.zst zstd "$tmp/records"
+zstdq -3 "$tmp/random" -o random.zst && vector random.zst zstd "$tmp/random"
+zstdq -19 "$tmp/repeat" -o repeat.zst && vector repeat.zst zstd "$tmp/repeat"
+zstdq "$tmp/empty" -o empty.zst && vector empty.zst zstd "$tmp/empty"
+cat records.1.zst repeat.zst >multi.zst && cat "$tmp/records" "$tmp/repeat" >"$tmp/multi" && vector multi.zst zstd "$tmp/multi"
+
+mkdir "$tmp/snappy"
+cat >"$tmp/snappy/main.go" <<'GO'
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"os"
+
+	"github.com/golang/snappy"
+)
+
+// Writes each input in the xerial framing of the Java client's
+// SnappyOutputStream (32KB chunks), or as one raw block with -raw.
+func main() {
+	raw := flag.Bool("raw", false, "write raw snappy blocks")
+	flag.Parse()
+	for _, path := range flag.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+		var out []byte
+		if *raw {
+			out = snappy.Encode(nil, src)
+		} else {
+			out = append([]byte{0x82, 'S', 'N', 'A', 'P', 'P', 'Y', 0}, 0, 0, 0, 1, 0, 0, 0, 1)
+			for off := 0; off < len(src); off += 32 << 10 {
+				block := snappy.Encode(nil, src[off:min(off+32<<10, len(src))])
+				out = binary.BigEndian.AppendUint32(out, uint32(len(block)))
+				out = append(out, block...)
+			}
+		}
+		os.Stdout.Write(out)
+	}
+}
+GO
+(cd "$tmp/snappy" && go mod init snappygen >/dev/null 2>&1 && go get github.com/golang/snappy@v1.0.0 >/dev/null 2>&1 && go build -o gen .)
+for name in records random repeat empty; do
+	"$tmp/snappy/gen" "$tmp/$name" >$name.snappy && vector $name.snappy snappy "$tmp/$name"
+done
+"$tmp/snappy/gen" -raw "$tmp/records" >records.raw.snappy && vector records.raw.snappy snappy "$tmp/records"
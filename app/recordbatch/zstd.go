@@ -0,0 +1,580 @@
+package recordbatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// zstd frames as defined by RFC 8878. Decoding covers the whole format but
+// dictionaries; encoding is a single greedy pass with raw literals and the
+// predefined sequence tables, cheap to produce and readable by any decoder.
+
+const (
+	zstdMagic = 0xFD2FB528
+
+	// zstdBlockSize is the largest block content the format allows.
+	zstdBlockSize = 128 << 10
+)
+
+// Predefined sequence code distributions (RFC 8878, 3.1.1.3.2.2).
+var (
+	llDefaultNorm = []int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}
+	mlDefaultNorm = []int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}
+	ofDefaultNorm = []int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+
+	llDefaultTable = mustFSETable(llDefaultNorm, 6)
+	mlDefaultTable = mustFSETable(mlDefaultNorm, 6)
+	ofDefaultTable = mustFSETable(ofDefaultNorm, 5)
+
+	llDefaultEncoder = newFSEEncoder(llDefaultNorm, 6)
+	mlDefaultEncoder = newFSEEncoder(mlDefaultNorm, 6)
+	ofDefaultEncoder = newFSEEncoder(ofDefaultNorm, 5)
+)
+
+// Literal and match length codes: the baseline each code stands for and how
+// many extra bits follow it.
+var (
+	llBase = [36]uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	llBits = [36]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	mlBase = [53]uint32{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	mlBits = [53]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// zstdDecoder carries the state blocks of one frame share: repeat offsets
+// and the tables later blocks may reuse.
+type zstdDecoder struct {
+	rep        [3]int
+	huffman    *huffmanTable
+	ll, of, ml *fseTable
+}
+
+func decodeZstd(src []byte) ([]byte, error) {
+	var dst []byte
+	for len(src) > 0 {
+		if len(src) < 4 {
+			return nil, fmt.Errorf("zstd: truncated frame")
+		}
+		magic := binary.LittleEndian.Uint32(src)
+		if magic&^0x0f == 0x184D2A50 {
+			if len(src) < 8 || uint64(len(src)-8) < uint64(binary.LittleEndian.Uint32(src[4:])) {
+				return nil, fmt.Errorf("zstd: truncated skippable frame")
+			}
+			src = src[8+int(binary.LittleEndian.Uint32(src[4:])):]
+			continue
+		}
+		if magic != zstdMagic {
+			return nil, fmt.Errorf("zstd: bad frame magic %#x", magic)
+		}
+		var err error
+		if dst, src, err = decodeZstdFrame(dst, src[4:]); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// decodeZstdFrame appends the content of the frame at the start of src to
+// dst and returns what follows the frame, checking the content size and
+// checksum when the frame carries them.
+func decodeZstdFrame(dst, src []byte) ([]byte, []byte, error) {
+	if len(src) < 1 {
+		return nil, nil, fmt.Errorf("zstd: truncated frame header")
+	}
+	descriptor := src[0]
+	if descriptor&0x08 != 0 {
+		return nil, nil, fmt.Errorf("zstd: reserved frame header bit set")
+	}
+	singleSegment := descriptor&0x20 != 0
+	pos := 1
+	if !singleSegment {
+		pos++
+	}
+	dictSize := [4]int{0, 1, 2, 4}[descriptor&0x03]
+	contentSizeSize := [4]int{0, 2, 4, 8}[descriptor>>6]
+	if descriptor>>6 == 0 && singleSegment {
+		contentSizeSize = 1
+	}
+	if pos+dictSize+contentSizeSize > len(src) {
+		return nil, nil, fmt.Errorf("zstd: truncated frame header")
+	}
+	for _, b := range src[pos : pos+dictSize] {
+		if b != 0 {
+			return nil, nil, fmt.Errorf("zstd: dictionaries are not supported")
+		}
+	}
+	pos += dictSize
+	var contentSize uint64
+	for i := contentSizeSize - 1; i >= 0; i-- {
+		contentSize = contentSize<<8 | uint64(src[pos+i])
+	}
+	if contentSizeSize == 2 {
+		contentSize += 256
+	}
+	pos += contentSizeSize
+
+	frameStart := len(dst)
+	d := zstdDecoder{rep: [3]int{1, 4, 8}}
+	for last := false; !last; {
+		if pos+3 > len(src) {
+			return nil, nil, fmt.Errorf("zstd: truncated block header")
+		}
+		header := uint32(src[pos]) | uint32(src[pos+1])<<8 | uint32(src[pos+2])<<16
+		pos += 3
+		last = header&1 != 0
+		size := int(header >> 3)
+		if size > zstdBlockSize {
+			return nil, nil, fmt.Errorf("zstd: block exceeds its maximum size")
+		}
+		switch header >> 1 & 3 {
+		case 0:
+			if pos+size > len(src) {
+				return nil, nil, fmt.Errorf("zstd: truncated raw block")
+			}
+			dst = append(dst, src[pos:pos+size]...)
+			pos += size
+		case 1:
+			if pos+1 > len(src) {
+				return nil, nil, fmt.Errorf("zstd: truncated RLE block")
+			}
+			dst = append(dst, bytes.Repeat(src[pos:pos+1], size)...)
+			pos++
+		case 2:
+			if pos+size > len(src) {
+				return nil, nil, fmt.Errorf("zstd: truncated compressed block")
+			}
+			var err error
+			if dst, err = d.decodeBlock(dst, frameStart, src[pos:pos+size]); err != nil {
+				return nil, nil, err
+			}
+			pos += size
+		default:
+			return nil, nil, fmt.Errorf("zstd: reserved block type")
+		}
+	}
+	if contentSizeSize > 0 && uint64(len(dst)-frameStart) != contentSize {
+		return nil, nil, fmt.Errorf("zstd: decoded %d bytes, header says %d", len(dst)-frameStart, contentSize)
+	}
+	if descriptor&0x04 != 0 {
+		if pos+4 > len(src) {
+			return nil, nil, fmt.Errorf("zstd: truncated content checksum")
+		}
+		if binary.LittleEndian.Uint32(src[pos:]) != uint32(xxh64(dst[frameStart:])) {
+			return nil, nil, fmt.Errorf("zstd: content checksum mismatch")
+		}
+		pos += 4
+	}
+	return dst, src[pos:], nil
+}
+
+func (d *zstdDecoder) decodeBlock(dst []byte, frameStart int, block []byte) ([]byte, error) {
+	literals, pos, err := d.decodeLiterals(block)
+	if err != nil {
+		return nil, err
+	}
+	limit := len(dst) + zstdBlockSize
+
+	if pos >= len(block) {
+		return nil, fmt.Errorf("zstd: missing sequences section")
+	}
+	count := int(block[pos])
+	switch {
+	case count < 128:
+		pos++
+	case count < 255:
+		if pos+2 > len(block) {
+			return nil, fmt.Errorf("zstd: truncated sequence count")
+		}
+		count = (count-128)<<8 + int(block[pos+1])
+		pos += 2
+	default:
+		if pos+3 > len(block) {
+			return nil, fmt.Errorf("zstd: truncated sequence count")
+		}
+		count = int(binary.LittleEndian.Uint16(block[pos+1:])) + 0x7F00
+		pos += 3
+	}
+	if count == 0 {
+		return append(dst, literals...), nil
+	}
+
+	if pos >= len(block) {
+		return nil, fmt.Errorf("zstd: missing sequence compression modes")
+	}
+	modes := block[pos]
+	pos++
+	if modes&0x03 != 0 {
+		return nil, fmt.Errorf("zstd: reserved sequence mode bits set")
+	}
+	if d.ll, pos, err = readSequenceTable(d.ll, modes>>6, block, pos, llDefaultTable, 35, 9); err != nil {
+		return nil, err
+	}
+	if d.of, pos, err = readSequenceTable(d.of, modes>>4&3, block, pos, ofDefaultTable, 31, 8); err != nil {
+		return nil, err
+	}
+	if d.ml, pos, err = readSequenceTable(d.ml, modes>>2&3, block, pos, mlDefaultTable, 52, 9); err != nil {
+		return nil, err
+	}
+
+	r, err := newReverseBits(block[pos:])
+	if err != nil {
+		return nil, err
+	}
+	llState, ofState, mlState := d.ll.init(r), d.of.init(r), d.ml.init(r)
+	litPos := 0
+	for i := 0; i < count; i++ {
+		llCode := d.ll.entries[llState].symbol
+		ofCode := d.of.entries[ofState].symbol
+		mlCode := d.ml.entries[mlState].symbol
+		if llCode > 35 || mlCode > 52 || ofCode > 31 {
+			return nil, fmt.Errorf("zstd: invalid sequence code")
+		}
+		offsetValue := 1<<ofCode + r.read(ofCode)
+		matchLength := int(mlBase[mlCode]) + int(r.read(mlBits[mlCode]))
+		literalLength := int(llBase[llCode]) + int(r.read(llBits[llCode]))
+		offset := d.offset(offsetValue, literalLength)
+		if i+1 < count {
+			llState = d.ll.update(llState, r)
+			mlState = d.ml.update(mlState, r)
+			ofState = d.of.update(ofState, r)
+		}
+
+		if litPos+literalLength > len(literals) {
+			return nil, fmt.Errorf("zstd: sequence overruns its literals")
+		}
+		dst = append(dst, literals[litPos:litPos+literalLength]...)
+		litPos += literalLength
+		if offset <= 0 || offset > len(dst)-frameStart {
+			return nil, fmt.Errorf("zstd: invalid match offset %d", offset)
+		}
+		if len(dst)+matchLength > limit {
+			return nil, fmt.Errorf("zstd: block exceeds its maximum size")
+		}
+		start := len(dst) - offset
+		for j := 0; j < matchLength; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if r.pos != 0 {
+		return nil, fmt.Errorf("zstd: sequence bitstream size mismatch")
+	}
+	return append(dst, literals[litPos:]...), nil
+}
+
+// offset resolves an offset value to a match offset, keeping the three
+// repeat offsets up to date.
+func (d *zstdDecoder) offset(value uint64, literalLength int) int {
+	if value > 3 {
+		o := int(value - 3)
+		d.rep = [3]int{o, d.rep[0], d.rep[1]}
+		return o
+	}
+	idx := int(value)
+	if literalLength == 0 {
+		idx++
+	}
+	var o int
+	switch idx {
+	case 1:
+		return d.rep[0]
+	case 2:
+		o = d.rep[1]
+		d.rep[0], d.rep[1] = o, d.rep[0]
+		return o
+	case 3:
+		o = d.rep[2]
+	default:
+		o = d.rep[0] - 1
+	}
+	d.rep = [3]int{o, d.rep[0], d.rep[1]}
+	return o
+}
+
+// decodeLiterals decodes a block's literals section and returns the
+// literals with the section's size.
+func (d *zstdDecoder) decodeLiterals(block []byte) ([]byte, int, error) {
+	if len(block) == 0 {
+		return nil, 0, fmt.Errorf("zstd: missing literals section")
+	}
+	b0 := block[0]
+	litType, sizeFormat := b0&3, b0>>2&3
+	if litType < 2 {
+		var regenerated, hdr int
+		switch sizeFormat {
+		case 0, 2:
+			regenerated, hdr = int(b0>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return nil, 0, fmt.Errorf("zstd: truncated literals header")
+			}
+			regenerated, hdr = int(b0>>4)+int(block[1])<<4, 2
+		default:
+			if len(block) < 3 {
+				return nil, 0, fmt.Errorf("zstd: truncated literals header")
+			}
+			regenerated, hdr = int(b0>>4)+int(block[1])<<4+int(block[2])<<12, 3
+		}
+		if regenerated > zstdBlockSize {
+			return nil, 0, fmt.Errorf("zstd: literals exceed the block size")
+		}
+		if litType == 1 {
+			if hdr+1 > len(block) {
+				return nil, 0, fmt.Errorf("zstd: truncated RLE literals")
+			}
+			return bytes.Repeat(block[hdr:hdr+1], regenerated), hdr + 1, nil
+		}
+		if hdr+regenerated > len(block) {
+			return nil, 0, fmt.Errorf("zstd: truncated raw literals")
+		}
+		return block[hdr : hdr+regenerated], hdr + regenerated, nil
+	}
+
+	streams, hdr := 4, 3
+	if sizeFormat == 0 {
+		streams = 1
+	}
+	if sizeFormat > 1 {
+		hdr = int(sizeFormat) + 2
+	}
+	if len(block) < hdr {
+		return nil, 0, fmt.Errorf("zstd: truncated literals header")
+	}
+	var v uint64
+	for i := hdr - 1; i >= 0; i-- {
+		v = v<<8 | uint64(block[i])
+	}
+	sizeBits := uint(10 + 4*max(int(sizeFormat)-1, 0))
+	regenerated := int(v >> 4 & (1<<sizeBits - 1))
+	compressed := int(v >> (4 + sizeBits) & (1<<sizeBits - 1))
+	if regenerated > zstdBlockSize || hdr+compressed > len(block) {
+		return nil, 0, fmt.Errorf("zstd: truncated compressed literals")
+	}
+	data := block[hdr : hdr+compressed]
+	if litType == 2 {
+		t, n, err := readHuffmanTable(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huffman, data = t, data[n:]
+	} else if d.huffman == nil {
+		return nil, 0, fmt.Errorf("zstd: treeless literals without a previous tree")
+	}
+	literals, err := d.huffman.decode(data, regenerated, streams)
+	return literals, hdr + compressed, err
+}
+
+// readSequenceTable resolves one sequence code's table from its compression
+// mode: predefined, a single repeated symbol, described inline, or repeated
+// from the previous block.
+func readSequenceTable(prev *fseTable, mode byte, block []byte, pos int, predefined *fseTable, maxSymbol int, maxLog uint8) (*fseTable, int, error) {
+	switch mode {
+	case 0:
+		return predefined, pos, nil
+	case 1:
+		if pos >= len(block) || int(block[pos]) > maxSymbol {
+			return nil, 0, fmt.Errorf("zstd: invalid RLE sequence code")
+		}
+		return &fseTable{entries: []fseEntry{{symbol: block[pos]}}}, pos + 1, nil
+	case 2:
+		norm, log, n, err := readFSEDistribution(block[pos:], maxSymbol, maxLog)
+		if err != nil {
+			return nil, 0, err
+		}
+		t, err := newFSETable(norm, log)
+		return t, pos + n, err
+	default:
+		if prev == nil {
+			return nil, 0, fmt.Errorf("zstd: repeated sequence table without a previous one")
+		}
+		return prev, pos, nil
+	}
+}
+
+// encodeZstd writes src as a single-segment frame, storing any block that
+// doesn't shrink.
+func encodeZstd(src []byte) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, zstdMagic)
+	switch n := len(src); {
+	case n < 256:
+		out = append(out, 0x20, byte(n))
+	case n < 1<<16+256:
+		out = append(out, 0x60)
+		out = binary.LittleEndian.AppendUint16(out, uint16(n-256))
+	default:
+		out = append(out, 0xA0)
+		out = binary.LittleEndian.AppendUint32(out, uint32(n))
+	}
+	if len(src) == 0 {
+		return append(out, 1, 0, 0)
+	}
+	for off := 0; off < len(src); {
+		end := min(off+zstdBlockSize, len(src))
+		raw := src[off:end]
+		var last uint32
+		if end == len(src) {
+			last = 1
+		}
+		if block := encodeZstdBlock(raw); len(block) < len(raw) {
+			out = appendZstdBlockHeader(out, last|2<<1|uint32(len(block))<<3)
+			out = append(out, block...)
+		} else {
+			out = appendZstdBlockHeader(out, last|uint32(len(raw))<<3)
+			out = append(out, raw...)
+		}
+		off = end
+	}
+	return out
+}
+
+func appendZstdBlockHeader(out []byte, header uint32) []byte {
+	return append(out, byte(header), byte(header>>8), byte(header>>16))
+}
+
+type zstdSequence struct {
+	literalLength, matchLength, offset int
+}
+
+// encodeZstdBlock compresses one block: its literals stored raw, its
+// sequences coded with the predefined tables and no repeat offsets.
+func encodeZstdBlock(src []byte) []byte {
+	var literals []byte
+	var seqs []zstdSequence
+	anchor := 0
+	greedyMatches(src, zstdBlockSize, len(src)-3, len(src), func(start, offset, length int) {
+		literals = append(literals, src[anchor:start]...)
+		seqs = append(seqs, zstdSequence{literalLength: start - anchor, matchLength: length, offset: offset})
+		anchor = start + length
+	})
+	literals = append(literals, src[anchor:]...)
+
+	var out []byte
+	switch n := len(literals); {
+	case n < 32:
+		out = append(out, byte(n<<3))
+	case n < 4096:
+		out = binary.LittleEndian.AppendUint16(out, uint16(n<<4|1<<2))
+	default:
+		out = append(out, byte(n<<4|3<<2), byte(n>>4), byte(n>>12))
+	}
+	out = append(out, literals...)
+
+	switch n := len(seqs); {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7F00:
+		out = append(out, byte(n>>8+128), byte(n))
+	default:
+		out = append(out, 255)
+		out = binary.LittleEndian.AppendUint16(out, uint16(n-0x7F00))
+	}
+	if len(seqs) == 0 {
+		return out
+	}
+	out = append(out, 0) // predefined tables for all three codes
+
+	// Sequences are written last to first, so the decoder reads them in
+	// order.
+	type codes struct {
+		ll, ml, of uint8
+	}
+	code := make([]codes, len(seqs))
+	for i, s := range seqs {
+		code[i] = codes{ll: literalLengthCode(s.literalLength), ml: matchLengthCode(s.matchLength), of: uint8(bits.Len(uint(s.offset+3)) - 1)}
+	}
+	extras := func(w *bitWriter, i int) {
+		s, c := seqs[i], code[i]
+		w.write(uint64(s.literalLength)-uint64(llBase[c.ll]), llBits[c.ll])
+		w.write(uint64(s.matchLength)-uint64(mlBase[c.ml]), mlBits[c.ml])
+		w.write(uint64(s.offset+3)-1<<c.of, c.of)
+	}
+	var w bitWriter
+	n := len(seqs) - 1
+	mlState := mlDefaultEncoder.init(code[n].ml)
+	ofState := ofDefaultEncoder.init(code[n].of)
+	llState := llDefaultEncoder.init(code[n].ll)
+	extras(&w, n)
+	for i := n - 1; i >= 0; i-- {
+		ofState = ofDefaultEncoder.encode(&w, ofState, code[i].of)
+		mlState = mlDefaultEncoder.encode(&w, mlState, code[i].ml)
+		llState = llDefaultEncoder.encode(&w, llState, code[i].ll)
+		extras(&w, i)
+	}
+	mlDefaultEncoder.flush(&w, mlState)
+	ofDefaultEncoder.flush(&w, ofState)
+	llDefaultEncoder.flush(&w, llState)
+	return append(out, w.close()...)
+}
+
+func literalLengthCode(n int) uint8 {
+	if n < 16 {
+		return uint8(n)
+	}
+	c := uint8(len(llBase) - 1)
+	for llBase[c] > uint32(n) {
+		c--
+	}
+	return c
+}
+
+func matchLengthCode(n int) uint8 {
+	c := uint8(len(mlBase) - 1)
+	for mlBase[c] > uint32(n) {
+		c--
+	}
+	return c
+}
+
+const (
+	xxh64Prime1 uint64 = 11400714785074694791
+	xxh64Prime2 uint64 = 14029467366897019727
+	xxh64Prime3 uint64 = 1609587929392839161
+	xxh64Prime4 uint64 = 9650029242287828579
+	xxh64Prime5 uint64 = 2870177450012600261
+)
+
+// xxh64 is the seedless XXH64 hash zstd takes its content checksum from.
+func xxh64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		p1 := xxh64Prime1 // the seeds wrap around, which constants can't
+		v1, v2, v3, v4 := p1+xxh64Prime2, xxh64Prime2, uint64(0), -p1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxh64Round(v1, binary.LittleEndian.Uint64(b[0:]))
+			v2 = xxh64Round(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = xxh64Round(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = xxh64Round(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		for _, v := range [4]uint64{v1, v2, v3, v4} {
+			h = (h^xxh64Round(0, v))*xxh64Prime1 + xxh64Prime4
+		}
+	} else {
+		h = xxh64Prime5
+	}
+	h += uint64(n)
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxh64Round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxh64Prime1 + xxh64Prime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxh64Prime1
+		h = bits.RotateLeft64(h, 23)*xxh64Prime2 + xxh64Prime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxh64Prime5
+		h = bits.RotateLeft64(h, 11) * xxh64Prime1
+	}
+	h ^= h >> 33
+	h *= xxh64Prime2
+	h ^= h >> 29
+	h *= xxh64Prime3
+	h ^= h >> 32
+	return h
+}
+
+func xxh64Round(acc, in uint64) uint64 {
+	return bits.RotateLeft64(acc+in*xxh64Prime2, 31) * xxh64Prime1
+}
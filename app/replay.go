@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/capture"
+	"github.com/codecrafters-io/kafka-starter-go/app/handlers"
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+)
+
+// runReplay implements "replay <capture> [host:port]". It sends a captured
+// connection's requests to a broker, localhost:9092 by default, in their
+// original order, one at a time. Each reply is read before the next request
+// goes out and compared with the captured response of the same correlation
+// id; a request with no captured response still has its reply read, unless
+// it is an acks=0 Produce. Timestamps, throttle times and generated IDs
+// legitimately differ, so differences are reported but don't fail the
+// replay.
+func runReplay(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: replay <capture file> [host:port]")
+		return 2
+	}
+	addr := "localhost:9092"
+	if len(args) == 2 {
+		addr = args[1]
+	}
+
+	records, err := capture.ReadFile(args[0])
+	if err != nil {
+		logger.Error("reading %s: %v", args[0], err)
+		return 1
+	}
+	captured := map[int32][]byte{}
+	for _, rec := range records {
+		if rec.Response && len(rec.Frame) >= 4 {
+			captured[int32(binary.BigEndian.Uint32(rec.Frame))] = rec.Frame
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		logger.Error("connecting to %s: %v", addr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	var sent, differ int
+	for _, rec := range records {
+		if rec.Response || len(rec.Frame) < 8 {
+			continue
+		}
+		apiKey := int16(binary.BigEndian.Uint16(rec.Frame[0:2]))
+		apiVersion := int16(binary.BigEndian.Uint16(rec.Frame[2:4]))
+		corrID := int32(binary.BigEndian.Uint32(rec.Frame[4:8]))
+
+		frame := binary.BigEndian.AppendUint32(nil, uint32(len(rec.Frame)))
+		if _, err := conn.Write(append(frame, rec.Frame...)); err != nil {
+			logger.Error("sending request %d: %v", corrID, err)
+			return 1
+		}
+		sent++
+
+		want, ok := captured[corrID]
+		if !ok && unanswered(rec.Frame) {
+			continue
+		}
+		// The broker answers whether or not a response was captured, and
+		// the reply has to be read for the next one to line up.
+		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		got, err := capture.ReadFrame(conn)
+		if err != nil {
+			logger.Error("reading response %d: %v", corrID, err)
+			return 1
+		}
+		switch {
+		case !ok:
+			logger.Warn("api_key=%d v%d correlation_id=%d: no captured response, got %d bytes", apiKey, apiVersion, corrID, len(got))
+		case bytes.Equal(got, want):
+			logger.Success("api_key=%d v%d correlation_id=%d: response matches", apiKey, apiVersion, corrID)
+		default:
+			differ++
+			logger.Warn("api_key=%d v%d correlation_id=%d: response differs (%d bytes, captured %d)", apiKey, apiVersion, corrID, len(got), len(want))
+		}
+	}
+	logger.Info("replayed %d requests to %s, %d responses differ", sent, addr, differ)
+	return 0
+}
+
+// unanswered reports whether a request frame is a Produce with acks=0,
+// which a broker sends no response to.
+func unanswered(frame []byte) bool {
+	if int16(binary.BigEndian.Uint16(frame[0:2])) != handlers.APIKeyProduce {
+		return false
+	}
+	flexible := int16(binary.BigEndian.Uint16(frame[2:4])) >= 9
+	br := parser.BytesReader{B: frame, Off: 8}
+	_, _ = parser.ReadNullableString(&br) // client_id
+	if flexible {
+		for n := int(parser.ReadUVarInt(&br)); n > 0 && !br.Short; n-- {
+			_ = parser.ReadUVarInt(&br)
+			if size := int(parser.ReadUVarInt(&br)); br.CanRead(size) {
+				br.Off += size
+			}
+		}
+		_, _ = parser.ReadCompactNullableString(&br) // transactional_id
+	} else {
+		_, _ = parser.ReadNullableString(&br)
+	}
+	acks := parser.ReadInt16(&br)
+	return !br.Short && acks == 0
+}
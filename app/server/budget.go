@@ -0,0 +1,66 @@
+package server
+
+import "sync"
+
+// produceBudget bounds the Produce request bytes held in memory across all
+// connections, like the Java broker's queued.max.request.bytes. A connection
+// that can't reserve room for its next Produce frame waits before reading it,
+// so the client's sends back up into TCP instead of into the broker's heap.
+var produceBudget = newByteBudget()
+
+type byteBudget struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newByteBudget() *byteBudget {
+	b := &byteBudget{}
+	b.freed = sync.NewCond(&b.mu)
+	return b
+}
+
+// SetProduceMemoryBytes caps the Produce payload bytes the broker holds in
+// memory at once. Zero or less leaves them unbounded.
+func SetProduceMemoryBytes(n int64) {
+	produceBudget.mu.Lock()
+	produceBudget.limit = n
+	produceBudget.mu.Unlock()
+	produceBudget.freed.Broadcast()
+}
+
+// ProduceBytesInFlight returns the Produce payload bytes currently reserved.
+func ProduceBytesInFlight() int64 {
+	produceBudget.mu.Lock()
+	defer produceBudget.mu.Unlock()
+	return produceBudget.used
+}
+
+// acquire blocks until n bytes fit in the budget, reserves them and returns
+// the amount reserved, which the caller hands back to release. A frame larger
+// than the whole budget reserves all of it, so it waits for the broker to
+// drain rather than forever.
+func (b *byteBudget) acquire(n int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit <= 0 {
+		return 0
+	}
+	n = min(n, b.limit)
+	for b.limit > 0 && b.used+n > b.limit {
+		b.freed.Wait()
+	}
+	b.used += n
+	return n
+}
+
+func (b *byteBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.freed.Broadcast()
+}
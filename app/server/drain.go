@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// conns tracks the open client connections, each mapped to whether it is in
+// the middle of a request, so Shutdown can close the idle ones at once and
+// give the busy ones time to answer.
+var (
+	connsMu  sync.Mutex
+	conns    = map[net.Conn]bool{}
+	connsWG  sync.WaitGroup
+	draining bool
+)
+
+// trackConn registers a new connection, refusing it once the broker is
+// shutting down.
+func trackConn(conn net.Conn) bool {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	if draining {
+		return false
+	}
+	conns[conn] = false
+	connsWG.Add(1)
+	return true
+}
+
+func untrackConn(conn net.Conn) {
+	connsMu.Lock()
+	delete(conns, conn)
+	connsMu.Unlock()
+	connsWG.Done()
+}
+
+// setBusy records whether conn is handling a request. It returns false when
+// a connection going idle should close because the broker is draining.
+func setBusy(conn net.Conn, busy bool) bool {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	conns[conn] = busy
+	return busy || !draining
+}
+
+// Shutdown stops the broker taking requests: idle connections are closed
+// straight away, and those mid-request close once they have written their
+// response. It waits up to timeout for them, then closes whatever is left,
+// and reports whether every connection drained in time. The caller stops the
+// listeners first.
+func Shutdown(timeout time.Duration) bool {
+	connsMu.Lock()
+	draining = true
+	for conn, busy := range conns {
+		if !busy {
+			conn.Close()
+		}
+	}
+	connsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		connsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+	}
+
+	connsMu.Lock()
+	for conn := range conns {
+		conn.Close()
+	}
+	connsMu.Unlock()
+	return false
+}
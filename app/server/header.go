@@ -0,0 +1,44 @@
+package server
+
+import "github.com/codecrafters-io/kafka-starter-go/app/parser"
+
+// requestHeaderVersion returns the request header version a request of
+// apiKey at apiVersion is sent with: v2, ending in tagged fields, from the
+// API's first flexible version, and v1 before it. Every header from v1
+// carries the client_id as a classic nullable string, even in v2. APIs the
+// broker doesn't serve are read as v1, which is enough to answer them.
+func requestHeaderVersion(apiKey, apiVersion int16) int {
+	if a, ok := apis[apiKey]; ok && apiVersion >= a.flexibleSince {
+		return 2
+	}
+	return 1
+}
+
+// skipHeader steps over the request header that follows api_key,
+// api_version and correlation_id, returning the client_id and whether the
+// header was complete.
+func skipHeader(br *parser.BytesReader, headerVersion int) (string, bool) {
+	n := int(parser.ReadInt16(br))
+	if br.Short || !br.CanRead(max(n, 0)) {
+		return "", false
+	}
+	var clientID string
+	if n > 0 {
+		clientID = string(br.B[br.Off : br.Off+n])
+		br.Off += n
+	}
+	if headerVersion < 2 {
+		return clientID, true
+	}
+
+	nTags := int(parser.ReadUVarInt(br))
+	for i := 0; i < nTags; i++ {
+		_ = parser.ReadUVarInt(br)
+		size := int(parser.ReadUVarInt(br))
+		if br.Short || !br.CanRead(size) {
+			return "", false
+		}
+		br.Off += size
+	}
+	return clientID, !br.Short
+}
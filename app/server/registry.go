@@ -0,0 +1,79 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/handlers"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+)
+
+// handlerFunc serves one request whose version is already known to be in
+// range; payload is the request body after the header.
+type handlerFunc func(corrID int32, apiVersion int16, payload []byte, c *session.Connection) ([]byte, *errors.KafkaError)
+
+// api is a registered API: the versions served, the first flexible one, and
+// its handler.
+type api struct {
+	minVersion    int16
+	maxVersion    int16
+	flexibleSince int16
+	handle        handlerFunc
+}
+
+// apis holds every API the broker serves. Dispatch, request header parsing
+// and the ApiVersions response are all driven from it, so an API is added in
+// one place.
+var apis = map[int16]api{}
+
+// register adds an API to the registry. Requests from flexibleSince on use
+// request header v2.
+func register(apiKey, minVersion, maxVersion, flexibleSince int16, handle handlerFunc) {
+	apis[apiKey] = api{minVersion: minVersion, maxVersion: maxVersion, flexibleSince: flexibleSince, handle: handle}
+}
+
+func init() {
+	register(handlers.APIKeyProduce, 3, 13, 9, handlers.HandleProduce)
+	register(handlers.APIKeyFetch, 4, 16, 12, handlers.HandleFetch)
+	register(handlers.APIKeyListOffsets, 6, 9, 6, handlers.HandleListOffsets)
+	register(handlers.APIKeyMetadata, 12, 13, 9, handlers.HandleMetadata)
+	register(handlers.APIKeyOffsetCommit, 8, 9, 8, withoutVersion(handlers.HandleOffsetCommit))
+	register(handlers.APIKeyOffsetFetch, 6, 9, 6, handlers.HandleOffsetFetch)
+	register(handlers.APIKeyFindCoordinator, 3, 4, 3, handlers.HandleFindCoordinator)
+	register(handlers.APIKeyApiVersions, 0, 4, 3, handleApiVersions)
+	register(handlers.APIKeyCreateTopics, 7, 7, 5, withoutVersion(handlers.HandleCreateTopicsV7))
+	register(handlers.APIKeyDeleteTopics, 4, 6, 4, handlers.HandleDeleteTopics)
+	register(handlers.APIKeyCreatePartitions, 2, 3, 2, withoutVersion(handlers.HandleCreatePartitions))
+	register(handlers.APIKeyDescribeTopicParts, 0, 0, 0, withoutVersion(handlers.HandleDescribeTopicPartitionsV0))
+}
+
+// withoutVersion adapts a handler that serves its versions alike.
+func withoutVersion(handle func(int32, []byte, *session.Connection) ([]byte, *errors.KafkaError)) handlerFunc {
+	return func(corrID int32, _ int16, payload []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+		return handle(corrID, payload, c)
+	}
+}
+
+// SupportedAPIs returns every API the broker serves with the versions it
+// accepts, in API key order. It is the matrix ApiVersions advertises on
+// client listeners, exposed so an embedding test harness can check a client
+// library's requirements against it without a round trip.
+func SupportedAPIs() []handlers.APIVersionRange {
+	ranges := make([]handlers.APIVersionRange, 0, len(apis))
+	for key, a := range apis {
+		ranges = append(ranges, handlers.APIVersionRange{APIKey: key, MinVersion: a.minVersion, MaxVersion: a.maxVersion})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].APIKey < ranges[j].APIKey })
+	return ranges
+}
+
+// handleApiVersions lists the registered APIs the client's listener serves.
+func handleApiVersions(corrID int32, apiVersion int16, _ []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	var ranges []handlers.APIVersionRange
+	for _, r := range SupportedAPIs() {
+		if servedOn(c.Listener, r.APIKey) {
+			ranges = append(ranges, r)
+		}
+	}
+	return handlers.BuildApiVersions(corrID, apiVersion, ranges), nil
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"github.com/codecrafters-io/kafka-starter-go/app/handlers"
+	"github.com/codecrafters-io/kafka-starter-go/app/listener"
+)
+
+// controllerAPIs are the KRaft quorum and registration APIs, which only
+// controller listeners carry.
+var controllerAPIs = map[int16]bool{
+	52: true, // Vote
+	53: true, // BeginQuorumEpoch
+	54: true, // EndQuorumEpoch
+	56: true, // AlterPartition
+	58: true, // Envelope
+	59: true, // FetchSnapshot
+	62: true, // BrokerRegistration
+	63: true, // BrokerHeartbeat
+	67: true, // AllocateProducerIds
+	70: true, // ControllerRegistration
+}
+
+// servedOn reports whether a request for apiKey may be sent on the named
+// listener. As in KRaft, a controller listener takes only ApiVersions and the
+// controller APIs, and client listeners refuse the controller APIs, so
+// neither kind of traffic can reach the other's handlers.
+func servedOn(listenerName string, apiKey int16) bool {
+	if listener.IsController(listenerName) {
+		return apiKey == handlers.APIKeyApiVersions || controllerAPIs[apiKey]
+	}
+	return !controllerAPIs[apiKey]
+}
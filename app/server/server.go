@@ -2,84 +2,175 @@ package server
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/binary"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"net"
+	"time"
 
+	"github.com/codecrafters-io/kafka-starter-go/app/capture"
 	"github.com/codecrafters-io/kafka-starter-go/app/errors"
 	"github.com/codecrafters-io/kafka-starter-go/app/handlers"
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
 	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/session"
+	"github.com/codecrafters-io/kafka-starter-go/app/stats"
 	"github.com/codecrafters-io/kafka-starter-go/app/topic"
 )
 
-const maxFrameSize = 16 << 20
+const (
+	maxFrameSize    = 16 << 20
+	hexDumpPrefix   = 32
+	statDisconnects = "client_disconnects"
+	statProtocolErr = "protocol_errors"
+)
+
+type protocolError struct {
+	msg  string
+	data []byte
+}
+
+func (e *protocolError) Error() string {
+	return e.msg
+}
 
-func HandleConnection(conn net.Conn, state *topic.BrokerState) {
+func HandleConnection(conn net.Conn, listenerName string, state *topic.BrokerState) {
 	defer conn.Close()
+	if !trackConn(conn) {
+		return
+	}
+	defer untrackConn(conn)
 	r := bufio.NewReader(conn)
+	c := session.New(conn, state)
+	c.Listener = listenerName
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			logger.Debug("%s: TLS handshake failed: %v", c, err)
+			return
+		}
+		// Like Kafka's default SSL principal builder, a verified client
+		// certificate names the principal by its subject DN.
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+			c.Principal = "User:" + certs[0].Subject.String()
+		}
+	}
+
+	capt, err := capture.Open(conn.RemoteAddr().String())
+	if err != nil {
+		logger.Warn("%s: not capturing connection: %v", c, err)
+	}
+	defer capt.Close()
 
 	for {
-		payload, corrID, apiKey, apiVersion, err := readRequest(r)
+		payload, reserved, corrID, apiKey, apiVersion, clientID, err := readRequest(r, capt)
 		if err != nil {
+			handleReadError(c, err)
 			return
 		}
+		setBusy(conn, true)
+		c.ClientID = clientID
+		c.APIVersions[apiKey] = apiVersion
+		stats.Default.RecordRequest(clientID, apiKey)
 
 		var resp []byte
-		switch apiKey {
-		case handlers.APIKeyProduce:
-			if apiVersion != 11 {
-				resp = handlers.BuildSimpleError(corrID, errors.ErrUnsupportedVersion)
-			} else {
-				resp = handlers.HandleProduceV11(corrID, payload, state)
-			}
-		case handlers.APIKeyFetch:
-			if apiVersion != 16 {
-				resp = handlers.BuildSimpleError(corrID, errors.ErrUnsupportedVersion)
-			} else {
-				resp = handlers.HandleFetchV16(corrID, payload, state)
-			}
-		case handlers.APIKeyApiVersions:
-			if apiVersion < 0 || apiVersion > 4 {
-				resp = handlers.BuildApiVersionsErrorOnly(corrID, errors.ErrUnsupportedVersion)
-			} else {
-				resp = handlers.BuildApiVersionsV4Body(corrID)
-			}
-		case handlers.APIKeyDescribeTopicParts:
-			if apiVersion != 0 {
-				resp = handlers.BuildSimpleError(corrID, errors.ErrUnsupportedVersion)
-			} else {
-				resp = handlers.HandleDescribeTopicPartitionsV0(corrID, payload, state)
-			}
-		default:
-			resp = frameResponse(parser.AppendInt32(nil, corrID), nil)
+		var kerr *errors.KafkaError
+		if servedOn(c.Listener, apiKey) {
+			resp, kerr = dispatch(corrID, apiKey, apiVersion, payload, c)
+		} else {
+			kerr = errors.Newf(errors.ErrUnsupportedVersion, "api_key %d is not served on listener %s", apiKey, c.Listener)
+		}
+		if kerr != nil {
+			logger.Debug("%s: request api_key=%d v%d failed: %v", c, apiKey, apiVersion, kerr)
+			resp = handlers.BuildErrorResponse(apiKey, apiVersion, corrID, kerr)
+		}
+		produceBudget.release(reserved)
+		if resp == nil {
+			// The response has nowhere to put the error, so the client
+			// learns of it as the Java broker's clients do: the
+			// connection closes.
+			logger.Warn("%s: closing connection after request api_key=%d v%d failed: %v", c, apiKey, apiVersion, kerr)
+			return
 		}
 
-		if writeAll(conn, resp) != nil {
+		capt.Response(resp[4:])
+		if writeAll(conn, resp) != nil || !setBusy(conn, false) {
 			return
 		}
+
+		if wait := time.Until(c.ThrottledUntil); wait > 0 {
+			time.Sleep(wait)
+		}
 	}
 }
 
-func readRequest(r *bufio.Reader) (body []byte, corrID int32, apiKey, apiVersion int16, err error) {
+// dispatch routes a request to its registered handler after checking the
+// version is one the API serves. An ApiVersions request of a version too new
+// is answered with just the error code, which every client version can read.
+// So are APIs the broker doesn't know, whose layout it can't write, with a v0
+// response header since that is all an unknown API can be assumed to use.
+func dispatch(corrID int32, apiKey, apiVersion int16, payload []byte, c *session.Connection) ([]byte, *errors.KafkaError) {
+	a, ok := apis[apiKey]
+	switch {
+	case !ok:
+		logger.Warn("%s: unknown api_key %d v%d", c, apiKey, apiVersion)
+		return handlers.BuildSimpleError(corrID, errors.ErrUnsupportedVersion), nil
+	case apiVersion < a.minVersion || apiVersion > a.maxVersion:
+		if apiKey == handlers.APIKeyApiVersions {
+			return handlers.BuildApiVersionsErrorOnly(corrID, errors.ErrUnsupportedVersion), nil
+		}
+		return nil, unsupportedVersion(apiKey, apiVersion)
+	}
+	return a.handle(corrID, apiVersion, payload, c)
+}
+
+func unsupportedVersion(apiKey, apiVersion int16) *errors.KafkaError {
+	return errors.Newf(errors.ErrUnsupportedVersion, "api_key %d does not support version %d", apiKey, apiVersion)
+}
+
+// readRequest reads the next request frame. A Produce frame is charged to
+// produceBudget before it is read; the reserved amount is returned for the
+// caller to release once the request is handled. The body starts after the
+// request header, whose version depends on the API and its version. The
+// whole frame is recorded to capt, if capturing.
+func readRequest(r *bufio.Reader, capt *capture.Writer) (body []byte, reserved int64, corrID int32, apiKey, apiVersion int16, clientID string, err error) {
+	// A connection closed part way through a frame is a malformed frame,
+	// not a clean disconnect, and is reported with the bytes it did send.
 	var sizeBuf [4]byte
-	if _, err = io.ReadFull(r, sizeBuf[:]); err != nil {
+	if n, rerr := io.ReadFull(r, sizeBuf[:]); rerr != nil {
+		err = truncatedFrame(rerr, sizeBuf[:n], 4)
 		return
 	}
 
 	msgSize := int32(binary.BigEndian.Uint32(sizeBuf[:]))
 	if msgSize <= 0 || msgSize > maxFrameSize {
-		err = fmt.Errorf("invalid message size")
+		err = &protocolError{msg: fmt.Sprintf("invalid message size %d", msgSize), data: append([]byte(nil), sizeBuf[:]...)}
 		return
 	}
 
+	if msgSize >= 8 {
+		if key, perr := r.Peek(2); perr == nil && int16(binary.BigEndian.Uint16(key)) == handlers.APIKeyProduce {
+			reserved = produceBudget.acquire(int64(msgSize))
+			defer func() {
+				if err != nil {
+					produceBudget.release(reserved)
+					reserved = 0
+				}
+			}()
+		}
+	}
+
 	payload := make([]byte, msgSize)
-	if _, err = io.ReadFull(r, payload); err != nil {
+	if n, rerr := io.ReadFull(r, payload); rerr != nil {
+		err = truncatedFrame(rerr, append(sizeBuf[:], payload[:n]...), 4+int(msgSize))
 		return
 	}
+	capt.Request(payload)
 
 	if len(payload) < 8 {
-		err = fmt.Errorf("payload too short")
+		err = &protocolError{msg: "payload too short", data: payload}
 		return
 	}
 
@@ -88,15 +179,9 @@ func readRequest(r *bufio.Reader) (body []byte, corrID int32, apiKey, apiVersion
 	corrID = int32(binary.BigEndian.Uint32(payload[4:8]))
 
 	hbr := parser.BytesReader{B: payload, Off: 8}
-	_, _ = parser.ReadCompactNullableString(&hbr)
-
-	tagBufLen := parser.ReadUVarInt(&hbr)
-	if tagBufLen > 0 && hbr.CanRead(int(tagBufLen)) {
-		hbr.Off += int(tagBufLen)
-	}
-
-	if hbr.Off > len(payload) {
-		err = fmt.Errorf("invalid header")
+	var ok bool
+	if clientID, ok = skipHeader(&hbr, requestHeaderVersion(apiKey, apiVersion)); !ok {
+		err = &protocolError{msg: "invalid header", data: payload}
 		return
 	}
 
@@ -104,6 +189,34 @@ func readRequest(r *bufio.Reader) (body []byte, corrID int32, apiKey, apiVersion
 	return
 }
 
+// truncatedFrame turns the end of the stream part way through a frame into a
+// protocol error carrying the bytes read; an end before the first byte, and
+// other errors, pass through.
+func truncatedFrame(err error, read []byte, want int) error {
+	if len(read) == 0 || !stderrors.Is(err, io.EOF) && !stderrors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	return &protocolError{msg: fmt.Sprintf("connection closed after %d of %d frame bytes", len(read), want), data: append([]byte(nil), read...)}
+}
+
+func handleReadError(c *session.Connection, err error) {
+	var perr *protocolError
+	switch {
+	case stderrors.Is(err, io.EOF):
+		stats.Default.Inc(statDisconnects)
+	case stderrors.As(err, &perr):
+		stats.Default.Inc(statProtocolErr)
+		prefix := perr.data
+		if len(prefix) > hexDumpPrefix {
+			prefix = prefix[:hexDumpPrefix]
+		}
+		logger.Warn("protocol error from %s: %s (first bytes: %x)", c, perr.msg, prefix)
+	default:
+		stats.Default.Inc(statDisconnects)
+		logger.Debug("connection from %s closed: %v", c, err)
+	}
+}
+
 func frameResponse(header, body []byte) []byte {
 	total := len(header) + len(body)
 	out := make([]byte, 0, 4+total)
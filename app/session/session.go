@@ -0,0 +1,71 @@
+package session
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/acl"
+	"github.com/codecrafters-io/kafka-starter-go/app/topic"
+)
+
+// Connection carries everything the broker knows about one client
+// connection. It is owned by the connection's goroutine, so handlers may read
+// and update it without locking.
+type Connection struct {
+	Conn     net.Conn
+	Listener string
+	State    *topic.BrokerState
+
+	Principal string
+	ClientID  string
+
+	SASLMechanism string
+	Authenticated bool
+
+	APIVersions   map[int16]int16
+	FetchSessions map[int32]*FetchSession
+
+	ThrottledUntil time.Time
+}
+
+type FetchSession struct {
+	ID         int32
+	Epoch      int32
+	Partitions map[string]int64
+}
+
+func New(conn net.Conn, state *topic.BrokerState) *Connection {
+	return &Connection{
+		Conn:          conn,
+		State:         state,
+		Principal:     acl.AnonymousPrincipal,
+		APIVersions:   make(map[int16]int16),
+		FetchSessions: make(map[int32]*FetchSession),
+	}
+}
+
+func (c *Connection) RemoteAddr() string {
+	if c.Conn == nil {
+		return ""
+	}
+	return c.Conn.RemoteAddr().String()
+}
+
+// String identifies the connection in log lines by peer address and the
+// client_id of its most recent request.
+func (c *Connection) String() string {
+	return fmt.Sprintf("%s (client_id=%q)", c.RemoteAddr(), c.ClientID)
+}
+
+// Throttle records a quota throttle so later requests on this connection can
+// be delayed until it has elapsed.
+func (c *Connection) Throttle(ms int32) {
+	if ms <= 0 {
+		return
+	}
+	until := time.Now().Add(time.Duration(ms) * time.Millisecond)
+	if until.After(c.ThrottledUntil) {
+		c.ThrottledUntil = until
+	}
+}
@@ -0,0 +1,398 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const windowSeconds = 10
+
+// maxClients bounds the client IDs tracked one by one, since clients pick
+// their own. Past it, a client idle for a whole rate window is forgotten to
+// make room, and when none is, newcomers share the otherClient entry.
+const (
+	maxClients  = 1000
+	otherClient = "other"
+)
+
+type rate struct {
+	buckets [windowSeconds]int64
+	stamps  [windowSeconds]int64
+}
+
+func (r *rate) add(now time.Time, n int64) {
+	sec := now.Unix()
+	i := sec % windowSeconds
+	if r.stamps[i] != sec {
+		r.stamps[i] = sec
+		r.buckets[i] = 0
+	}
+	r.buckets[i] += n
+}
+
+func (r *rate) perSecond(now time.Time) float64 {
+	sec := now.Unix()
+	var total int64
+	for i := range r.buckets {
+		if sec-r.stamps[i] < windowSeconds {
+			total += r.buckets[i]
+		}
+	}
+	return float64(total) / windowSeconds
+}
+
+type counters struct {
+	bytesIn  int64
+	bytesOut int64
+	inRate   rate
+	outRate  rate
+	lastSeen time.Time
+}
+
+type Snapshot struct {
+	Name     string
+	BytesIn  int64
+	BytesOut int64
+}
+
+// PartitionLog holds the gauges for one partition's log. The storage layer
+// sets them whenever it writes or first scans a log, so exporting them never
+// touches the filesystem.
+type PartitionLog struct {
+	SizeBytes      int64
+	LogStartOffset int64
+	LogEndOffset   int64
+	Segments       int
+	LastFlush      time.Time
+}
+
+// Sample is one series of a labeled gauge: its label names and values in
+// pairs, and its value.
+type Sample struct {
+	Labels []string
+	Value  int64
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+type requestKey struct {
+	clientID string
+	apiKey   int16
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	topics   map[string]*counters
+	clients  map[string]*counters
+	requests map[requestKey]int64
+	counters map[string]int64
+	logs     map[partitionKey]PartitionLog
+	gauges   map[string]func() int64
+	vecs     map[string]func() []Sample
+}
+
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		topics:   make(map[string]*counters),
+		clients:  make(map[string]*counters),
+		requests: make(map[requestKey]int64),
+		counters: make(map[string]int64),
+		logs:     make(map[partitionKey]PartitionLog),
+		gauges:   make(map[string]func() int64),
+		vecs:     make(map[string]func() []Sample),
+	}
+}
+
+func (r *Registry) RecordBytesIn(topicName, clientID string, n int) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range []*counters{lookup(r.topics, topicName), r.client(clientID, now)} {
+		c.bytesIn += int64(n)
+		c.inRate.add(now, int64(n))
+	}
+}
+
+func (r *Registry) RecordBytesOut(topicName, clientID string, n int) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range []*counters{lookup(r.topics, topicName), r.client(clientID, now)} {
+		c.bytesOut += int64(n)
+		c.outRate.add(now, int64(n))
+	}
+}
+
+func (r *Registry) RecordRequest(clientID string, apiKey int16) {
+	r.mu.Lock()
+	r.client(clientID, time.Now())
+	r.requests[requestKey{r.clientKey(clientID), apiKey}]++
+	r.mu.Unlock()
+}
+
+func (r *Registry) ClientRequests(clientID string, apiKey int16) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests[requestKey{r.clientKey(clientID), apiKey}]
+}
+
+func (r *Registry) Inc(name string) {
+	r.mu.Lock()
+	r.counters[name]++
+	r.mu.Unlock()
+}
+
+func (r *Registry) Counter(name string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name]
+}
+
+// SetGaugeFunc registers a gauge whose value is computed by fn each time
+// the metrics are read, for values owned by another package.
+func (r *Registry) SetGaugeFunc(name string, fn func() int64) {
+	r.mu.Lock()
+	r.gauges[name] = fn
+	r.mu.Unlock()
+}
+
+// SetGaugeVecFunc registers a gauge with one series per sample fn returns
+// each time the metrics are read.
+func (r *Registry) SetGaugeVecFunc(name string, fn func() []Sample) {
+	r.mu.Lock()
+	r.vecs[name] = fn
+	r.mu.Unlock()
+}
+
+func (r *Registry) SetPartitionLog(topicName string, partition int32, l PartitionLog) {
+	r.mu.Lock()
+	r.logs[partitionKey{topicName, partition}] = l
+	r.mu.Unlock()
+}
+
+func (r *Registry) RemovePartitionLog(topicName string, partition int32) {
+	r.mu.Lock()
+	delete(r.logs, partitionKey{topicName, partition})
+	r.mu.Unlock()
+}
+
+func (r *Registry) PartitionLog(topicName string, partition int32) (PartitionLog, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.logs[partitionKey{topicName, partition}]
+	return l, ok
+}
+
+func (r *Registry) ClientBytesInRate(clientID string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.clients[r.clientKey(clientID)]
+	if !ok {
+		return 0
+	}
+	return c.inRate.perSecond(time.Now())
+}
+
+func (r *Registry) ClientBytesOutRate(clientID string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.clients[r.clientKey(clientID)]
+	if !ok {
+		return 0
+	}
+	return c.outRate.perSecond(time.Now())
+}
+
+// client returns the counters clientID is recorded under, making room for
+// it or folding it into otherClient once maxClients are tracked. The caller
+// holds r.mu.
+func (r *Registry) client(clientID string, now time.Time) *counters {
+	if c, ok := r.clients[clientID]; ok {
+		c.lastSeen = now
+		return c
+	}
+	if len(r.clients) >= maxClients && !r.evictIdleClient(now) {
+		clientID = otherClient
+	}
+	c := lookup(r.clients, clientID)
+	c.lastSeen = now
+	return c
+}
+
+// clientKey is the name clientID's counters are kept under: its own when
+// tracked, otherClient when not. The caller holds r.mu.
+func (r *Registry) clientKey(clientID string) string {
+	if _, ok := r.clients[clientID]; ok {
+		return clientID
+	}
+	return otherClient
+}
+
+// evictIdleClient forgets the longest idle client, with its request counts,
+// if it has been idle for a whole rate window and so has no rate left to
+// enforce a quota with. The caller holds r.mu.
+func (r *Registry) evictIdleClient(now time.Time) bool {
+	var oldest string
+	var oldestSeen time.Time
+	found := false
+	for id, c := range r.clients {
+		if id != otherClient && (!found || c.lastSeen.Before(oldestSeen)) {
+			oldest, oldestSeen, found = id, c.lastSeen, true
+		}
+	}
+	if !found || now.Sub(oldestSeen) < windowSeconds*time.Second {
+		return false
+	}
+	delete(r.clients, oldest)
+	for k := range r.requests {
+		if k.clientID == oldest {
+			delete(r.requests, k)
+		}
+	}
+	return true
+}
+
+func (r *Registry) Topics() []Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return snapshot(r.topics)
+}
+
+func (r *Registry) Clients() []Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return snapshot(r.clients)
+}
+
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.counters))
+	for name := range r.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "kafka_%s_total %d\n", name, r.counters[name])
+	}
+	keys := make([]requestKey, 0, len(r.requests))
+	for k := range r.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].clientID != keys[j].clientID {
+			return keys[i].clientID < keys[j].clientID
+		}
+		return keys[i].apiKey < keys[j].apiKey
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "kafka_client_requests_total{client_id=%q,api_key=\"%d\"} %d\n", k.clientID, k.apiKey, r.requests[k])
+	}
+	logKeys := make([]partitionKey, 0, len(r.logs))
+	for k := range r.logs {
+		logKeys = append(logKeys, k)
+	}
+	sort.Slice(logKeys, func(i, j int) bool {
+		if logKeys[i].topic != logKeys[j].topic {
+			return logKeys[i].topic < logKeys[j].topic
+		}
+		return logKeys[i].partition < logKeys[j].partition
+	})
+	for _, k := range logKeys {
+		l := r.logs[k]
+		labels := fmt.Sprintf("{topic=%q,partition=\"%d\"}", k.topic, k.partition)
+		fmt.Fprintf(w, "kafka_log_size_bytes%s %d\n", labels, l.SizeBytes)
+		fmt.Fprintf(w, "kafka_log_start_offset%s %d\n", labels, l.LogStartOffset)
+		fmt.Fprintf(w, "kafka_log_end_offset%s %d\n", labels, l.LogEndOffset)
+		fmt.Fprintf(w, "kafka_log_segments%s %d\n", labels, l.Segments)
+		if !l.LastFlush.IsZero() {
+			fmt.Fprintf(w, "kafka_log_last_flush_time_ms%s %d\n", labels, l.LastFlush.UnixMilli())
+		}
+	}
+	r.mu.Unlock()
+
+	r.mu.Lock()
+	gaugeNames := make([]string, 0, len(r.gauges))
+	for name := range r.gauges {
+		gaugeNames = append(gaugeNames, name)
+	}
+	gauges := make([]func() int64, len(gaugeNames))
+	sort.Strings(gaugeNames)
+	for i, name := range gaugeNames {
+		gauges[i] = r.gauges[name]
+	}
+	vecNames := make([]string, 0, len(r.vecs))
+	for name := range r.vecs {
+		vecNames = append(vecNames, name)
+	}
+	vecs := make([]func() []Sample, len(vecNames))
+	sort.Strings(vecNames)
+	for i, name := range vecNames {
+		vecs[i] = r.vecs[name]
+	}
+	r.mu.Unlock()
+	// Gauge funcs run without the registry lock so they can take their
+	// own package's locks.
+	for i, name := range gaugeNames {
+		fmt.Fprintf(w, "kafka_%s %d\n", name, gauges[i]())
+	}
+	for i, name := range vecNames {
+		for _, s := range vecs[i]() {
+			var labels strings.Builder
+			for j := 0; j+1 < len(s.Labels); j += 2 {
+				if j > 0 {
+					labels.WriteByte(',')
+				}
+				fmt.Fprintf(&labels, "%s=%q", s.Labels[j], s.Labels[j+1])
+			}
+			fmt.Fprintf(w, "kafka_%s{%s} %d\n", name, labels.String(), s.Value)
+		}
+	}
+
+	for _, s := range r.Topics() {
+		fmt.Fprintf(w, "kafka_topic_bytes_in_total{topic=%q} %d\n", s.Name, s.BytesIn)
+		fmt.Fprintf(w, "kafka_topic_bytes_out_total{topic=%q} %d\n", s.Name, s.BytesOut)
+	}
+	for _, s := range r.Clients() {
+		fmt.Fprintf(w, "kafka_client_bytes_in_total{client_id=%q} %d\n", s.Name, s.BytesIn)
+		fmt.Fprintf(w, "kafka_client_bytes_out_total{client_id=%q} %d\n", s.Name, s.BytesOut)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+func lookup(m map[string]*counters, key string) *counters {
+	c, ok := m[key]
+	if !ok {
+		c = &counters{}
+		m[key] = c
+	}
+	return c
+}
+
+func snapshot(m map[string]*counters) []Snapshot {
+	out := make([]Snapshot, 0, len(m))
+	for name, c := range m {
+		out = append(out, Snapshot{Name: name, BytesIn: c.bytesIn, BytesOut: c.bytesOut})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,172 @@
+package topic
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+)
+
+// defaultMinInsyncReplicas is the broker-wide min.insync.replicas, used by
+// topics that do not override it.
+var defaultMinInsyncReplicas atomic.Int32
+
+// defaultTimestampBefore and defaultTimestampAfter are the broker-wide
+// log.message.timestamp.before.max.ms and after.max.ms. Both default to
+// unbounded, as in the Java broker.
+var (
+	defaultTimestampBefore atomic.Int64
+	defaultTimestampAfter  atomic.Int64
+)
+
+func init() {
+	defaultMinInsyncReplicas.Store(1)
+	defaultTimestampBefore.Store(math.MaxInt64)
+	defaultTimestampAfter.Store(math.MaxInt64)
+}
+
+func SetDefaultMinInsyncReplicas(n int32) {
+	if n > 0 {
+		defaultMinInsyncReplicas.Store(n)
+	}
+}
+
+// SetDefaultTimestampWindow sets how far before and after the broker's clock
+// a CreateTime timestamp may be for topics that do not override it. Negative
+// values are ignored.
+func SetDefaultTimestampWindow(before, after int64) {
+	if before >= 0 {
+		defaultTimestampBefore.Store(before)
+	}
+	if after >= 0 {
+		defaultTimestampAfter.Store(after)
+	}
+}
+
+// LoadBrokerDefaults applies the broker-wide topic defaults
+// (min.insync.replicas, log.segment.bytes and the log.message.timestamp.*
+// window) from the broker's properties.
+func LoadBrokerDefaults(props map[string]string) {
+	intProp := func(key string) int64 {
+		n, err := strconv.ParseInt(props[key], 10, 64)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+	SetDefaultMinInsyncReplicas(int32(intProp("min.insync.replicas")))
+	partition.SetSegmentBytes(intProp("log.segment.bytes"))
+	difference := intProp("log.message.timestamp.difference.max.ms")
+	before := intProp("log.message.timestamp.before.max.ms")
+	after := intProp("log.message.timestamp.after.max.ms")
+	// The older difference setting bounds both sides unless they are set.
+	if before < 0 {
+		before = difference
+	}
+	if after < 0 {
+		after = difference
+	}
+	SetDefaultTimestampWindow(before, after)
+}
+
+type configSpec struct {
+	validate func(string) error
+}
+
+var topicConfigs = map[string]configSpec{
+	"cleanup.policy":                      {validate: oneOf("delete", "compact", "delete,compact", "compact,delete")},
+	"compression.type":                    {validate: oneOf("uncompressed", "gzip", "snappy", "lz4", "zstd", "producer")},
+	"max.message.bytes":                   {validate: intAtLeast(0)},
+	"min.insync.replicas":                 {validate: intAtLeast(1)},
+	"retention.bytes":                     {validate: intAtLeast(-1)},
+	"retention.ms":                        {validate: intAtLeast(-1)},
+	"segment.bytes":                       {validate: intAtLeast(14)},
+	"segment.ms":                          {validate: intAtLeast(1)},
+	"message.timestamp.type":              {validate: oneOf("CreateTime", "LogAppendTime")},
+	"message.timestamp.difference.max.ms": {validate: intAtLeast(0)},
+	"message.timestamp.before.max.ms":     {validate: intAtLeast(0)},
+	"message.timestamp.after.max.ms":      {validate: intAtLeast(0)},
+
+	// Fault injection, not in the Java broker: see Meta.ProduceFault.
+	"fault.produce.latency.ms": {validate: intAtLeast(0)},
+	"fault.produce.error.rate": {validate: fraction},
+	"fault.produce.error":      {validate: errorName},
+}
+
+func ValidateConfig(name string, value *string) error {
+	spec, ok := topicConfigs[name]
+	if !ok {
+		return fmt.Errorf("unknown topic config name: %s", name)
+	}
+	if value == nil {
+		return nil
+	}
+	if err := spec.validate(*value); err != nil {
+		return fmt.Errorf("invalid value %q for configuration %s: %v", *value, name, err)
+	}
+	return nil
+}
+
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("topic name is illegal, it can't be empty")
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("topic name cannot be \".\" or \"..\"")
+	}
+	if len(name) > 249 {
+		return fmt.Errorf("topic name is illegal, it can't be longer than 249 characters")
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return fmt.Errorf("topic name %q is illegal, it contains a character other than ASCII alphanumerics, '.', '_' and '-'", name)
+		}
+	}
+	return nil
+}
+
+func oneOf(allowed ...string) func(string) error {
+	return func(v string) error {
+		for _, a := range allowed {
+			if strings.EqualFold(v, a) {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+func intAtLeast(min int64) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		if n < min {
+			return fmt.Errorf("must be at least %d", min)
+		}
+		return nil
+	}
+}
+
+func fraction(v string) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("not a number")
+	}
+	if f < 0 || f > 1 {
+		return fmt.Errorf("must be between 0 and 1")
+	}
+	return nil
+}
+
+func errorName(v string) error {
+	if code, ok := errors.CodeByName(strings.ToUpper(v)); !ok || code == errors.ErrNone {
+		return fmt.Errorf("not a Kafka error name")
+	}
+	return nil
+}
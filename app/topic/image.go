@@ -0,0 +1,113 @@
+package topic
+
+import (
+	"sort"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/logger"
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
+)
+
+// MetadataImage is the cluster state built by replaying the metadata log.
+// It is fed whole batches, either from the local log at startup or from a
+// controller in observer mode, and installed into the broker state once a
+// consistent prefix of the log has been applied.
+type MetadataImage struct {
+	topics     map[string]Meta
+	partitions map[[16]byte]map[int32]PartitionMeta
+	brokers    map[int32]Broker
+
+	// installed tracks the topics the last Install put into the broker
+	// state, so a topic removed from the log is removed from the state too
+	// while topics the broker created itself are left alone.
+	installed map[string]bool
+}
+
+func NewMetadataImage() *MetadataImage {
+	return &MetadataImage{
+		topics:     make(map[string]Meta),
+		partitions: make(map[[16]byte]map[int32]PartitionMeta),
+		brokers:    make(map[int32]Broker),
+		installed:  make(map[string]bool),
+	}
+}
+
+// ApplyBatches replays the record batches in data whose records all lie
+// below committedEnd. It stops at the first batch that doesn't, and returns
+// the offset after the last batch it considered along with that batch's
+// leader epoch, which is where the next read of the log should resume.
+func (m *MetadataImage) ApplyBatches(data []byte, committedEnd int64) (int64, int32, bool) {
+	var next int64 = -1
+	var epoch int32 = -1
+	for offset := 0; offset < len(data); {
+		batch, size, err := recordbatch.Decode(data[offset:])
+		if size == 0 {
+			if err != nil && offset+12 <= len(data) {
+				logger.Warn("stopping metadata log replay at byte %d: %v", offset, err)
+			}
+			break
+		}
+		end := batch.LastOffset() + 1
+		if end > committedEnd {
+			break
+		}
+		offset += size
+		next, epoch = end, batch.LeaderEpoch
+		if err != nil {
+			logger.Warn("skipping metadata batch at byte %d: %v", offset-size, err)
+			if len(batch.Records) == 0 {
+				continue
+			}
+		}
+
+		// Control batches only carry transaction markers, never metadata.
+		if recordbatch.IsControl(batch.Attributes) {
+			continue
+		}
+		for _, rec := range batch.Records {
+			applyRecordValue(rec.Value, m.topics, m.partitions, m.brokers)
+		}
+	}
+	return next, epoch, next >= 0
+}
+
+// Install replaces the image's topics and the registered brokers in state.
+func (m *MetadataImage) Install(state *BrokerState) {
+	state.Lock()
+	defer state.Unlock()
+
+	for name := range m.installed {
+		if _, ok := m.topics[name]; !ok {
+			state.RemoveTopic(name)
+		}
+	}
+	m.installed = make(map[string]bool, len(m.topics))
+	for name, meta := range m.topics {
+		if parts, ok := m.partitions[meta.ID]; ok && len(parts) > 0 {
+			// Copied, since later batches keep updating the image's map
+			// while handlers read the installed one.
+			meta.Partitions = len(parts)
+			meta.PartitionInfo = make(map[int32]PartitionMeta, len(parts))
+			for idx, pm := range parts {
+				meta.PartitionInfo[idx] = pm
+			}
+		} else if meta.Partitions == 0 {
+			meta.Partitions = 1
+		}
+		// Topic configs aren't in the log, so those the broker was given
+		// when it created the topic are kept.
+		if cur, ok := state.Topics[name]; ok && cur.ID == meta.ID {
+			meta.Configs, meta.Internal = cur.Configs, cur.Internal
+		}
+		state.SetTopic(name, meta)
+		m.installed[name] = true
+	}
+
+	// A fresh slice, so a caller still holding the old one never sees it
+	// rewritten.
+	brokers := make([]Broker, 0, len(m.brokers))
+	for _, b := range m.brokers {
+		brokers = append(brokers, b)
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].ID < brokers[j].ID })
+	state.Brokers = brokers
+}
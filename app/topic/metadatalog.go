@@ -0,0 +1,134 @@
+package topic
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
+)
+
+const (
+	registerBrokerRecordType = 0
+	topicRecordType          = 2
+	partitionRecordType      = 3
+	removeTopicRecordType    = 9
+)
+
+// MetadataTopic is the KRaft metadata log, kept as partition 0 of this topic.
+const MetadataTopic = "__cluster_metadata"
+
+var metadataLogMu sync.Mutex
+
+// TopicRecordValue encodes a version 0 TopicRecord.
+func TopicRecordValue(name string, id [16]byte) []byte {
+	b := metadataRecordHeader(topicRecordType, 0)
+	b = parser.AppendCompactString(b, name)
+	b = append(b, id[:]...)
+	return parser.AppendUVarInt(b, 0)
+}
+
+// PartitionRecordValue encodes a version 0 PartitionRecord for one partition
+// of the topic with the given ID.
+func PartitionRecordValue(topicID [16]byte, pm PartitionMeta) []byte {
+	b := metadataRecordHeader(partitionRecordType, 0)
+	b = parser.AppendInt32(b, pm.Index)
+	b = append(b, topicID[:]...)
+	b = parser.AppendCompactInt32Array(b, pm.Replicas)
+	b = parser.AppendCompactInt32Array(b, pm.ISR)
+	b = parser.AppendCompactInt32Array(b, nil)
+	b = parser.AppendCompactInt32Array(b, nil)
+	b = parser.AppendInt32(b, pm.Leader)
+	b = parser.AppendInt32(b, max(pm.LeaderEpoch, 0))
+	b = parser.AppendInt32(b, 0)
+	return parser.AppendUVarInt(b, 0)
+}
+
+// RemoveTopicRecordValue encodes a version 0 RemoveTopicRecord.
+func RemoveTopicRecordValue(id [16]byte) []byte {
+	b := metadataRecordHeader(removeTopicRecordType, 0)
+	b = append(b, id[:]...)
+	return parser.AppendUVarInt(b, 0)
+}
+
+func metadataRecordHeader(recordType, version uint32) []byte {
+	b := parser.AppendUVarInt(nil, 1)
+	b = parser.AppendUVarInt(b, recordType)
+	return parser.AppendUVarInt(b, version)
+}
+
+// AppendMetadataRecords commits values to the cluster metadata log as a
+// single record batch, so a reader replaying the log sees either all of them
+// or none; a TopicRecord is never visible without its PartitionRecords. The
+// log is only appended to when it already exists: a broker bootstrapped from
+// server.properties has no metadata log, and creating one would hide the
+// properties topics on the next start.
+func AppendMetadataRecords(values ...[]byte) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	metadataLogMu.Lock()
+	defer metadataLogMu.Unlock()
+
+	path := partition.ActiveSegmentPath(MetadataTopic, 0)
+	if path == "" {
+		return nil
+	}
+
+	nextOffset, epoch := metadataLogEnd(partition.ReadRecords(MetadataTopic, 0))
+	batch := encodeMetadataBatch(nextOffset, epoch, time.Now().UnixMilli(), values)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(batch); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// metadataLogEnd returns the offset the next batch should start at and the
+// leader epoch of the last complete batch in data.
+func metadataLogEnd(data []byte) (int64, int32) {
+	var next int64
+	var epoch int32
+	for off := 0; off < len(data); {
+		h, size, err := recordbatch.ParseHeader(data[off:])
+		if size == 0 {
+			break
+		}
+		if err == nil {
+			next = h.LastOffset() + 1
+			epoch = h.LeaderEpoch
+		}
+		off += size
+	}
+	return next, epoch
+}
+
+func encodeMetadataBatch(baseOffset int64, epoch int32, timestamp int64, values [][]byte) []byte {
+	batch := recordbatch.Batch{
+		Header: recordbatch.Header{
+			BaseOffset:     baseOffset,
+			LeaderEpoch:    epoch,
+			FirstTimestamp: timestamp,
+			MaxTimestamp:   timestamp,
+			ProducerID:     -1,
+			ProducerEpoch:  -1,
+			BaseSequence:   -1,
+		},
+	}
+	for i, v := range values {
+		batch.Records = append(batch.Records, recordbatch.Record{OffsetDelta: int32(i), Value: v})
+	}
+	return batch.Encode()
+}
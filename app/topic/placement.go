@@ -0,0 +1,136 @@
+package topic
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Broker struct {
+	ID     int32
+	Rack   string
+	Fenced bool
+	// Endpoints are the listeners the broker registered, by listener name.
+	Endpoints map[string]BrokerEndpoint
+}
+
+type BrokerEndpoint struct {
+	Host string
+	Port int32
+}
+
+// AssignReplicas spreads numPartitions partitions over the brokers using
+// Kafka's round-robin placement. When every broker advertises a rack the
+// broker list is interleaved by rack first, so each partition's replicas land
+// on as many distinct racks as possible.
+func AssignReplicas(brokers []Broker, numPartitions int32, replicationFactor int16) (map[int32][]int32, error) {
+	n := len(brokers)
+	if int(replicationFactor) > n {
+		return nil, fmt.Errorf("Unable to replicate the partition %d time(s): The target replication factor of %d cannot be reached because only %d broker(s) are registered.", replicationFactor, replicationFactor, n)
+	}
+
+	arranged, racks := arrangeByRack(brokers)
+	numRacks := len(racks)
+	if numRacks == 0 {
+		numRacks = 1
+	}
+
+	assignments := make(map[int32][]int32, numPartitions)
+	shift := 0
+	for p := 0; p < int(numPartitions); p++ {
+		if p > 0 && p%n == 0 {
+			shift++
+		}
+		first := p % n
+		leader := arranged[first]
+
+		replicas := []int32{leader.ID}
+		usedRacks := map[string]bool{leader.Rack: true}
+		usedBrokers := map[int32]bool{leader.ID: true}
+
+		k := 0
+		for len(replicas) < int(replicationFactor) {
+			b := arranged[replicaIndex(first, shift*numRacks, k, n)]
+			k++
+			if usedBrokers[b.ID] {
+				continue
+			}
+			if usedRacks[b.Rack] && len(usedRacks) < numRacks {
+				continue
+			}
+			replicas = append(replicas, b.ID)
+			usedRacks[b.Rack] = true
+			usedBrokers[b.ID] = true
+		}
+		assignments[int32(p)] = replicas
+	}
+	return assignments, nil
+}
+
+func ValidateAssignments(brokers []Broker, assignments map[int32][]int32) error {
+	known := make(map[int32]bool, len(brokers))
+	for _, b := range brokers {
+		known[b.ID] = true
+	}
+
+	replicationFactor := -1
+	for p := int32(0); p < int32(len(assignments)); p++ {
+		replicas, ok := assignments[p]
+		if !ok {
+			return fmt.Errorf("Partitions should be consecutive and start from 0, missing partition %d.", p)
+		}
+		if len(replicas) == 0 {
+			return fmt.Errorf("The manual partition assignment includes an empty replica list.")
+		}
+		if replicationFactor != -1 && len(replicas) != replicationFactor {
+			return fmt.Errorf("All partitions should have the same number of replicas.")
+		}
+		replicationFactor = len(replicas)
+
+		seen := make(map[int32]bool, len(replicas))
+		for _, id := range replicas {
+			if seen[id] {
+				return fmt.Errorf("The manual partition assignment includes the broker %d more than once.", id)
+			}
+			seen[id] = true
+			if !known[id] {
+				return fmt.Errorf("The manual partition assignment includes broker %d, but no such broker is registered.", id)
+			}
+		}
+	}
+	return nil
+}
+
+func replicaIndex(first, shift, k, n int) int {
+	if n == 1 {
+		return 0
+	}
+	return (first + 1 + (shift+k)%(n-1)) % n
+}
+
+func arrangeByRack(brokers []Broker) ([]Broker, []string) {
+	sorted := append([]Broker(nil), brokers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	byRack := map[string][]Broker{}
+	var racks []string
+	for _, b := range sorted {
+		if b.Rack == "" {
+			return sorted, nil
+		}
+		if _, ok := byRack[b.Rack]; !ok {
+			racks = append(racks, b.Rack)
+		}
+		byRack[b.Rack] = append(byRack[b.Rack], b)
+	}
+	sort.Strings(racks)
+
+	arranged := make([]Broker, 0, len(sorted))
+	for i := 0; len(arranged) < len(sorted); i++ {
+		for _, rack := range racks {
+			if i < len(byRack[rack]) {
+				arranged = append(arranged, byRack[rack][i])
+			}
+		}
+	}
+	return arranged, racks
+}
@@ -1,50 +1,269 @@
 package topic
 
 import (
-	"encoding/binary"
 	"fmt"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/codecrafters-io/kafka-starter-go/app/errors"
 	"github.com/codecrafters-io/kafka-starter-go/app/logger"
 	"github.com/codecrafters-io/kafka-starter-go/app/parser"
+	"github.com/codecrafters-io/kafka-starter-go/app/partition"
+	"github.com/codecrafters-io/kafka-starter-go/app/recordbatch"
 )
 
+// DefaultNodeID is the broker's node.id unless configured otherwise.
+const DefaultNodeID = int32(1)
+
+var nodeID atomic.Int32
+
+func init() {
+	nodeID.Store(DefaultNodeID)
+}
+
+// NodeID is this broker's node.id, the leader and sole replica of every
+// partition the metadata doesn't place elsewhere.
+func NodeID() int32 {
+	return nodeID.Load()
+}
+
+// SetNodeID sets this broker's node.id. Negative values are ignored.
+func SetNodeID(id int32) {
+	if id >= 0 {
+		nodeID.Store(id)
+	}
+}
+
 type Meta struct {
-	ID         [16]byte
-	Partitions int
+	ID            [16]byte
+	Partitions    int
+	PartitionInfo map[int32]PartitionMeta
+	Configs       map[string]string
+	Internal      bool
+}
+
+type PartitionMeta struct {
+	Index           int32
+	Leader          int32
+	LeaderEpoch     int32
+	Replicas        []int32
+	ISR             []int32
+	ELR             []int32
+	LastKnownELR    []int32
+	OfflineReplicas []int32
+}
+
+func (m Meta) Partition(idx int32) PartitionMeta {
+	if p, ok := m.PartitionInfo[idx]; ok {
+		return p
+	}
+	return PartitionMeta{
+		Index:       idx,
+		Leader:      NodeID(),
+		LeaderEpoch: -1,
+		Replicas:    []int32{NodeID()},
+		ISR:         []int32{NodeID()},
+	}
+}
+
+// MinInsyncReplicas is the topic's min.insync.replicas, falling back to the
+// broker-wide default when the topic does not override it.
+func (m Meta) MinInsyncReplicas() int {
+	if v, err := strconv.Atoi(m.Configs["min.insync.replicas"]); err == nil && v > 0 {
+		return v
+	}
+	return int(defaultMinInsyncReplicas.Load())
+}
+
+// LogAppendTime reports whether the broker stamps the topic's records with
+// their append time instead of keeping the producer's timestamps.
+func (m Meta) LogAppendTime() bool {
+	return strings.EqualFold(m.Configs["message.timestamp.type"], "LogAppendTime")
 }
 
+// CompressionCodec returns the record batch codec the topic's
+// compression.type asks the broker to store batches in; ok is false when it
+// keeps whatever the producer sent.
+func (m Meta) CompressionCodec() (codec int, ok bool) {
+	switch m.Configs["compression.type"] {
+	case "uncompressed":
+		return recordbatch.CompressionNone, true
+	case "gzip":
+		return recordbatch.CompressionGzip, true
+	case "snappy":
+		return recordbatch.CompressionSnappy, true
+	case "lz4":
+		return recordbatch.CompressionLZ4, true
+	case "zstd":
+		return recordbatch.CompressionZstd, true
+	}
+	return 0, false
+}
+
+// ProduceFault returns the faults injected into appends to the topic, set
+// through its fault.produce.* configs to see how producers' timeouts and
+// retries cope with a slow or failing broker: each append is delayed by
+// latency, then fails with errorCode with probability errorRate instead of
+// being written. The error defaults to NOT_ENOUGH_REPLICAS, which producers
+// retry.
+func (m Meta) ProduceFault() (latency time.Duration, errorRate float64, errorCode int16) {
+	if ms, err := strconv.ParseInt(m.Configs["fault.produce.latency.ms"], 10, 64); err == nil && ms > 0 {
+		latency = time.Duration(ms) * time.Millisecond
+	}
+	if r, err := strconv.ParseFloat(m.Configs["fault.produce.error.rate"], 64); err == nil && r > 0 {
+		errorRate = min(r, 1)
+	}
+	errorCode = errors.ErrNotEnoughReplicas
+	if code, ok := errors.CodeByName(strings.ToUpper(m.Configs["fault.produce.error"])); ok && code != errors.ErrNone {
+		errorCode = code
+	}
+	return latency, errorRate, errorCode
+}
+
+// TimestampWindow returns how far before and after the broker's clock a
+// CreateTime timestamp produced to the topic may be, in milliseconds. The
+// topic's message.timestamp.before.max.ms and after.max.ms win over the older
+// message.timestamp.difference.max.ms, which bounds both; unset sides fall
+// back to the broker defaults. LogAppendTime topics ignore producer
+// timestamps, so their window is unbounded.
+func (m Meta) TimestampWindow() (before, after int64) {
+	if m.LogAppendTime() {
+		return math.MaxInt64, math.MaxInt64
+	}
+	before, after = defaultTimestampBefore.Load(), defaultTimestampAfter.Load()
+	if v, err := strconv.ParseInt(m.Configs["message.timestamp.difference.max.ms"], 10, 64); err == nil && v >= 0 {
+		before, after = v, v
+	}
+	if v, err := strconv.ParseInt(m.Configs["message.timestamp.before.max.ms"], 10, 64); err == nil && v >= 0 {
+		before = v
+	}
+	if v, err := strconv.ParseInt(m.Configs["message.timestamp.after.max.ms"], 10, 64); err == nil && v >= 0 {
+		after = v
+	}
+	return before, after
+}
+
+// BrokerState is the cluster as the broker currently sees it, read under the
+// read lock and changed under the write lock.
 type BrokerState struct {
-	Topics map[string]Meta
+	sync.RWMutex
+	// Topics is only written through SetTopic and RemoveTopic, which keep
+	// the topic ID index in step with it.
+	Topics  map[string]Meta
+	Brokers []Broker
+
+	// names indexes Topics by topic ID.
+	names map[[16]byte]string
 }
 
-func LoadFromProperties(path string, state *BrokerState) error {
-	logPath := "/tmp/kraft-combined-logs/__cluster_metadata-0/00000000000000000000.log"
-	if err := loadClusterMetadata(logPath, state); err == nil {
-		return nil
+// SetTopic adds or replaces a topic. The caller must hold the write lock.
+func (s *BrokerState) SetTopic(name string, meta Meta) {
+	if s.Topics == nil {
+		s.Topics = map[string]Meta{}
 	}
+	if s.names == nil {
+		s.names = map[[16]byte]string{}
+	}
+	if old, ok := s.Topics[name]; ok && s.names[old.ID] == name {
+		delete(s.names, old.ID)
+	}
+	s.Topics[name] = meta
+	s.names[meta.ID] = name
+}
 
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return err
+// RemoveTopic forgets a topic. The caller must hold the write lock.
+func (s *BrokerState) RemoveTopic(name string) {
+	if old, ok := s.Topics[name]; ok && s.names[old.ID] == name {
+		delete(s.names, old.ID)
 	}
+	delete(s.Topics, name)
+}
 
-	tmp := map[string]Meta{}
-	for _, line := range strings.Split(string(b), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") || !strings.HasPrefix(line, "topic.") {
-			continue
+// TopicByID finds a topic by its ID. The caller must hold the read lock.
+func (s *BrokerState) TopicByID(id [16]byte) (string, Meta, bool) {
+	name, ok := s.names[id]
+	if !ok {
+		return "", Meta{}, false
+	}
+	meta, ok := s.Topics[name]
+	return name, meta, ok
+}
+
+// LiveBrokers returns the registered brokers that are not fenced, or this
+// broker alone when none are known. It takes the read lock itself.
+func (s *BrokerState) LiveBrokers() []Broker {
+	s.RLock()
+	defer s.RUnlock()
+	live := make([]Broker, 0, len(s.Brokers))
+	for _, b := range s.Brokers {
+		if !b.Fenced {
+			live = append(live, b)
+		}
+	}
+	if len(live) == 0 {
+		live = append(live, Broker{ID: NodeID()})
+	}
+	return live
+}
+
+// ReplicationHealth counts the partitions whose ISR is smaller than their
+// replica set, and those whose ISR is below the topic's min.insync.replicas
+// (1 unless overridden).
+func (s *BrokerState) ReplicationHealth() (underReplicated, underMinISR int) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, meta := range s.Topics {
+		minISR := meta.MinInsyncReplicas()
+		for idx := int32(0); idx < int32(max(meta.Partitions, 1)); idx++ {
+			p := meta.Partition(idx)
+			if len(p.ISR) < len(p.Replicas) {
+				underReplicated++
+			}
+			if len(p.ISR) < minISR {
+				underMinISR++
+			}
 		}
+	}
+	return underReplicated, underMinISR
+}
 
-		kv := strings.SplitN(line, "=", 2)
-		if len(kv) != 2 {
+// NewTopicID returns a random topic ID not used by any known topic. Like the
+// Java broker it never hands out the nil UUID or one whose base64 form starts
+// with '-', which command-line tools would mistake for a flag. The caller
+// must hold the state lock.
+func (s *BrokerState) NewTopicID() [16]byte {
+	for {
+		id := parser.RandomUUID()
+		if id == parser.NilUUID() || id[0]>>2 == 62 {
 			continue
 		}
+		if _, inUse := s.names[id]; !inUse {
+			return id
+		}
+	}
+}
+
+// LoadFromProperties loads the topics from the cluster metadata log or,
+// without one, from the broker's topic.<name>.id and
+// topic.<name>.partitions properties, a shorthand for seeding topics without
+// a controller.
+func LoadFromProperties(props map[string]string, state *BrokerState) {
+	if err := loadClusterMetadata(state); err == nil {
+		return
+	}
 
-		key, val := kv[0], strings.TrimSpace(kv[1])
-		rest := strings.TrimPrefix(key, "topic.")
+	tmp := map[string]Meta{}
+	for key, val := range props {
+		rest, ok := strings.CutPrefix(key, "topic.")
+		if !ok {
+			continue
+		}
 		dot := strings.LastIndex(rest, ".")
 		if dot <= 0 || dot == len(rest)-1 {
 			continue
@@ -70,99 +289,93 @@ func LoadFromProperties(path string, state *BrokerState) error {
 		tmp[name] = meta
 	}
 
+	// Topics listed without a partition count take it from the directories
+	// already on disk.
+	onDisk := scanLogDirs(partition.LogDir)
+	for name, meta := range tmp {
+		if parts := onDisk[name]; meta.Partitions == 0 && len(parts) > 0 {
+			meta.Partitions = int(parts[len(parts)-1]) + 1
+			tmp[name] = meta
+		}
+	}
+
+	var unnamed []string
 	for k, v := range tmp {
-		state.Topics[k] = v
+		if v.ID == parser.NilUUID() {
+			unnamed = append(unnamed, k)
+			continue
+		}
+		state.SetTopic(k, v)
+	}
+	// Topics listed without an id get a generated one, in name order so a
+	// seeded generator hands out the same IDs on every run.
+	sort.Strings(unnamed)
+	for _, k := range unnamed {
+		v := tmp[k]
+		v.ID = state.NewTopicID()
+		state.SetTopic(k, v)
 	}
-	return nil
 }
 
-func loadClusterMetadata(logPath string, state *BrokerState) error {
-	data, err := os.ReadFile(logPath)
+// scanLogDirs lists the partition directories under root by topic. Entries
+// that are not directories, or whose names don't split into a legal topic name
+// and partition index, are skipped rather than guessed at.
+func scanLogDirs(root string) map[string][]int32 {
+	entries, err := os.ReadDir(root)
 	if err != nil {
-		return err
+		return nil
 	}
-
-	topicRecords := make(map[string]Meta)
-	partitionCounts := make(map[[16]byte]int)
-
-	offset := 0
-	for offset < len(data)-20 {
-		if offset+12 > len(data) {
-			break
-		}
-
-		batchLen := int(binary.BigEndian.Uint32(data[offset+8 : offset+12]))
-		if batchLen <= 0 || batchLen > len(data)-offset-12 {
-			offset++
+	out := map[string][]int32{}
+	for _, e := range entries {
+		if !e.IsDir() {
 			continue
 		}
-
-		batchEnd := offset + 12 + batchLen
-		if batchEnd > len(data) {
-			break
+		name, idx, ok := partition.ParseDir(e.Name())
+		if !ok {
+			continue
 		}
-
-		recordsStart := offset + 61
-		if recordsStart >= batchEnd {
-			offset = batchEnd
+		if err := ValidateName(name); err != nil {
+			logger.Warn("skipping log directory %s: %v", e.Name(), err)
 			continue
 		}
-
-		parseRecords(data[recordsStart:batchEnd], topicRecords, partitionCounts)
-		offset = batchEnd
+		out[name] = append(out[name], idx)
+	}
+	for _, parts := range out {
+		sort.Slice(parts, func(i, j int) bool { return parts[i] < parts[j] })
 	}
+	return out
+}
 
-	for name, meta := range topicRecords {
-		if count, ok := partitionCounts[meta.ID]; ok && count > 0 {
-			meta.Partitions = count
-		} else if meta.Partitions == 0 {
-			meta.Partitions = 1
-		}
-		state.Topics[name] = meta
+func loadClusterMetadata(state *BrokerState) error {
+	data := partition.ReadRecords(MetadataTopic, 0)
+	if len(data) == 0 {
+		return fmt.Errorf("no cluster metadata log")
 	}
 
+	image, _ := ReplayMetadataLog(data)
+	image.Install(state)
+
 	if len(state.Topics) == 0 {
 		return fmt.Errorf("no topics found in cluster metadata")
 	}
 	return nil
 }
 
-func parseRecords(data []byte, topicRecords map[string]Meta, partitionCounts map[[16]byte]int) {
-	br := parser.BytesReader{B: data}
-
-	for br.Off < len(data)-5 {
-		recLen := int(parser.ReadVarInt(&br))
-		if recLen <= 0 || br.Off+recLen > len(data) {
-			break
-		}
-
-		recStart := br.Off
-		_ = parser.ReadInt8(&br)
-		_ = parser.ReadVarInt(&br)
-		_ = parser.ReadVarInt(&br)
-		keyLen := int(parser.ReadVarInt(&br))
-
-		if keyLen > 0 && br.CanRead(keyLen) {
-			br.Off += keyLen
-		}
-
-		valueLen := int(parser.ReadVarInt(&br))
-
-		if valueLen > 0 && br.CanRead(valueLen) {
-			valueData := br.B[br.Off : br.Off+valueLen]
-
-			if len(valueData) >= 2 {
-				recordType := valueData[1]
-
-				if recordType == 2 {
-					parseTopicRecordValue(valueData, topicRecords)
-				} else if recordType == 3 {
-					parsePartitionRecordValue(valueData, partitionCounts)
-				}
-			}
-		}
-
-		br.Off = recStart + recLen
+// applyRecordValue applies one metadata record value, dispatching on the
+// record type that follows its frame version.
+func applyRecordValue(value []byte, topicRecords map[string]Meta, partitions map[[16]byte]map[int32]PartitionMeta, brokers map[int32]Broker) {
+	if len(value) < 2 {
+		return
+	}
+	switch value[1] {
+	case topicRecordType:
+		parseTopicRecordValue(value, topicRecords)
+	case partitionRecordType:
+		parsePartitionRecordValue(value, partitions)
+	case removeTopicRecordType:
+		parseRemoveTopicRecordValue(value, topicRecords, partitions)
+	case registerBrokerRecordType:
+		parseRegisterBrokerRecordValue(value, brokers)
 	}
 }
 
@@ -197,7 +410,27 @@ func parseTopicRecordValue(data []byte, topicRecords map[string]Meta) {
 	topicRecords[name] = meta
 }
 
-func parsePartitionRecordValue(data []byte, partitionCounts map[[16]byte]int) {
+func parseRemoveTopicRecordValue(data []byte, topicRecords map[string]Meta, partitions map[[16]byte]map[int32]PartitionMeta) {
+	br := parser.BytesReader{B: data}
+	_ = parser.ReadInt8(&br)
+	_ = parser.ReadInt8(&br)
+	_ = parser.ReadUVarInt(&br)
+
+	if !br.CanRead(16) {
+		return
+	}
+	var topicID [16]byte
+	copy(topicID[:], br.B[br.Off:br.Off+16])
+
+	for name, meta := range topicRecords {
+		if meta.ID == topicID {
+			delete(topicRecords, name)
+		}
+	}
+	delete(partitions, topicID)
+}
+
+func parsePartitionRecordValue(data []byte, partitions map[[16]byte]map[int32]PartitionMeta) {
 	if len(data) < 20 {
 		return
 	}
@@ -205,18 +438,113 @@ func parsePartitionRecordValue(data []byte, partitionCounts map[[16]byte]int) {
 	br := parser.BytesReader{B: data}
 	_ = parser.ReadInt8(&br)
 	_ = parser.ReadInt8(&br)
-	_ = parser.ReadUVarInt(&br)
+	version := parser.ReadUVarInt(&br)
 
 	if !br.CanRead(4) {
 		return
 	}
-	_ = parser.ReadInt32(&br)
+	pm := PartitionMeta{Index: parser.ReadInt32(&br)}
 
 	if !br.CanRead(16) {
 		return
 	}
 	var topicID [16]byte
 	copy(topicID[:], br.B[br.Off:br.Off+16])
+	br.Off += 16
+
+	pm.Replicas = parser.ReadCompactInt32Array(&br)
+	pm.ISR = parser.ReadCompactInt32Array(&br)
+	_ = parser.ReadCompactInt32Array(&br)
+	_ = parser.ReadCompactInt32Array(&br)
+	pm.Leader = parser.ReadInt32(&br)
+	pm.LeaderEpoch = parser.ReadInt32(&br)
+	_ = parser.ReadInt32(&br)
+
+	if version >= 1 {
+		nDirs := int(parser.ReadUVarInt(&br)) - 1
+		if nDirs > 0 && br.CanRead(16*nDirs) {
+			br.Off += 16 * nDirs
+		}
+	}
+
+	nTags := int(parser.ReadUVarInt(&br))
+	for i := 0; i < nTags && br.Off < len(data); i++ {
+		tag := parser.ReadUVarInt(&br)
+		size := int(parser.ReadUVarInt(&br))
+		if !br.CanRead(size) {
+			break
+		}
+		field := parser.BytesReader{B: br.B[br.Off : br.Off+size]}
+		switch tag {
+		case 1:
+			pm.ELR = parser.ReadCompactInt32Array(&field)
+		case 2:
+			pm.LastKnownELR = parser.ReadCompactInt32Array(&field)
+		}
+		br.Off += size
+	}
+
+	if partitions[topicID] == nil {
+		partitions[topicID] = make(map[int32]PartitionMeta)
+	}
+	partitions[topicID][pm.Index] = pm
+}
+
+func parseRegisterBrokerRecordValue(data []byte, brokers map[int32]Broker) {
+	br := parser.BytesReader{B: data}
+	_ = parser.ReadInt8(&br)
+	_ = parser.ReadInt8(&br)
+	version := parser.ReadUVarInt(&br)
 
-	partitionCounts[topicID]++
+	if !br.CanRead(4) {
+		return
+	}
+	b := Broker{ID: parser.ReadInt32(&br)}
+
+	if version >= 2 {
+		_ = parser.ReadInt8(&br)
+	}
+	if !br.CanRead(16 + 8) {
+		return
+	}
+	br.Off += 16
+	_ = parser.ReadInt64(&br)
+
+	nEndpoints := int(parser.ReadUVarInt(&br)) - 1
+	if nEndpoints > 0 {
+		b.Endpoints = map[string]BrokerEndpoint{}
+	}
+	for i := 0; i < nEndpoints && br.Off < len(br.B); i++ {
+		name := parser.ReadCompactString(&br)
+		host := parser.ReadCompactString(&br)
+		port := int32(uint16(parser.ReadInt16(&br)))
+		_ = parser.ReadInt16(&br)
+		skipTaggedFields(&br)
+		b.Endpoints[name] = BrokerEndpoint{Host: host, Port: port}
+	}
+
+	nFeatures := int(parser.ReadUVarInt(&br)) - 1
+	for i := 0; i < nFeatures; i++ {
+		_ = parser.ReadCompactString(&br)
+		_ = parser.ReadInt16(&br)
+		_ = parser.ReadInt16(&br)
+		skipTaggedFields(&br)
+	}
+
+	b.Rack, _ = parser.ReadCompactNullableString(&br)
+	b.Fenced = parser.ReadInt8(&br) != 0
+	brokers[b.ID] = b
+}
+
+func skipTaggedFields(br *parser.BytesReader) {
+	nTags := int(parser.ReadUVarInt(br))
+	for i := 0; i < nTags && br.Off < len(br.B); i++ {
+		_ = parser.ReadUVarInt(br)
+		size := int(parser.ReadUVarInt(br))
+		if !br.CanRead(size) {
+			br.Off = len(br.B)
+			return
+		}
+		br.Off += size
+	}
 }